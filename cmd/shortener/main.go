@@ -3,23 +3,44 @@ package main
 import (
 	"compress/gzip"
 	"context"
+	"crypto/tls"
+	"errors"
 	"log"
+	"net"
+	"net/http"
+	"os"
 	"os/signal"
+	"sync"
 	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
-	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 
 	"github.com/maxzhirnov/urlshort/internal/auth"
+	"github.com/maxzhirnov/urlshort/internal/certs"
 	"github.com/maxzhirnov/urlshort/internal/configs"
 	"github.com/maxzhirnov/urlshort/internal/handlers"
+	"github.com/maxzhirnov/urlshort/internal/health"
 	"github.com/maxzhirnov/urlshort/internal/logging"
+	"github.com/maxzhirnov/urlshort/internal/metrics"
 	"github.com/maxzhirnov/urlshort/internal/middleware"
+	"github.com/maxzhirnov/urlshort/internal/ratelimit"
 	"github.com/maxzhirnov/urlshort/internal/repositories"
 	"github.com/maxzhirnov/urlshort/internal/services"
+	"github.com/maxzhirnov/urlshort/internal/tracing"
 )
 
+const certReloadInterval = 30 * time.Second
+
+// writableStorage is implemented by storages keeping data in local files
+type writableStorage interface {
+	CheckWritable() error
+}
+
 func main() {
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
@@ -28,59 +49,374 @@ func main() {
 		log.Println(".env file parsing failed")
 	}
 
-	logger := logging.NewLogrusLogger(logrus.DebugLevel)
+	// Пока конфигурация не загружена, используется логгер с настройками по умолчанию
+	bootLogger, err := logging.New(logging.Config{})
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	config, err := configs.NewFromFlags(logger)
+	config, err := configs.NewFromFlags(bootLogger)
 	if err != nil {
-		logger.Fatal(err.Error())
+		bootLogger.Fatal(err.Error())
+	}
+	if config.PrintConfig() {
+		if err := config.WriteEffective(os.Stdout); err != nil {
+			bootLogger.Fatal(err.Error())
+		}
+		return
+	}
+
+	logger, err := logging.New(config.Logging())
+	if err != nil {
+		bootLogger.Fatal(err.Error())
 	}
 
 	logger.Info("Starting app",
+		"config_file", config.ConfigFile(),
+		"log_backend", config.Logging().Backend,
 		"server_addr", config.ServerAddr(),
 		"base_url", config.BaseURL(),
 		"file_storage_path", config.FileStoragePath())
 
-	storage, err := repositories.NewStorage(*config)
+	shutdownTracing, err := tracing.Setup(ctx, config.Tracing())
+	if err != nil {
+		logger.Fatal(err.Error())
+	}
+
+	storage, err := repositories.NewStorage(*config, logger)
 	if err != nil {
 		logger.Fatal(err.Error())
 	}
-	defer storage.Close()
 
 	if err := storage.Bootstrap(); err != nil {
 		logger.Fatal(err.Error())
 	}
 
-	repo := repositories.NewRepository(logger, storage)
+	appMetrics := metrics.New()
+	backend := repositories.StorageBackend(*config)
+	repo := repositories.NewRepository(logger, repositories.NewTracedStorage(storage, backend)).
+		WithMetrics(appMetrics, backend)
 	idGenerator := services.NewRandIDGenerator(8)
-	service := services.NewURLShortener(repo, idGenerator, logger)
-	authService := auth.NewAuth()
-	handler := handlers.NewHandlers(service, config.BaseURL(), authService, logger)
+	service := services.NewURLShortener(repo, idGenerator, logger).WithMetrics(appMetrics)
+	service.SetDeletionInterval(config.DeletionInterval())
+	appMetrics.RegisterDeletionQueue(service.DeletionQueueDepth)
+	authService, err := newAuth(*config)
+	if err != nil {
+		logger.Fatal(err.Error())
+	}
+	authService.WithCookieConfig(config.Cookie())
+	if authService.UsesDefaultSecret() && !config.DevMode() {
+		logger.Fatal("refusing to start with default JWT secret, provide JWT_KEYS, keyring file or run with -dev flag")
+	}
+
+	readiness := health.NewChecker().
+		Add("storage", func(context.Context) error { return service.Ping() }).
+		Add("deletion_worker", service.CheckDeletionWorker)
+	if s, ok := storage.(writableStorage); ok {
+		readiness.Add("file_storage", func(context.Context) error { return s.CheckWritable() })
+	}
+
+	handler := handlers.NewHandlers(service, config.BaseURL(), authService, logger).
+		WithAdmins(config.AdminIDs(), config.AdminToken()).
+		WithMetrics(appMetrics).
+		WithHealth(readiness).
+		WithStorageBackend(backend).
+		WithRedirectDefaults(config.RedirectDefaults())
+	if config.ShouldUseOIDC() {
+		oidcProvider, err := auth.NewOIDCProvider(ctx, auth.OIDCConfig{
+			IssuerURL:    config.OIDCIssuer(),
+			ClientID:     config.OIDCClientID(),
+			ClientSecret: config.OIDCClientSecret(),
+			RedirectURL:  config.OIDCRedirectURL(),
+		})
+		if err != nil {
+			logger.Fatal(err.Error())
+		}
+		handler.WithOIDC(oidcProvider)
+	}
+
+	if err := service.SyncRevokedSessions(ctx, authService.Revoke); err != nil {
+		logger.Fatal(err.Error())
+	}
 
-	go service.ProcessLinkDeletion(ctx)
+	// Воркеры, которые пишут в хранилище, останавливаются только после HTTP сервера,
+	// пока идут запросы в очередь удаления могут добавляться новые ссылки
+	workersCtx, stopWorkers := context.WithCancel(context.Background())
+	var workers sync.WaitGroup
+	runWorker(&workers, func() { service.ProcessLinkDeletion(workersCtx) })
+	runWorker(&workers, func() { service.ProcessSessionCleanup(workersCtx) })
+	// Сессии могут отзываться другими инстансами с тем же хранилищем
+	runWorker(&workers, func() { service.ProcessRevocationSync(workersCtx, authService.Revoke) })
+	go authService.RevocationList().RunCleanup(ctx, time.Hour)
 
 	gin.SetMode(gin.ReleaseMode)
-	r := gin.Default()
-	r.Use(middleware.LoggingMiddleware(logger))
-	gzipWriter, err := gzip.NewWriterLevel(nil, gzip.BestSpeed)
+	r, err := newEngine(config.TrustedProxies())
 	if err != nil {
-		log.Fatal(err)
+		logger.Fatal("error setting trusted proxies", "error", err)
 	}
-	r.Use(middleware.GzipMiddleware(gzipWriter, logger))
+	r.Use(gin.Recovery())
+	// Span сервера открывается первым, чтобы в него попало время всей цепочки middleware
+	r.Use(otelgin.Middleware(tracing.ServiceName, otelgin.WithFilter(func(r *http.Request) bool {
+		switch r.URL.Path {
+		case "/metrics", "/healthz", "/readyz":
+			return false
+		}
+		return true
+	})))
+	r.Use(middleware.RequestIDMiddleware())
+	r.Use(middleware.LoggingMiddleware(logger))
+	r.Use(middleware.MetricsMiddleware(appMetrics))
+	r.Use(middleware.GzipMiddleware(middleware.GzipOptions{
+		Level:               gzip.BestSpeed,
+		MinSize:             config.CompressMinSize(),
+		MaxDecompressedSize: config.MaxBodySize(),
+	}, logger))
 	r.Use(middleware.TokenIssuerMiddleware(authService, logger))
 
-	r.GET("/:ID", handler.HandleRedirect)
-	r.POST("/", handler.HandleCreate)
-	r.GET("/ping", handler.HandlePing)
+	createLimit, createLimiters := newRateLimit(ctx, config.CreateRateLimits(), authService, logger)
+	redirectLimit, redirectLimiters := newRateLimit(ctx, config.RedirectRateLimits(), authService, logger)
+	go reloadOnSighup(ctx, config, runtimeSettings{
+		logger:         logger,
+		auth:           authService,
+		service:        service,
+		createLimits:   createLimiters,
+		redirectLimits: redirectLimiters,
+	})
+
+	var metricsServer *http.Server
+	var metricsHandler http.Handler
+	if config.MetricsAddr() != "" {
+		metricsServer = newMetricsServer(config.MetricsAddr(), appMetrics.Handler())
+	} else {
+		metricsHandler = appMetrics.Handler()
+	}
+
+	registerRoutes(r, handler, routeMiddlewares{
+		createLimit:   createLimit,
+		redirectLimit: redirectLimit,
+		trustedSubnet: middleware.TrustedSubnetMiddleware(config.TrustedSubnets()),
+		metrics:       metricsHandler,
+	})
+
+	tlsConfig, err := newTLSConfig(ctx, *config, logger)
+	if err != nil {
+		logger.Fatal(err.Error())
+	}
+
+	server := &http.Server{
+		Addr:              config.ServerAddr(),
+		Handler:           r,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	servers := []*http.Server{server}
+	if metricsServer != nil {
+		servers = append(servers, metricsServer)
+	}
+
+	serverErr := make(chan error, len(servers)+1)
+	for _, srv := range servers {
+		srv.TLSConfig = tlsConfig
+		logger.Info("Starting server", "addr", srv.Addr, "tls", tlsConfig != nil)
+		go func(srv *http.Server) {
+			var err error
+			if srv.TLSConfig != nil {
+				err = srv.ListenAndServeTLS("", "")
+			} else {
+				err = srv.ListenAndServe()
+			}
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				serverErr <- err
+			}
+		}(srv)
+	}
+
+	var grpcServer *grpc.Server
+	if config.GRPCAddr() != "" {
+		var opts []grpc.ServerOption
+		if tlsConfig != nil {
+			opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+		}
+		grpcServer = handlers.NewGRPCServer(service, config.BaseURL(), authService, logger).
+			WithRateLimit(createLimiters.perUser, createLimiters.perIP).
+			Register(opts...)
+		listener, err := net.Listen("tcp", config.GRPCAddr())
+		if err != nil {
+			logger.Fatal("Couldn't start gRPC server", "error", err)
+		}
+		logger.Info("Starting gRPC server", "addr", config.GRPCAddr(), "tls", tlsConfig != nil)
+		go func() {
+			if err := grpcServer.Serve(listener); err != nil {
+				serverErr <- err
+			}
+		}()
+	}
+
+	exitCode := 0
+	select {
+	case <-ctx.Done():
+		logger.Info("Shutting down", "timeout", config.ShutdownTimeout())
+	case err := <-serverErr:
+		logger.Error("Couldn't start server", "error", err)
+		exitCode = 1
+	}
+	// Повторный сигнал завершит процесс сразу, не дожидаясь окончания shutdown
+	stop()
+
+	// Пока балансировщик не увидел проваленный readiness, запросы еще приходят
+	readiness.SetDraining()
+	if delay := config.DrainDelay(); delay > 0 && exitCode == 0 {
+		logger.Info("Draining", "delay", delay)
+		time.Sleep(delay)
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout())
+	defer cancel()
+
+	// Порядок важен: сначала дожидаемся запросов, потом сбрасываем очередь удалений,
+	// затем закрываем хранилище и отправляем оставшиеся трейсы
+	for _, srv := range servers {
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			logger.Error("Server shutdown error", "addr", srv.Addr, "error", err)
+		}
+	}
+	if grpcServer != nil {
+		stopGRPC(shutdownCtx, grpcServer)
+	}
+	stopWorkers()
+	if !waitWorkers(shutdownCtx, &workers) {
+		logger.Error("Background workers didn't finish in time")
+	}
+	if err := storage.Close(); err != nil {
+		logger.Error("Couldn't close storage", "error", err)
+	}
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		logger.Error("Couldn't flush traces", "error", err)
+	}
+	logger.Info("Stopped")
+	_ = logger.Sync()
+
+	if exitCode != 0 {
+		os.Exit(exitCode)
+	}
+}
+
+// newTLSConfig returns nil when TLS is disabled. Certificate files are watched
+// and reloaded after rotation without restart.
+func newTLSConfig(ctx context.Context, config configs.Config, logger logging.Logger) (*tls.Config, error) {
+	switch {
+	case config.DevTLS():
+		host, _, err := net.SplitHostPort(config.ServerAddr())
+		if err != nil {
+			return nil, err
+		}
+		cert, err := certs.SelfSigned(host)
+		if err != nil {
+			return nil, err
+		}
+		logger.Warn("serving HTTPS with self-signed certificate, don't use it in production")
+		return &tls.Config{
+			MinVersion:   tls.VersionTLS12,
+			Certificates: []tls.Certificate{cert},
+		}, nil
+	case config.TLSCertFile() != "":
+		reloader, err := certs.NewReloader(config.TLSCertFile(), config.TLSKeyFile())
+		if err != nil {
+			return nil, err
+		}
+		go reloader.Watch(ctx, certReloadInterval, logger)
+		return &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: reloader.GetCertificate,
+		}, nil
+	default:
+		return nil, nil
+	}
+}
 
-	api := r.Group("/api")
-	api.POST("/shorten", handler.HandleShorten)
-	api.POST("/shorten/batch", handler.HandleShortenBatch)
-	api.GET("/user/urls", handler.HandleShowAllUsersURLs)
-	api.DELETE("/user/urls", handler.HandleDeleteURL)
+// newMetricsServer serves /metrics on a separate address so it can be kept away from public traffic
+func newMetricsServer(addr string, h http.Handler) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", h)
+	return &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+}
+
+// stopGRPC waits for in-flight calls until ctx is done, then closes remaining connections
+func stopGRPC(ctx context.Context, s *grpc.Server) {
+	done := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		s.Stop()
+	}
+}
+
+func runWorker(wg *sync.WaitGroup, fn func()) {
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		fn()
+	}()
+}
+
+// waitWorkers returns false if ctx is done before all workers finished
+func waitWorkers(ctx context.Context, wg *sync.WaitGroup) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
 
-	if err := r.Run(config.ServerAddr()); err != nil {
-		logger.Fatal("Couldn't start server",
-			"error", err,
-		)
+// newRateLimit creates limiters for a group of endpoints, idle buckets are evicted every minute
+func newRateLimit(ctx context.Context, limits configs.RateLimits, a *auth.Auth, logger logging.Logger) (gin.HandlerFunc, rateLimiters) {
+	rl := rateLimiters{
+		perUser: ratelimit.NewLimiter(limits.PerUser),
+		perIP:   ratelimit.NewLimiter(limits.PerIP),
+	}
+	go rl.perUser.RunCleanup(ctx, time.Minute)
+	go rl.perIP.RunCleanup(ctx, time.Minute)
+	return middleware.RateLimitMiddleware(rl.perUser, rl.perIP, a, logger), rl
+}
+
+func newAuth(config configs.Config) (*auth.Auth, error) {
+	if config.JWTKeysFile() != "" {
+		return auth.NewAuthFromFile(config.JWTKeysFile())
+	}
+	kr, err := config.JWTKeyring()
+	if err != nil {
+		return nil, err
+	}
+	if kr != nil {
+		return auth.NewAuthWithKeyring(kr), nil
+	}
+	return auth.NewAuth(), nil
+}
+
+// newEngine returns engine which takes client IP from X-Forwarded-For only when the connection
+// comes from one of trusted proxies, otherwise anyone could bypass per-IP rate limits
+func newEngine(trustedProxies []*net.IPNet) (*gin.Engine, error) {
+	// gin.Default не используется, его логгер дублировал бы access лог
+	r := gin.New()
+	var proxies []string
+	for _, p := range trustedProxies {
+		proxies = append(proxies, p.String())
+	}
+	if err := r.SetTrustedProxies(proxies); err != nil {
+		return nil, err
 	}
+	return r, nil
 }
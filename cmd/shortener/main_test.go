@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/maxzhirnov/urlshort/internal/auth"
+	"github.com/maxzhirnov/urlshort/internal/logging"
+	"github.com/maxzhirnov/urlshort/internal/middleware"
+	"github.com/maxzhirnov/urlshort/internal/ratelimit"
+)
+
+func Test_newEngine_RateLimitIgnoresSpoofedForwardedFor(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	_, proxy, err := net.ParseCIDR("10.0.0.0/8")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		proxies    []*net.IPNet
+		remoteAddr string
+		wantLimit  bool
+	}{
+		{name: "no trusted proxies", remoteAddr: "203.0.113.5:1234", wantLimit: true},
+		{name: "client isn't a trusted proxy", proxies: []*net.IPNet{proxy}, remoteAddr: "203.0.113.5:1234", wantLimit: true},
+		{name: "trusted proxy forwards different clients", proxies: []*net.IPNet{proxy}, remoteAddr: "10.0.0.1:1234", wantLimit: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := newEngine(tt.proxies)
+			require.NoError(t, err)
+			perIP := ratelimit.NewLimiter(ratelimit.Limit{Burst: 2, Per: time.Minute})
+			perUser := ratelimit.NewLimiter(ratelimit.Limit{Burst: 100, Per: time.Minute})
+			limit := middleware.RateLimitMiddleware(perUser, perIP, auth.NewAuth(), logging.NewLogrusLogger(0))
+			r.POST("/api/shorten/batch", limit, func(c *gin.Context) { c.Status(http.StatusCreated) })
+
+			limited := false
+			for _, xff := range []string{"198.51.100.1", "198.51.100.2", "198.51.100.3", "198.51.100.4"} {
+				req := httptest.NewRequest(http.MethodPost, "/api/shorten/batch", nil)
+				req.RemoteAddr = tt.remoteAddr
+				req.Header.Set("X-Forwarded-For", xff)
+				w := httptest.NewRecorder()
+				r.ServeHTTP(w, req)
+				limited = limited || w.Code == http.StatusTooManyRequests
+			}
+			assert.Equal(t, tt.wantLimit, limited)
+		})
+	}
+}
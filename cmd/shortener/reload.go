@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/maxzhirnov/urlshort/internal/auth"
+	"github.com/maxzhirnov/urlshort/internal/configs"
+	"github.com/maxzhirnov/urlshort/internal/logging"
+	"github.com/maxzhirnov/urlshort/internal/ratelimit"
+	"github.com/maxzhirnov/urlshort/internal/services"
+)
+
+// rateLimiters are limiters of a group of endpoints
+type rateLimiters struct {
+	perUser *ratelimit.Limiter
+	perIP   *ratelimit.Limiter
+}
+
+func (rl rateLimiters) set(limits configs.RateLimits) {
+	rl.perUser.SetLimit(limits.PerUser)
+	rl.perIP.SetLimit(limits.PerIP)
+}
+
+// runtimeSettings are components whose settings can be changed without restart
+type runtimeSettings struct {
+	logger         logging.Logger
+	auth           *auth.Auth
+	service        *services.URLShortener
+	createLimits   rateLimiters
+	redirectLimits rateLimiters
+}
+
+func (rs runtimeSettings) apply(config *configs.Config) {
+	if err := rs.logger.SetLevel(config.LogLevel()); err != nil {
+		rs.logger.Error("couldn't set log level", "error", err)
+	}
+	if kr, err := config.JWTKeyring(); err != nil {
+		rs.logger.Error("couldn't set JWT keys", "error", err)
+	} else if kr != nil {
+		rs.auth.SetKeyring(kr)
+	}
+	rs.auth.WithCookieConfig(config.Cookie())
+	rs.service.SetDeletionInterval(config.DeletionInterval())
+	rs.createLimits.set(config.CreateRateLimits())
+	rs.redirectLimits.set(config.RedirectRateLimits())
+}
+
+// reloadOnSighup reloads JWT keys and configuration on SIGHUP. Settings which can't be changed
+// at runtime are only reported, they're compared with startup config so the warning repeats
+// until the server is restarted
+func reloadOnSighup(ctx context.Context, startup *configs.Config, rs runtimeSettings) {
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+	defer signal.Stop(sighup)
+
+	current := startup
+	for {
+		select {
+		case <-sighup:
+			if err := rs.auth.Reload(); err != nil {
+				rs.logger.Error("couldn't reload JWT keys", "error", err)
+			} else {
+				rs.logger.Info("JWT keys reloaded")
+			}
+
+			next, err := current.Reload()
+			if err != nil {
+				// Текущие настройки остаются в силе, пока файл не исправят
+				rs.logger.Error("couldn't reload config, keeping current settings", "error", err)
+				continue
+			}
+			applied, _ := current.Changes(next)
+			_, restart := startup.Changes(next)
+			rs.apply(next)
+			current = next
+
+			rs.logger.Info("config reloaded", "applied", applied)
+			if len(restart) > 0 {
+				rs.logger.Warn("some config changes need restart to take effect", "keys", restart)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/maxzhirnov/urlshort/internal/apidocs"
+	"github.com/maxzhirnov/urlshort/internal/handlers"
+)
+
+// routeMiddlewares are per-route middlewares, metrics is nil when metrics are served on a separate address
+type routeMiddlewares struct {
+	createLimit   gin.HandlerFunc
+	redirectLimit gin.HandlerFunc
+	trustedSubnet gin.HandlerFunc
+	metrics       http.Handler
+}
+
+// registerRoutes registers every HTTP route of the service, each of them should be
+// described in internal/apidocs/openapi.json, routes_test.go checks it
+func registerRoutes(r *gin.Engine, handler *handlers.Handlers, mw routeMiddlewares) {
+	r.GET("/:ID", mw.redirectLimit, handler.HandleRedirect)
+	r.GET("/:ID/*rest", mw.redirectLimit, handler.HandleRedirect)
+	r.POST("/", mw.createLimit, handler.HandleCreate)
+	r.GET("/ping", handler.HandlePing)
+	r.GET("/healthz", handler.HandleLiveness)
+	r.GET("/readyz", handler.HandleReadiness)
+	if mw.metrics != nil {
+		r.GET("/metrics", gin.WrapH(mw.metrics))
+	}
+
+	api := r.Group("/api")
+	api.GET("/openapi.json", apidocs.HandleSpec)
+	api.GET("/docs", apidocs.HandleDocs)
+	api.POST("/shorten", mw.createLimit, handler.HandleShorten)
+	api.POST("/shorten/batch", mw.createLimit, handler.HandleShortenBatch)
+	api.GET("/user/urls", handler.HandleShowAllUsersURLs)
+	api.DELETE("/user/urls", handler.HandleDeleteURL)
+	api.GET("/user/urls/:id/rules", handler.HandleGetRedirectRules)
+	api.PUT("/user/urls/:id/rules", handler.HandleSetRedirectRules)
+	api.POST("/user/keys", handler.HandleCreateAPIKey)
+	api.GET("/user/keys", handler.HandleListAPIKeys)
+	api.DELETE("/user/keys/:id", handler.HandleRevokeAPIKey)
+	api.POST("/user/signup", handler.HandleSignUp)
+	api.POST("/user/login", handler.HandleLogin)
+	api.POST("/user/logout", handler.HandleLogout)
+	api.GET("/user/sessions", handler.HandleListSessions)
+	api.DELETE("/user/sessions/:id", handler.HandleRevokeSession)
+	api.GET("/auth/oidc/login", mw.createLimit, handler.HandleOIDCLogin)
+	api.GET("/auth/oidc/callback", handler.HandleOIDCCallback)
+	api.POST("/orgs", handler.HandleCreateOrg)
+	api.GET("/orgs", handler.HandleListOrgs)
+	api.GET("/orgs/:org/members", handler.HandleListOrgMembers)
+	api.PUT("/orgs/:org/members/:uuid", handler.HandleSetOrgMember)
+	api.DELETE("/orgs/:org/members/:uuid", handler.HandleRemoveOrgMember)
+	api.GET("/orgs/:org/urls", handler.HandleListOrgURLs)
+	api.POST("/orgs/:org/urls", mw.createLimit, handler.HandleCreateOrgURL)
+	api.DELETE("/orgs/:org/urls", handler.HandleDeleteOrgURLs)
+	api.POST("/orgs/:org/urls/transfer", handler.HandleTransferOrgURLs)
+
+	api.GET("/internal/stats", mw.trustedSubnet, handler.HandleInternalStats)
+
+	admin := api.Group("/admin", handler.RequireAdmin)
+	admin.GET("/urls", handler.HandleAdminSearchURLs)
+	admin.DELETE("/urls", handler.HandleAdminDeleteURLs)
+	admin.PATCH("/urls", handler.HandleAdminSetURLsDisabled)
+	admin.GET("/users", handler.HandleAdminListUsers)
+	admin.GET("/users/:id", handler.HandleAdminInspectUser)
+	admin.GET("/stats", handler.HandleAdminStats)
+}
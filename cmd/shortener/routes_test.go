@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/maxzhirnov/urlshort/internal/apidocs"
+	"github.com/maxzhirnov/urlshort/internal/handlers"
+)
+
+var ginParam = regexp.MustCompile(`[:*]([^/]+)`)
+
+func Test_registerRoutes_DescribedInSpec(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var spec struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	require.NoError(t, json.Unmarshal(apidocs.Spec(), &spec))
+
+	noop := func(c *gin.Context) {}
+	r := gin.New()
+	registerRoutes(r, &handlers.Handlers{}, routeMiddlewares{
+		createLimit:   noop,
+		redirectLimit: noop,
+		trustedSubnet: noop,
+		metrics:       http.NotFoundHandler(),
+	})
+
+	registered := make(map[string]bool)
+	for _, route := range r.Routes() {
+		path := ginParam.ReplaceAllString(route.Path, "{$1}")
+		method := strings.ToLower(route.Method)
+		registered[method+" "+path] = true
+
+		_, ok := spec.Paths[path][method]
+		assert.Truef(t, ok, "route %s %s is missing in openapi.json", route.Method, path)
+	}
+
+	for path, operations := range spec.Paths {
+		for method := range operations {
+			assert.Truef(t, registered[method+" "+path], "openapi.json describes %s %s which isn't registered", strings.ToUpper(method), path)
+		}
+	}
+}
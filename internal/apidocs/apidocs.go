@@ -0,0 +1,31 @@
+// Package apidocs serves OpenAPI description of the REST API and a page rendering it.
+// openapi.json is written by hand, keep it in sync with routes in cmd/shortener.
+package apidocs
+
+import (
+	_ "embed"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+//go:embed openapi.json
+var spec []byte
+
+//go:embed docs.html
+var docsPage []byte
+
+// Spec returns OpenAPI 3 document of the API
+func Spec() []byte {
+	return spec
+}
+
+func HandleSpec(c *gin.Context) {
+	c.Data(http.StatusOK, "application/json", spec)
+}
+
+// HandleDocs serves the page which loads openapi.json relative to its own path,
+// it doesn't use any external scripts so it works in closed networks
+func HandleDocs(c *gin.Context) {
+	c.Data(http.StatusOK, "text/html; charset=utf-8", docsPage)
+}
@@ -0,0 +1,78 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"math/big"
+	"net/http"
+	"strings"
+)
+
+// APIKeyPrefix marks api keys so they can be told apart from JWT tokens in Authorization header
+const APIKeyPrefix = "us_"
+
+const (
+	apiKeyIDLen     = 8
+	apiKeySecretLen = 32
+	apiKeyCharset   = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+)
+
+// GenerateAPIKey returns new api key of the form us_<id>_<secret> together with its id
+func GenerateAPIKey() (key string, id string, err error) {
+	id, err = randomString(apiKeyIDLen)
+	if err != nil {
+		return "", "", err
+	}
+	secret, err := randomString(apiKeySecretLen)
+	if err != nil {
+		return "", "", err
+	}
+	return APIKeyPrefix + id + "_" + secret, id, nil
+}
+
+// HashAPIKey returns hex encoded sha256 of the key, keys are random enough
+// to not need a slow password hash
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func IsAPIKey(s string) bool {
+	return strings.HasPrefix(s, APIKeyPrefix)
+}
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	max := big.NewInt(int64(len(apiKeyCharset)))
+	for i := range b {
+		idx, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		b[i] = apiKeyCharset[idx.Int64()]
+	}
+	return string(b), nil
+}
+
+// APIKeyFromRequest extracts api key from X-API-Key header or from
+// Authorization: Bearer header if the bearer value looks like an api key
+func APIKeyFromRequest(r *http.Request) (string, bool) {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key, true
+	}
+	if token, ok := BearerToken(r); ok && IsAPIKey(token) {
+		return token, true
+	}
+	return "", false
+}
+
+// BearerToken returns value of the Authorization: Bearer header
+func BearerToken(r *http.Request) (string, bool) {
+	const prefix = "Bearer "
+	header := r.Header.Get("Authorization")
+	if len(header) <= len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return "", false
+	}
+	return strings.TrimSpace(header[len(prefix):]), true
+}
@@ -1,8 +1,10 @@
 package auth
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/golang-jwt/jwt/v4"
@@ -12,62 +14,220 @@ import (
 const tokenExp = time.Hour * 24 * 365
 const secretKey = "supersecretkey"
 
+// defaultKeyID is used for the single secret configured without a keyring and
+// for verifying legacy tokens issued without kid header
+const defaultKeyID = "default"
+
+var (
+	ErrUnknownKeyID = errors.New("unknown key id")
+	ErrInvalidToken = errors.New("invalid token")
+	ErrTokenRevoked = errors.New("token revoked")
+)
+
 type claims struct {
 	jwt.RegisteredClaims
 	UserID string
 }
 
+// IssuedToken is a signed token together with its registered claims
+type IssuedToken struct {
+	Value     string
+	ID        string
+	UserID    string
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+}
+
 type Auth struct {
-	secretKey string
+	mu       sync.RWMutex
+	keyring  *Keyring
+	keysFile string
+	cookie   CookieConfig
+	revoked  *RevocationList
 }
 
+// NewAuth creates Auth with the single secret taken from secretKey env variable
+// or the hard-coded default one
 func NewAuth() *Auth {
-	secretKey := secretKey
+	secret := secretKey
 	if parsedSecretKey, exist := os.LookupEnv("secretKey"); exist {
-		secretKey = parsedSecretKey
+		secret = parsedSecretKey
 	}
+	kr, _ := NewKeyring(defaultKeyID, Key{ID: defaultKeyID, Secret: secret})
+	return NewAuthWithKeyring(kr)
+}
+
+func NewAuthWithKeyring(kr *Keyring) *Auth {
 	return &Auth{
-		secretKey: secretKey,
+		keyring: kr,
+		cookie:  DefaultCookieConfig(),
+		revoked: NewRevocationList(),
 	}
 }
 
-func (a Auth) GenerateToken(userID string) (string, error) {
+// NewAuthFromFile creates Auth with keyring loaded from file, the file is re-read on Reload
+func NewAuthFromFile(path string) (*Auth, error) {
+	kr, err := LoadKeyring(path)
+	if err != nil {
+		return nil, err
+	}
+	a := NewAuthWithKeyring(kr)
+	a.keysFile = path
+	return a, nil
+}
+
+// Reload re-reads keyring file if Auth was created from one, old keyring stays in use on error
+func (a *Auth) Reload() error {
+	if a.keysFile == "" {
+		return nil
+	}
+	kr, err := LoadKeyring(a.keysFile)
+	if err != nil {
+		return err
+	}
+	a.SetKeyring(kr)
+	return nil
+}
+
+func (a *Auth) SetKeyring(kr *Keyring) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.keyring = kr
+}
+
+func (a *Auth) getKeyring() *Keyring {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.keyring
+}
+
+// UsesDefaultSecret reports whether any key in the keyring is the hard-coded development secret
+func (a *Auth) UsesDefaultSecret() bool {
+	for _, secret := range a.getKeyring().keys {
+		if string(secret) == secretKey {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *Auth) tokenTTL() time.Duration {
+	if maxAge := a.CookieConfig().MaxAge; maxAge > 0 {
+		return maxAge
+	}
+	return tokenExp
+}
+
+func (a *Auth) GenerateToken(userID string) (string, error) {
+	token, err := a.IssueToken(userID)
+	if err != nil {
+		return "", err
+	}
+	return token.Value, nil
+}
+
+// IssueToken signs new token with unique jti so it can be revoked later
+func (a *Auth) IssueToken(userID string) (IssuedToken, error) {
+	kid, secret := a.getKeyring().activeKey()
+	now := time.Now()
+	issued := IssuedToken{
+		ID:        uuid.New().String(),
+		UserID:    userID,
+		IssuedAt:  now,
+		ExpiresAt: now.Add(a.tokenTTL()),
+	}
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims{
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(tokenExp)),
+			ID:        issued.ID,
+			IssuedAt:  jwt.NewNumericDate(issued.IssuedAt),
+			ExpiresAt: jwt.NewNumericDate(issued.ExpiresAt),
 		},
 		UserID: userID,
 	})
+	token.Header["kid"] = kid
 
-	tokenString, err := token.SignedString([]byte(a.secretKey))
+	tokenString, err := token.SignedString(secret)
 	if err != nil {
-		return "", err
+		return IssuedToken{}, err
 	}
+	issued.Value = tokenString
+
+	return issued, nil
+}
 
-	return tokenString, nil
+// ValidateToken checks if token is valid and not revoked and returns uuid
+func (a *Auth) ValidateToken(tokenString string) string {
+	token, err := a.ParseToken(tokenString)
+	if err != nil {
+		return ""
+	}
+	return token.UserID
 }
 
-// ValidateToken checks if token is valid and returns uuid
-func (a Auth) ValidateToken(tokenString string) string {
+// ParseToken verifies token and returns its claims
+func (a *Auth) ParseToken(tokenString string) (IssuedToken, error) {
+	kr := a.getKeyring()
 	token, err := jwt.ParseWithClaims(tokenString, &claims{}, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
-		return []byte(a.secretKey), nil
+		return verificationKey(kr, token)
 	})
-
 	if err != nil {
-		return ""
+		return IssuedToken{}, err
 	}
 
-	if claims, ok := token.Claims.(*claims); ok && token.Valid {
-		return claims.UserID
-	} else {
-		return ""
+	c, ok := token.Claims.(*claims)
+	if !ok || !token.Valid || c.UserID == "" {
+		return IssuedToken{}, ErrInvalidToken
+	}
+	if c.ID != "" && a.revoked.IsRevoked(c.ID) {
+		return IssuedToken{}, ErrTokenRevoked
+	}
+
+	issued := IssuedToken{
+		Value:  tokenString,
+		ID:     c.ID,
+		UserID: c.UserID,
+	}
+	if c.IssuedAt != nil {
+		issued.IssuedAt = c.IssuedAt.Time
+	}
+	if c.ExpiresAt != nil {
+		issued.ExpiresAt = c.ExpiresAt.Time
+	}
+	return issued, nil
+}
+
+// Revoke rejects token with the jti until it expires
+func (a *Auth) Revoke(jti string, expiresAt time.Time) {
+	a.revoked.Revoke(jti, expiresAt)
+}
+
+func (a *Auth) RevocationList() *RevocationList {
+	return a.revoked
+}
+
+// verificationKey selects key by kid header, tokens without kid are checked against
+// the default key if keyring still has it, otherwise against the active one
+func verificationKey(kr *Keyring, token *jwt.Token) ([]byte, error) {
+	kid, ok := token.Header["kid"].(string)
+	if !ok || kid == "" {
+		if secret, ok := kr.key(defaultKeyID); ok {
+			return secret, nil
+		}
+		_, secret := kr.activeKey()
+		return secret, nil
+	}
+
+	secret, ok := kr.key(kid)
+	if !ok {
+		return nil, ErrUnknownKeyID
 	}
+	return secret, nil
 }
 
-func (a Auth) GenerateUUID() string {
+func (a *Auth) GenerateUUID() string {
 	id := uuid.New().String()
 	return id
 }
@@ -2,6 +2,7 @@ package auth
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -11,3 +12,70 @@ func Test_GenerateUserID(t *testing.T) {
 	userID := auth.GenerateUUID()
 	assert.Greater(t, len(userID), 0)
 }
+
+func Test_KeyRotation(t *testing.T) {
+	oldRing, err := NewKeyring("k1", Key{ID: "k1", Secret: "old"})
+	assert.NoError(t, err)
+	a := NewAuthWithKeyring(oldRing)
+
+	token, err := a.GenerateToken("user")
+	assert.NoError(t, err)
+	assert.Equal(t, "user", a.ValidateToken(token))
+
+	// k2 becomes active, k1 is kept for verification only
+	newRing, err := NewKeyring("k2", Key{ID: "k1", Secret: "old"}, Key{ID: "k2", Secret: "new"})
+	assert.NoError(t, err)
+	a.SetKeyring(newRing)
+	assert.Equal(t, "user", a.ValidateToken(token))
+
+	newToken, err := a.GenerateToken("other")
+	assert.NoError(t, err)
+	assert.Equal(t, "other", a.ValidateToken(newToken))
+
+	// k1 removed
+	onlyNew, err := NewKeyring("k2", Key{ID: "k2", Secret: "new"})
+	assert.NoError(t, err)
+	a.SetKeyring(onlyNew)
+	assert.Equal(t, "", a.ValidateToken(token))
+	assert.Equal(t, "other", a.ValidateToken(newToken))
+}
+
+func Test_NewKeyring(t *testing.T) {
+	_, err := NewKeyring("k1")
+	assert.ErrorIs(t, err, ErrEmptyKeyring)
+
+	_, err = NewKeyring("missing", Key{ID: "k1", Secret: "s"})
+	assert.ErrorIs(t, err, ErrUnknownActiveKey)
+}
+
+func Test_UsesDefaultSecret(t *testing.T) {
+	t.Setenv("secretKey", secretKey)
+	assert.True(t, NewAuth().UsesDefaultSecret())
+
+	t.Setenv("secretKey", "custom")
+	assert.False(t, NewAuth().UsesDefaultSecret())
+}
+
+func Test_RevokeToken(t *testing.T) {
+	a := NewAuth()
+	token, err := a.IssueToken("user")
+	assert.NoError(t, err)
+	assert.NotEmpty(t, token.ID)
+	assert.Equal(t, "user", a.ValidateToken(token.Value))
+
+	a.Revoke(token.ID, token.ExpiresAt)
+	assert.Equal(t, "", a.ValidateToken(token.Value))
+	_, err = a.ParseToken(token.Value)
+	assert.ErrorIs(t, err, ErrTokenRevoked)
+}
+
+func Test_RevocationListCleanup(t *testing.T) {
+	l := NewRevocationList()
+	now := time.Now()
+	l.Revoke("expired", now.Add(-time.Minute))
+	l.Revoke("active", now.Add(time.Minute))
+
+	assert.Equal(t, 1, l.Cleanup(now))
+	assert.False(t, l.IsRevoked("expired"))
+	assert.True(t, l.IsRevoked("active"))
+}
@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// TokenHeader is the response header newly issued tokens are echoed in for non-browser clients
+const TokenHeader = "X-Auth-Token"
+
+type CookieConfig struct {
+	Name     string
+	Domain   string
+	Path     string
+	Secure   bool
+	SameSite http.SameSite
+	// MaxAge is lifetime of both the cookie and the token in it
+	MaxAge time.Duration
+}
+
+// DefaultCookieConfig returns host-only cookie living as long as the token
+func DefaultCookieConfig() CookieConfig {
+	return CookieConfig{
+		Name:     "jwt_token",
+		Path:     "/",
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   tokenExp,
+	}
+}
+
+// ParseSameSite parses lax, strict or none, empty string means default mode
+func ParseSameSite(s string) (http.SameSite, error) {
+	switch strings.ToLower(s) {
+	case "":
+		return http.SameSiteDefaultMode, nil
+	case "lax":
+		return http.SameSiteLaxMode, nil
+	case "strict":
+		return http.SameSiteStrictMode, nil
+	case "none":
+		return http.SameSiteNoneMode, nil
+	default:
+		return 0, fmt.Errorf("unknown SameSite mode %q, should be lax, strict or none", s)
+	}
+}
+
+// WithCookieConfig sets cookie attributes, it's safe to call while requests are served
+func (a *Auth) WithCookieConfig(cfg CookieConfig) *Auth {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.cookie = cfg
+	return a
+}
+
+func (a *Auth) CookieConfig() CookieConfig {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.cookie
+}
+
+// NewCookie returns cookie carrying the token with configured attributes
+func (a *Auth) NewCookie(token string) *http.Cookie {
+	cfg := a.CookieConfig()
+	return &http.Cookie{
+		Name:     cfg.Name,
+		Value:    token,
+		Domain:   cfg.Domain,
+		Path:     cfg.Path,
+		MaxAge:   int(cfg.MaxAge.Seconds()),
+		Secure:   cfg.Secure,
+		HttpOnly: true,
+		SameSite: cfg.SameSite,
+	}
+}
+
+// TokenFromRequest returns JWT from Authorization: Bearer header or from the cookie,
+// api keys in Authorization header are skipped
+func (a *Auth) TokenFromRequest(r *http.Request) (string, bool) {
+	if token, ok := BearerToken(r); ok && !IsAPIKey(token) {
+		return token, true
+	}
+	cookie, err := r.Cookie(a.CookieConfig().Name)
+	if err != nil {
+		return "", false
+	}
+	return cookie.Value, true
+}
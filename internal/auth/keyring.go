@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+var (
+	ErrEmptyKeyring     = errors.New("keyring should contain at least one key")
+	ErrUnknownActiveKey = errors.New("active key is not present in keyring")
+)
+
+// Key is a single HMAC secret identified by the kid header value
+type Key struct {
+	ID     string `json:"id"`
+	Secret string `json:"secret"`
+}
+
+// Keyring holds every key accepted for verification and the id of the one used for signing
+type Keyring struct {
+	active string
+	keys   map[string][]byte
+}
+
+type keyringFile struct {
+	Active string `json:"active"`
+	Keys   []Key  `json:"keys"`
+}
+
+func NewKeyring(activeID string, keys ...Key) (*Keyring, error) {
+	if len(keys) == 0 {
+		return nil, ErrEmptyKeyring
+	}
+
+	kr := &Keyring{
+		active: activeID,
+		keys:   make(map[string][]byte, len(keys)),
+	}
+	for _, k := range keys {
+		if k.ID == "" || k.Secret == "" {
+			return nil, fmt.Errorf("key id and secret shouldn't be empty")
+		}
+		kr.keys[k.ID] = []byte(k.Secret)
+	}
+
+	if _, ok := kr.keys[activeID]; !ok {
+		return nil, ErrUnknownActiveKey
+	}
+
+	return kr, nil
+}
+
+// LoadKeyring reads keyring from json file of the form
+// {"active": "k2", "keys": [{"id": "k1", "secret": "..."}, {"id": "k2", "secret": "..."}]}
+func LoadKeyring(path string) (*Keyring, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var f keyringFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("error parsing keyring file: %w", err)
+	}
+
+	return NewKeyring(f.Active, f.Keys...)
+}
+
+func (k *Keyring) ActiveID() string {
+	return k.active
+}
+
+func (k *Keyring) activeKey() (string, []byte) {
+	return k.active, k.keys[k.active]
+}
+
+func (k *Keyring) key(id string) ([]byte, bool) {
+	secret, ok := k.keys[id]
+	return secret, ok
+}
+
+// IDs returns ids of all keys accepted for verification
+func (k *Keyring) IDs() []string {
+	ids := make([]string, 0, len(k.keys))
+	for id := range k.keys {
+		ids = append(ids, id)
+	}
+	return ids
+}
@@ -0,0 +1,174 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+const (
+	// OIDCLoginTTL is how long the user has to finish login at the provider
+	OIDCLoginTTL = 10 * time.Minute
+	// maxPendingOIDCLogins bounds memory used by logins which are started and never finished
+	maxPendingOIDCLogins = 10000
+)
+
+var (
+	ErrUnknownOIDCState     = errors.New("unknown or expired oidc state")
+	ErrOIDCNonce            = errors.New("oidc nonce mismatch")
+	ErrTooManyPendingLogins = errors.New("too many pending oidc logins")
+)
+
+type OIDCConfig struct {
+	IssuerURL    string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+}
+
+// OIDCIdentity is the user authenticated by the identity provider
+type OIDCIdentity struct {
+	// ExternalID is unique across providers: issuer and sub claim joined by |
+	ExternalID string
+	Subject    string
+	Email      string
+	// MergeFrom is uuid of the anonymous user who started the login
+	MergeFrom string
+}
+
+type oidcLogin struct {
+	verifier  string
+	nonce     string
+	mergeFrom string
+	expiresAt time.Time
+}
+
+// OIDCProvider implements authorization code flow with PKCE, pending logins are kept
+// in memory so the callback should be served by the instance which started the login
+type OIDCProvider struct {
+	issuer   string
+	oauth2   oauth2.Config
+	verifier *oidc.IDTokenVerifier
+
+	mu      sync.Mutex
+	pending map[string]oidcLogin
+}
+
+// NewOIDCProvider discovers provider endpoints from issuer's .well-known/openid-configuration
+func NewOIDCProvider(ctx context.Context, cfg OIDCConfig) (*OIDCProvider, error) {
+	provider, err := oidc.NewProvider(ctx, cfg.IssuerURL)
+	if err != nil {
+		return nil, fmt.Errorf("error discovering oidc provider: %w", err)
+	}
+
+	return &OIDCProvider{
+		issuer: cfg.IssuerURL,
+		oauth2: oauth2.Config{
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			RedirectURL:  cfg.RedirectURL,
+			Endpoint:     provider.Endpoint(),
+			Scopes:       []string{oidc.ScopeOpenID, "email"},
+		},
+		verifier: provider.Verifier(&oidc.Config{ClientID: cfg.ClientID}),
+		pending:  make(map[string]oidcLogin),
+	}, nil
+}
+
+// AuthCodeURL starts new login and returns url of the provider to redirect the user to
+// and state of the login, the caller should bind state to the browser which started the login
+func (p *OIDCProvider) AuthCodeURL(mergeFrom string) (authURL, state string, err error) {
+	state, err = randomString(32)
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := randomString(32)
+	if err != nil {
+		return "", "", err
+	}
+	verifier := oauth2.GenerateVerifier()
+
+	p.mu.Lock()
+	p.cleanupLocked()
+	if len(p.pending) >= maxPendingOIDCLogins {
+		p.mu.Unlock()
+		return "", "", ErrTooManyPendingLogins
+	}
+	p.pending[state] = oidcLogin{
+		verifier:  verifier,
+		nonce:     nonce,
+		mergeFrom: mergeFrom,
+		expiresAt: time.Now().Add(OIDCLoginTTL),
+	}
+	p.mu.Unlock()
+
+	return p.oauth2.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier)), state, nil
+}
+
+// Exchange finishes login started with AuthCodeURL: exchanges the code and verifies id token
+func (p *OIDCProvider) Exchange(ctx context.Context, state, code string) (OIDCIdentity, error) {
+	login, ok := p.takePending(state)
+	if !ok {
+		return OIDCIdentity{}, ErrUnknownOIDCState
+	}
+
+	token, err := p.oauth2.Exchange(ctx, code, oauth2.VerifierOption(login.verifier))
+	if err != nil {
+		return OIDCIdentity{}, fmt.Errorf("error exchanging code: %w", err)
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return OIDCIdentity{}, errors.New("token response has no id_token")
+	}
+
+	idToken, err := p.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return OIDCIdentity{}, fmt.Errorf("error verifying id token: %w", err)
+	}
+	if idToken.Nonce != login.nonce {
+		return OIDCIdentity{}, ErrOIDCNonce
+	}
+
+	var claims struct {
+		Email string `json:"email"`
+	}
+	if err := idToken.Claims(&claims); err != nil {
+		return OIDCIdentity{}, err
+	}
+
+	return OIDCIdentity{
+		ExternalID: p.issuer + "|" + idToken.Subject,
+		Subject:    idToken.Subject,
+		Email:      claims.Email,
+		MergeFrom:  login.mergeFrom,
+	}, nil
+}
+
+func (p *OIDCProvider) takePending(state string) (oidcLogin, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	login, ok := p.pending[state]
+	if !ok {
+		return oidcLogin{}, false
+	}
+	delete(p.pending, state)
+	if time.Now().After(login.expiresAt) {
+		return oidcLogin{}, false
+	}
+	return login, true
+}
+
+func (p *OIDCProvider) cleanupLocked() {
+	now := time.Now()
+	for state, login := range p.pending {
+		if now.After(login.expiresAt) {
+			delete(p.pending, state)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mockIdP is a minimal OpenID Connect provider issuing codes directly from the authorize url
+type mockIdP struct {
+	server   *httptest.Server
+	key      *rsa.PrivateKey
+	clientID string
+	subject  string
+
+	// code -> authorize request parameters
+	codes map[string]url.Values
+}
+
+func newMockIdP(t *testing.T, clientID, subject string) *mockIdP {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	idp := &mockIdP{key: key, clientID: clientID, subject: subject, codes: make(map[string]url.Values)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]interface{}{
+			"issuer":                                idp.server.URL,
+			"authorization_endpoint":                idp.server.URL + "/authorize",
+			"token_endpoint":                        idp.server.URL + "/token",
+			"jwks_uri":                              idp.server.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"alg": "RS256",
+				"use": "sig",
+				"kid": "test",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		params, ok := idp.codes[r.PostForm.Get("code")]
+		if !ok {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		// PKCE: sha256 от verifier должен совпасть с challenge из authorize запроса
+		sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		if base64.RawURLEncoding.EncodeToString(sum[:]) != params.Get("code_challenge") {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+
+		idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+			"iss":   idp.server.URL,
+			"aud":   idp.clientID,
+			"sub":   idp.subject,
+			"email": "employee@example.com",
+			"nonce": params.Get("nonce"),
+			"iat":   time.Now().Unix(),
+			"exp":   time.Now().Add(time.Minute).Unix(),
+		})
+		idToken.Header["kid"] = "test"
+		signed, err := idToken.SignedString(key)
+		require.NoError(t, err)
+
+		writeJSON(w, map[string]interface{}{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"expires_in":   60,
+			"id_token":     signed,
+		})
+	})
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+// authorize emulates user consent and returns code and state the provider would redirect with
+func (idp *mockIdP) authorize(t *testing.T, authURL string) (code, state string) {
+	u, err := url.Parse(authURL)
+	require.NoError(t, err)
+	params := u.Query()
+	assert.Equal(t, "S256", params.Get("code_challenge_method"))
+	assert.Equal(t, idp.clientID, params.Get("client_id"))
+
+	code = "code-" + params.Get("state")
+	idp.codes[code] = params
+	return code, params.Get("state")
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func Test_OIDCLogin(t *testing.T) {
+	idp := newMockIdP(t, "shortener", "employee-42")
+	ctx := context.Background()
+
+	p, err := NewOIDCProvider(ctx, OIDCConfig{
+		IssuerURL:    idp.server.URL,
+		ClientID:     "shortener",
+		ClientSecret: "secret",
+		RedirectURL:  "http://localhost:8080/api/auth/oidc/callback",
+	})
+	require.NoError(t, err)
+
+	authURL, started, err := p.AuthCodeURL("anonymous-uuid")
+	require.NoError(t, err)
+	code, state := idp.authorize(t, authURL)
+	assert.Equal(t, started, state)
+
+	identity, err := p.Exchange(ctx, state, code)
+	require.NoError(t, err)
+	assert.Equal(t, "employee-42", identity.Subject)
+	assert.Equal(t, idp.server.URL+"|employee-42", identity.ExternalID)
+	assert.Equal(t, "employee@example.com", identity.Email)
+	assert.Equal(t, "anonymous-uuid", identity.MergeFrom)
+
+	// state одноразовый
+	_, err = p.Exchange(ctx, state, code)
+	assert.ErrorIs(t, err, ErrUnknownOIDCState)
+}
+
+func Test_OIDCLoginWrongVerifier(t *testing.T) {
+	idp := newMockIdP(t, "shortener", "employee-42")
+	ctx := context.Background()
+
+	p, err := NewOIDCProvider(ctx, OIDCConfig{IssuerURL: idp.server.URL, ClientID: "shortener"})
+	require.NoError(t, err)
+
+	authURL, _, err := p.AuthCodeURL("")
+	require.NoError(t, err)
+	code, state := idp.authorize(t, authURL)
+
+	// Подменяем verifier, как если бы код был перехвачен другим клиентом
+	login := p.pending[state]
+	login.verifier = "intercepted"
+	p.pending[state] = login
+
+	_, err = p.Exchange(ctx, state, code)
+	assert.Error(t, err)
+}
+
+func Test_OIDCPendingLoginsLimit(t *testing.T) {
+	idp := newMockIdP(t, "shortener", "employee-42")
+	p, err := NewOIDCProvider(context.Background(), OIDCConfig{IssuerURL: idp.server.URL, ClientID: "shortener"})
+	require.NoError(t, err)
+
+	for i := 0; i < maxPendingOIDCLogins; i++ {
+		p.pending[fmt.Sprint(i)] = oidcLogin{expiresAt: time.Now().Add(time.Minute)}
+	}
+	_, _, err = p.AuthCodeURL("")
+	assert.ErrorIs(t, err, ErrTooManyPendingLogins)
+
+	// Просроченные логины освобождают место
+	for state, login := range p.pending {
+		login.expiresAt = time.Now().Add(-time.Second)
+		p.pending[state] = login
+		break
+	}
+	_, _, err = p.AuthCodeURL("")
+	assert.NoError(t, err)
+}
@@ -0,0 +1,69 @@
+package auth
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RevocationList keeps ids of revoked tokens until the tokens expire
+type RevocationList struct {
+	mu sync.RWMutex
+	m  map[string]time.Time
+}
+
+func NewRevocationList() *RevocationList {
+	return &RevocationList{
+		m: make(map[string]time.Time),
+	}
+}
+
+func (l *RevocationList) Revoke(jti string, expiresAt time.Time) {
+	if jti == "" {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.m[jti] = expiresAt
+}
+
+func (l *RevocationList) IsRevoked(jti string) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	_, ok := l.m[jti]
+	return ok
+}
+
+func (l *RevocationList) Len() int {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return len(l.m)
+}
+
+// Cleanup drops ids of tokens expired before now, they are rejected by expiry check anyway
+func (l *RevocationList) Cleanup(now time.Time) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	removed := 0
+	for jti, expiresAt := range l.m {
+		if expiresAt.Before(now) {
+			delete(l.m, jti)
+			removed++
+		}
+	}
+	return removed
+}
+
+// RunCleanup calls Cleanup every interval until ctx is done
+func (l *RevocationList) RunCleanup(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			l.Cleanup(now)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
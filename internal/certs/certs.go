@@ -0,0 +1,154 @@
+// Package certs provides TLS certificates for the server: loaded from files and
+// reloaded when they change, or self-signed for development
+package certs
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type logger interface {
+	Info(string, ...interface{})
+	Error(string, ...interface{})
+}
+
+// Reloader serves certificate from cert/key files and picks up new files after rotation
+type Reloader struct {
+	certFile string
+	keyFile  string
+
+	cert atomic.Pointer[tls.Certificate]
+
+	mu       sync.Mutex
+	certTime time.Time
+	keyTime  time.Time
+}
+
+// NewReloader loads the key pair, error is returned if files are missing or don't match
+func NewReloader(certFile, keyFile string) (*Reloader, error) {
+	r := &Reloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+	if _, err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// GetCertificate is used as tls.Config.GetCertificate
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.cert.Load(), nil
+}
+
+// Reload loads the key pair if any of files was modified since last load and reports
+// whether certificate was replaced. On error the previous certificate is kept.
+func (r *Reloader) Reload() (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	certInfo, err := os.Stat(r.certFile)
+	if err != nil {
+		return false, err
+	}
+	keyInfo, err := os.Stat(r.keyFile)
+	if err != nil {
+		return false, err
+	}
+	if certInfo.ModTime().Equal(r.certTime) && keyInfo.ModTime().Equal(r.keyTime) && r.cert.Load() != nil {
+		return false, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return false, fmt.Errorf("error loading TLS key pair: %w", err)
+	}
+	r.cert.Store(&cert)
+	r.certTime = certInfo.ModTime()
+	r.keyTime = keyInfo.ModTime()
+	return true, nil
+}
+
+// Watch checks files every interval until ctx is done. Cert and key are usually
+// replaced one after another, so a mismatched pair is only logged and retried.
+func (r *Reloader) Watch(ctx context.Context, interval time.Duration, l logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			reloaded, err := r.Reload()
+			if err != nil {
+				l.Error("couldn't reload TLS certificate", "error", err)
+				continue
+			}
+			if reloaded {
+				l.Info("TLS certificate reloaded", "cert_file", r.certFile)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// SelfSigned creates in-memory certificate for the hosts, localhost is always included
+func SelfSigned(hosts ...string) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	now := time.Now()
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"urlshortener dev"}},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.AddDate(1, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	for _, h := range hosts {
+		if h == "" || h == "localhost" {
+			continue
+		}
+		if ip := net.ParseIP(h); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, h)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}, nil
+}
@@ -0,0 +1,87 @@
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writePair(t *testing.T, cert tls.Certificate, certFile, keyFile string, modTime time.Time) {
+	t.Helper()
+	keyDER, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600))
+	require.NoError(t, os.Chtimes(certFile, modTime, modTime))
+	require.NoError(t, os.Chtimes(keyFile, modTime, modTime))
+}
+
+func TestSelfSigned(t *testing.T) {
+	cert, err := SelfSigned("shortener.local", "10.0.0.1")
+	require.NoError(t, err)
+
+	assert.NoError(t, cert.Leaf.VerifyHostname("localhost"))
+	assert.NoError(t, cert.Leaf.VerifyHostname("127.0.0.1"))
+	assert.NoError(t, cert.Leaf.VerifyHostname("shortener.local"))
+	assert.NoError(t, cert.Leaf.VerifyHostname("10.0.0.1"))
+	assert.Error(t, cert.Leaf.VerifyHostname("example.com"))
+}
+
+func TestReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	start := time.Now().Add(-time.Hour)
+
+	first, err := SelfSigned()
+	require.NoError(t, err)
+	writePair(t, first, certFile, keyFile, start)
+
+	r, err := NewReloader(certFile, keyFile)
+	require.NoError(t, err)
+	served, err := r.GetCertificate(nil)
+	require.NoError(t, err)
+	assert.Equal(t, first.Certificate[0], served.Certificate[0])
+
+	t.Run("unchanged files are not reloaded", func(t *testing.T) {
+		reloaded, err := r.Reload()
+		require.NoError(t, err)
+		assert.False(t, reloaded)
+	})
+
+	t.Run("half rotated pair keeps previous certificate", func(t *testing.T) {
+		other, err := SelfSigned()
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: other.Certificate[0]}), 0600))
+		require.NoError(t, os.Chtimes(certFile, start.Add(time.Minute), start.Add(time.Minute)))
+
+		_, err = r.Reload()
+		assert.Error(t, err)
+		served, _ := r.GetCertificate(nil)
+		assert.Equal(t, first.Certificate[0], served.Certificate[0])
+	})
+
+	t.Run("rotated pair is served", func(t *testing.T) {
+		second, err := SelfSigned()
+		require.NoError(t, err)
+		writePair(t, second, certFile, keyFile, start.Add(2*time.Minute))
+
+		reloaded, err := r.Reload()
+		require.NoError(t, err)
+		assert.True(t, reloaded)
+		served, _ := r.GetCertificate(nil)
+		assert.Equal(t, second.Certificate[0], served.Certificate[0])
+	})
+}
+
+func TestNewReloader_MissingFiles(t *testing.T) {
+	_, err := NewReloader(filepath.Join(t.TempDir(), "cert.pem"), filepath.Join(t.TempDir(), "key.pem"))
+	assert.Error(t, err)
+}
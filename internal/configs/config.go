@@ -2,7 +2,17 @@ package configs
 
 import (
 	"flag"
+	"fmt"
+	"net"
 	"os"
+	"strings"
+	"time"
+
+	"github.com/maxzhirnov/urlshort/internal/auth"
+	"github.com/maxzhirnov/urlshort/internal/logging"
+	"github.com/maxzhirnov/urlshort/internal/models"
+	"github.com/maxzhirnov/urlshort/internal/ratelimit"
+	"github.com/maxzhirnov/urlshort/internal/tracing"
 )
 
 type logger interface {
@@ -14,28 +24,72 @@ type logger interface {
 }
 
 const (
-	serverAddrFlag      = "a"
-	baseURLFlag         = "b"
-	fileStoragePathFlag = "f"
-	postgresConnFlag    = "d"
-
-	defaultServerAddr      = "localhost:8080"
-	defaultBaseURL         = "http://" + defaultServerAddr
-	defaultFileStoragePath = "/tmp/short-url-db.json"
-	defaultPostgresConn    = ""
-
-	serverAddrFlagUsageMessage  = "Provide server address"
-	baseURLFlagUsageMessage     = "Provide domain which will be used for serving shorten URLs"
-	fileStoragePathUsageMessage = "Provide full path to the file where urls data will be saved"
-	postgresConnUsageMessage    = "Provide PostgreSQL DB connection string"
+	configFileFlag  = "c"
+	printConfigFlag = "print-config"
+
+	defaultServerAddr       = "localhost:8080"
+	defaultFileStoragePath  = "/tmp/short-url-db.json"
+	defaultRateCreateUser   = "60/m"
+	defaultRateCreateIP     = "300/m"
+	defaultRateRedirectUser = ""
+	defaultRateRedirectIP   = "1200/m"
+	defaultCompressMinSize  = 512
+	defaultMaxBodySize      = 10 << 20
+	defaultTraceSampleRatio = "1"
+	defaultShutdownTimeout  = "10s"
+	defaultLogLevel         = "info"
+	defaultLogSampleInitial = "0"
+	defaultLogSampleAfter   = "100"
+	defaultDeletionInterval = "10s"
+
+	configFileUsageMessage  = "Provide path to JSON, YAML or TOML config file, env and flags override values from it"
+	printConfigUsageMessage = "Print effective configuration with secrets redacted and exit"
 )
 
 type Config struct {
-	serverAddr      string
-	baseURL         string
-	fileStoragePath string
-	postgresConn    string
-	logger          logger
+	serverAddr       string
+	baseURL          string
+	fileStoragePath  string
+	postgresConn     string
+	jwtKeysFile      string
+	jwtKeys          []auth.Key
+	devMode          bool
+	oidcIssuer       string
+	oidcClientID     string
+	oidcSecret       string
+	oidcRedirectURL  string
+	adminIDs         []string
+	adminToken       string
+	cookie           auth.CookieConfig
+	createLimits     RateLimits
+	redirectLimits   RateLimits
+	compressMinSize  int
+	maxBodySize      int64
+	metricsAddr      string
+	tracing          tracing.Config
+	trustedSubnets   []*net.IPNet
+	trustedProxies   []*net.IPNet
+	shutdownTimeout  time.Duration
+	drainDelay       time.Duration
+	tlsCertFile      string
+	tlsKeyFile       string
+	devTLS           bool
+	grpcAddr         string
+	logging          logging.Config
+	deletionInterval time.Duration
+	redirect         models.RedirectOptions
+	logger           logger
+
+	// settings are raw values the config was built from, with their sources
+	settings    settings
+	loader      loader
+	printConfig bool
+}
+
+// RateLimits are token bucket limits applied to a group of endpoints
+type RateLimits struct {
+	PerUser ratelimit.Limit
+	PerIP   ratelimit.Limit
 }
 
 type Builder struct {
@@ -62,61 +116,182 @@ func (b *Builder) WithPostgresConn(postgresConn string) *Builder {
 	return b
 }
 
-func NewFromFlags(logger logger) (*Config, error) {
-	var serverAddr string
-	flag.StringVar(&serverAddr, serverAddrFlag, defaultServerAddr, serverAddrFlagUsageMessage)
+func (b *Builder) WithJWTKeysFile(jwtKeysFile string) *Builder {
+	b.config.jwtKeysFile = jwtKeysFile
+	return b
+}
 
-	var baseURL string
-	flag.StringVar(&baseURL, baseURLFlag, defaultBaseURL, baseURLFlagUsageMessage)
+// WithJWTKeys sets signing keys given inline, the first key signs new tokens
+func (b *Builder) WithJWTKeys(keys []auth.Key) *Builder {
+	b.config.jwtKeys = keys
+	return b
+}
 
-	var fileStoragePath string
-	flag.StringVar(&fileStoragePath, fileStoragePathFlag, defaultFileStoragePath, fileStoragePathUsageMessage)
+func (b *Builder) WithDevMode(devMode bool) *Builder {
+	b.config.devMode = devMode
+	return b
+}
 
-	var postgresConn string
-	flag.StringVar(&postgresConn, postgresConnFlag, defaultPostgresConn, postgresConnUsageMessage)
+func (b *Builder) WithOIDC(issuer, clientID, secret, redirectURL string) *Builder {
+	b.config.oidcIssuer = issuer
+	b.config.oidcClientID = clientID
+	b.config.oidcSecret = secret
+	b.config.oidcRedirectURL = redirectURL
+	return b
+}
 
-	flag.Parse()
+func (b *Builder) WithAdmins(adminIDs []string, adminToken string) *Builder {
+	b.config.adminIDs = adminIDs
+	b.config.adminToken = adminToken
+	return b
+}
 
-	var builder Builder
-	builder.WithServerAddr(serverAddr).
-		WithBaseURL(baseURL).
-		WithFileStoragePath(fileStoragePath).
-		WithPostgresConn(postgresConn)
+func (b *Builder) WithCookie(cookie auth.CookieConfig) *Builder {
+	b.config.cookie = cookie
+	return b
+}
 
-	if v, ok := os.LookupEnv("SERVER_ADDRESS"); ok {
-		logger.Debug("successfully parsed SERVER_ADDRESS from env")
-		builder.WithServerAddr(v)
-	} else {
-		logger.Warn("couldn't parse SERVER_ADDRESS from env")
-	}
+func (b *Builder) WithRateLimits(create, redirect RateLimits) *Builder {
+	b.config.createLimits = create
+	b.config.redirectLimits = redirect
+	return b
+}
+
+func (b *Builder) WithCompression(minSize int, maxBodySize int64) *Builder {
+	b.config.compressMinSize = minSize
+	b.config.maxBodySize = maxBodySize
+	return b
+}
+
+func (b *Builder) WithMetricsAddr(metricsAddr string) *Builder {
+	b.config.metricsAddr = metricsAddr
+	return b
+}
+
+func (b *Builder) WithTracing(tracing tracing.Config) *Builder {
+	b.config.tracing = tracing
+	return b
+}
+
+func (b *Builder) WithTrustedSubnets(subnets []*net.IPNet) *Builder {
+	b.config.trustedSubnets = subnets
+	return b
+}
+
+func (b *Builder) WithTrustedProxies(proxies []*net.IPNet) *Builder {
+	b.config.trustedProxies = proxies
+	return b
+}
 
-	if v, ok := os.LookupEnv("BASE_URL"); ok {
-		logger.Debug("successfully parsed BASE_URL from env")
-		builder.WithBaseURL(v)
-	} else {
-		logger.Warn("couldn't parse BASE_URL from env")
+func (b *Builder) WithShutdownTimeout(timeout time.Duration) *Builder {
+	b.config.shutdownTimeout = timeout
+	return b
+}
+
+func (b *Builder) WithDrainDelay(delay time.Duration) *Builder {
+	b.config.drainDelay = delay
+	return b
+}
+
+func (b *Builder) WithTLS(certFile, keyFile string, devTLS bool) *Builder {
+	b.config.tlsCertFile = certFile
+	b.config.tlsKeyFile = keyFile
+	b.config.devTLS = devTLS
+	return b
+}
+
+func (b *Builder) WithGRPCAddr(grpcAddr string) *Builder {
+	b.config.grpcAddr = grpcAddr
+	return b
+}
+func (b *Builder) WithLogging(logging logging.Config) *Builder {
+	b.config.logging = logging
+	return b
+}
+
+func (b *Builder) WithDeletionInterval(interval time.Duration) *Builder {
+	b.config.deletionInterval = interval
+	return b
+}
+
+func (b *Builder) WithRedirectDefaults(redirect models.RedirectOptions) *Builder {
+	b.config.redirect = redirect
+	return b
+}
+
+// NewFromFlags loads configuration from defaults, config file given by -c or CONFIG env,
+// env and command line flags, each of them overrides the previous ones
+func NewFromFlags(logger logger) (*Config, error) {
+	return newFromArgs(flag.CommandLine, os.Args[1:], os.LookupEnv, logger)
+}
+
+func newFromArgs(fs *flag.FlagSet, args []string, lookupEnv func(string) (string, bool), logger logger) (*Config, error) {
+	keysByFlag := make(map[string]string)
+	for _, o := range options {
+		if o.flag == "" {
+			continue
+		}
+		fs.Var(&optionValue{value: o.def, isBool: o.isBool}, o.flag, o.usage)
+		keysByFlag[o.flag] = o.key
 	}
+	var configFile string
+	fs.StringVar(&configFile, configFileFlag, "", configFileUsageMessage)
+	var printConfig bool
+	fs.BoolVar(&printConfig, printConfigFlag, false, printConfigUsageMessage)
 
-	if v, ok := os.LookupEnv("FILE_STORAGE_PATH"); ok {
-		logger.Debug("successfully parsed FILE_STORAGE_PATH from env")
-		builder.WithFileStoragePath(v)
-	} else {
-		logger.Warn("couldn't parse FILE_STORAGE_PATH from env")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
 	}
 
-	if v, ok := os.LookupEnv("POSTGRES_CONN"); ok {
-		logger.Debug("successfully parsed POSTGRES_CONN from env")
-		builder.WithPostgresConn(v)
-	} else {
-		logger.Warn("couldn't parse POSTGRES_CONN from env")
+	l := loader{
+		file:      configFile,
+		flags:     make(map[string]string),
+		lookupEnv: lookupEnv,
+	}
+	// Учитываются только явно переданные флаги, иначе их значения по умолчанию перекрыли бы env и файл
+	fs.Visit(func(f *flag.Flag) {
+		if key, ok := keysByFlag[f.Name]; ok {
+			l.flags[key] = f.Value.String()
+		}
+	})
+	if l.file == "" {
+		l.file, _ = lookupEnv("CONFIG")
+	}
+	if l.file != "" {
+		logger.Debug("loading config file", "path", l.file)
 	}
 
-	cfg := &builder.config
+	cfg, err := l.load()
+	if err != nil {
+		return nil, err
+	}
 	cfg.logger = logger
-
+	cfg.printConfig = printConfig
 	return cfg, nil
 }
 
+// optionValue keeps flag value as is, it's parsed later together with values from other sources
+type optionValue struct {
+	value  string
+	isBool bool
+}
+
+func (v *optionValue) String() string {
+	if v == nil {
+		return ""
+	}
+	return v.value
+}
+
+func (v *optionValue) Set(s string) error {
+	v.value = s
+	return nil
+}
+
+func (v *optionValue) IsBoolFlag() bool {
+	return v.isBool
+}
+
 func (c Config) ServerAddr() string {
 	return c.serverAddr
 }
@@ -140,3 +315,186 @@ func (c Config) ShouldUsePostgres() bool {
 func (c Config) PostgresConn() string {
 	return c.postgresConn
 }
+
+func (c Config) JWTKeysFile() string {
+	return c.jwtKeysFile
+}
+
+// JWTKeys are signing keys given inline instead of the keys file, the first key signs new tokens
+func (c Config) JWTKeys() []auth.Key {
+	return c.jwtKeys
+}
+
+// JWTKeyring builds keyring from inline keys, it's nil when keys are not set
+func (c Config) JWTKeyring() (*auth.Keyring, error) {
+	if len(c.jwtKeys) == 0 {
+		return nil, nil
+	}
+	return auth.NewKeyring(c.jwtKeys[0].ID, c.jwtKeys...)
+}
+
+func (c Config) DevMode() bool {
+	return c.devMode
+}
+
+func (c Config) ShouldUseOIDC() bool {
+	return c.oidcIssuer != "" && c.oidcClientID != ""
+}
+
+func (c Config) OIDCIssuer() string {
+	return c.oidcIssuer
+}
+
+func (c Config) OIDCClientID() string {
+	return c.oidcClientID
+}
+
+func (c Config) OIDCClientSecret() string {
+	return c.oidcSecret
+}
+
+func (c Config) OIDCRedirectURL() string {
+	if c.oidcRedirectURL != "" {
+		return c.oidcRedirectURL
+	}
+	return c.baseURL + "/api/auth/oidc/callback"
+}
+
+func (c Config) AdminIDs() []string {
+	return c.adminIDs
+}
+
+func (c Config) AdminToken() string {
+	return c.adminToken
+}
+
+// splitList splits comma separated list skipping empty items
+func splitList(s string) []string {
+	items := make([]string, 0)
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// parseSubnets parses comma separated list of CIDRs
+func parseSubnets(s string) ([]*net.IPNet, error) {
+	items := splitList(s)
+	subnets := make([]*net.IPNet, 0, len(items))
+	for _, item := range items {
+		_, subnet, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing trusted subnet: %w", err)
+		}
+		subnets = append(subnets, subnet)
+	}
+	return subnets, nil
+}
+
+func (c Config) Cookie() auth.CookieConfig {
+	return c.cookie
+}
+
+// CreateRateLimits are limits of link creation endpoints
+func (c Config) CreateRateLimits() RateLimits {
+	return c.createLimits
+}
+
+func (c Config) RedirectRateLimits() RateLimits {
+	return c.redirectLimits
+}
+
+// CompressMinSize is the smallest response body which is compressed
+func (c Config) CompressMinSize() int {
+	return c.compressMinSize
+}
+
+// MaxBodySize limits size of decompressed request bodies
+func (c Config) MaxBodySize() int64 {
+	return c.maxBodySize
+}
+
+// MetricsAddr is the address of separate metrics server, empty when metrics are served on the main server
+func (c Config) MetricsAddr() string {
+	return c.metricsAddr
+}
+
+func (c Config) Tracing() tracing.Config {
+	return c.tracing
+}
+
+// TrustedSubnets are networks allowed to call internal endpoints
+func (c Config) TrustedSubnets() []*net.IPNet {
+	return c.trustedSubnets
+}
+
+// TrustedProxies are reverse proxies allowed to set client IP for rate limits and logs
+func (c Config) TrustedProxies() []*net.IPNet {
+	return c.trustedProxies
+}
+
+// ShutdownTimeout limits graceful shutdown, after it remaining requests are cut off
+func (c Config) ShutdownTimeout() time.Duration {
+	return c.shutdownTimeout
+}
+
+// DrainDelay is how long readiness check reports draining before the server stops
+// accepting connections, it lets load balancers take the instance out of rotation
+func (c Config) DrainDelay() time.Duration {
+	return c.drainDelay
+}
+
+// TLSEnabled reports whether server serves HTTPS
+func (c Config) TLSEnabled() bool {
+	return c.tlsCertFile != "" || c.devTLS
+}
+
+func (c Config) TLSCertFile() string {
+	return c.tlsCertFile
+}
+
+func (c Config) TLSKeyFile() string {
+	return c.tlsKeyFile
+}
+
+// DevTLS means self-signed certificate is generated on start
+func (c Config) DevTLS() bool {
+	return c.devTLS
+}
+
+// ConfigFile is the path of config file, empty when configuration comes from env and flags only
+func (c Config) ConfigFile() string {
+	return c.loader.file
+}
+
+// PrintConfig means effective configuration should be printed instead of starting the server
+func (c Config) PrintConfig() bool {
+	return c.printConfig
+}
+
+// GRPCAddr is the address of gRPC server, empty when gRPC API is disabled
+func (c Config) GRPCAddr() string {
+	return c.grpcAddr
+}
+
+// Logging configures logger backend, level, format and sampling
+func (c Config) Logging() logging.Config {
+	return c.logging
+}
+
+// LogLevel is one of debug, info, warn or error
+func (c Config) LogLevel() string {
+	return c.logging.Level
+}
+
+// DeletionInterval is how often deleted links are written to storage in batch
+func (c Config) DeletionInterval() time.Duration {
+	return c.deletionInterval
+}
+
+// RedirectDefaults are redirect code and headers of links which don't set their own
+func (c Config) RedirectDefaults() models.RedirectOptions {
+	return c.redirect
+}
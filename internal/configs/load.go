@@ -0,0 +1,508 @@
+package configs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/maxzhirnov/urlshort/internal/auth"
+	"github.com/maxzhirnov/urlshort/internal/logging"
+	"github.com/maxzhirnov/urlshort/internal/models"
+	"github.com/maxzhirnov/urlshort/internal/ratelimit"
+	"github.com/maxzhirnov/urlshort/internal/tracing"
+)
+
+// Источники настроек в порядке возрастания приоритета
+const (
+	sourceDefault = "default"
+	sourceFile    = "file"
+	sourceEnv     = "env"
+	sourceFlag    = "flag"
+	// sourceDerived values are computed from other settings, e.g. base url from server address
+	sourceDerived = "derived"
+)
+
+const redacted = "REDACTED"
+
+type setting struct {
+	value  string
+	source string
+}
+
+// settings are raw values of every option keyed by option key
+type settings map[string]setting
+
+// describe names the setting and where it came from for error messages
+func (s settings) describe(key string) string {
+	o, _ := lookupOption(key)
+	switch s[key].source {
+	case sourceFile:
+		return key + " (from config file)"
+	case sourceEnv:
+		return fmt.Sprintf("%s (from env %s)", key, o.env)
+	case sourceFlag:
+		return fmt.Sprintf("%s (from flag -%s)", key, o.flag)
+	default:
+		return key
+	}
+}
+
+// loader remembers config file and command line, so the config can be loaded again
+type loader struct {
+	file      string
+	flags     map[string]string
+	lookupEnv func(string) (string, bool)
+}
+
+// settings merges defaults, config file, env and flags, later sources override earlier ones
+func (l loader) settings() (settings, error) {
+	s := make(settings, len(options))
+	for _, o := range options {
+		s[o.key] = setting{value: o.def, source: sourceDefault}
+	}
+
+	if l.file != "" {
+		values, err := readFile(l.file)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range values {
+			s[k] = setting{value: v, source: sourceFile}
+		}
+	}
+
+	for _, o := range options {
+		if v, ok := l.lookupEnv(o.env); ok {
+			s[o.key] = setting{value: v, source: sourceEnv}
+		}
+	}
+
+	for k, v := range l.flags {
+		s[k] = setting{value: v, source: sourceFlag}
+	}
+	return s, nil
+}
+
+func (l loader) load() (*Config, error) {
+	s, err := l.settings()
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := s.build()
+	if err != nil {
+		return nil, err
+	}
+	cfg.loader = l
+	return cfg, nil
+}
+
+// parser converts raw settings and collects every error, so all mistakes are reported at once
+type parser struct {
+	settings settings
+	errs     []error
+}
+
+func (p *parser) fail(key string, err error) {
+	p.errs = append(p.errs, fmt.Errorf("%s: %w", p.settings.describe(key), err))
+}
+
+func (p *parser) string(key string) string {
+	return p.settings[key].value
+}
+
+func (p *parser) list(key string) []string {
+	return splitList(p.settings[key].value)
+}
+
+// jwtKeys parses comma separated id:secret pairs
+func (p *parser) jwtKeys(key string) []auth.Key {
+	items := p.list(key)
+	if len(items) == 0 {
+		return nil
+	}
+	keys := make([]auth.Key, 0, len(items))
+	for i, item := range items {
+		id, secret, ok := strings.Cut(item, ":")
+		if !ok || id == "" || secret == "" {
+			// Сам секрет в ошибку не попадает
+			p.fail(key, fmt.Errorf("key %d should be in id:secret form", i))
+			return nil
+		}
+		keys = append(keys, auth.Key{ID: id, Secret: secret})
+	}
+	return keys
+}
+
+func (p *parser) bool(key string) bool {
+	v := p.settings[key].value
+	if v == "" {
+		return false
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		p.fail(key, fmt.Errorf("%q isn't a boolean", v))
+	}
+	return b
+}
+
+func (p *parser) int(key string) int64 {
+	v := p.settings[key].value
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		p.fail(key, fmt.Errorf("%q isn't an integer", v))
+	}
+	return n
+}
+
+func (p *parser) float(key string) float64 {
+	v := p.settings[key].value
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		p.fail(key, fmt.Errorf("%q isn't a number", v))
+	}
+	return f
+}
+
+func (p *parser) duration(key string) time.Duration {
+	v := p.settings[key].value
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		p.fail(key, fmt.Errorf("%q isn't a duration, e.g. 10s", v))
+	}
+	return d
+}
+
+func (p *parser) limit(key string) ratelimit.Limit {
+	l, err := ratelimit.ParseLimit(p.settings[key].value)
+	if err != nil {
+		p.fail(key, err)
+	}
+	return l
+}
+
+// address checks host:port, empty address is allowed for optional servers
+func (p *parser) address(key string) string {
+	v := p.settings[key].value
+	if v == "" {
+		return ""
+	}
+	_, port, err := net.SplitHostPort(v)
+	if err != nil {
+		p.fail(key, err)
+		return v
+	}
+	if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		p.fail(key, fmt.Errorf("invalid port %q", port))
+	}
+	return v
+}
+
+// absoluteURL checks that URL has http(s) scheme and host and doesn't end with slash
+func (p *parser) absoluteURL(key string) string {
+	v := p.settings[key].value
+	if v == "" {
+		return ""
+	}
+	u, err := url.Parse(v)
+	switch {
+	case err != nil:
+		p.fail(key, err)
+	case u.Scheme != "http" && u.Scheme != "https":
+		p.fail(key, fmt.Errorf("%q should be absolute http or https URL", v))
+	case u.Host == "":
+		p.fail(key, fmt.Errorf("%q has no host", v))
+	case strings.HasSuffix(v, "/"):
+		p.fail(key, fmt.Errorf("%q shouldn't end with slash", v))
+	case u.RawQuery != "" || u.Fragment != "":
+		p.fail(key, fmt.Errorf("%q shouldn't have query or fragment", v))
+	}
+	return v
+}
+
+func (p *parser) positive(key string, d time.Duration) {
+	if d <= 0 {
+		p.fail(key, errors.New("should be positive"))
+	}
+}
+
+// build parses and validates settings
+func (s settings) build() (*Config, error) {
+	p := &parser{settings: s}
+	var builder Builder
+
+	builder.WithServerAddr(p.address(keyServerAddr)).
+		WithFileStoragePath(p.string(keyFileStoragePath)).
+		WithPostgresConn(p.string(keyPostgresConn)).
+		WithJWTKeysFile(p.string(keyJWTKeysFile)).
+		WithDevMode(p.bool(keyDevMode)).
+		WithAdmins(p.list(keyAdminIDs), p.string(keyAdminToken)).
+		WithMetricsAddr(p.address(keyMetricsAddr)).
+		WithGRPCAddr(p.address(keyGRPCAddr)).
+		WithDeletionInterval(p.duration(keyDeletionInterval)).
+		WithShutdownTimeout(p.duration(keyShutdownTimeout)).
+		WithDrainDelay(p.duration(keyDrainDelay)).
+		WithTLS(p.string(keyTLSCertFile), p.string(keyTLSKeyFile), p.bool(keyDevTLS))
+	cfg := &builder.config
+
+	if s[keyPostgresConn].value != "" && s[keyFileStoragePath].source != sourceDefault && s[keyFileStoragePath].value != "" {
+		p.fail(keyFileStoragePath, fmt.Errorf("can't be used together with %s", keyPostgresConn))
+	}
+	builder.WithJWTKeys(p.jwtKeys(keyJWTKeys))
+	if len(cfg.jwtKeys) > 0 && cfg.jwtKeysFile != "" {
+		p.fail(keyJWTKeys, fmt.Errorf("can't be used together with %s", keyJWTKeysFile))
+	}
+	p.positive(keyShutdownTimeout, cfg.shutdownTimeout)
+	p.positive(keyDeletionInterval, cfg.deletionInterval)
+
+	builder.WithLogging(logging.Config{
+		Backend: p.string(keyLogBackend),
+		Level:   strings.ToLower(p.string(keyLogLevel)),
+		Format:  p.string(keyLogFormat),
+		Sampling: logging.Sampling{
+			Initial:    int(p.int(keyLogSampleInitial)),
+			Thereafter: int(p.int(keyLogSampleAfter)),
+		},
+	})
+	switch cfg.logging.Level {
+	case "debug", "info", "warn", "warning", "error":
+	default:
+		p.fail(keyLogLevel, fmt.Errorf("unknown log level %q, use debug, info, warn or error", cfg.logging.Level))
+	}
+	if !logging.ValidBackend(cfg.logging.Backend) {
+		p.fail(keyLogBackend, fmt.Errorf("unknown log backend %q, use logrus or zap", cfg.logging.Backend))
+	}
+	if !logging.ValidFormat(cfg.logging.Format) {
+		p.fail(keyLogFormat, fmt.Errorf("unknown log format %q, use json or console", cfg.logging.Format))
+	}
+	if cfg.logging.Sampling.Initial < 0 {
+		p.fail(keyLogSampleInitial, errors.New("shouldn't be negative"))
+	}
+	if cfg.logging.Sampling.Thereafter < 0 {
+		p.fail(keyLogSampleAfter, errors.New("shouldn't be negative"))
+	}
+	if cfg.drainDelay < 0 {
+		p.fail(keyDrainDelay, errors.New("shouldn't be negative"))
+	}
+	for _, key := range []string{keyMetricsAddr, keyGRPCAddr} {
+		if v := s[key].value; v != "" && v == cfg.serverAddr {
+			p.fail(key, fmt.Errorf("should differ from %s", keyServerAddr))
+		}
+	}
+	if v := s[keyGRPCAddr].value; v != "" && v == s[keyMetricsAddr].value {
+		p.fail(keyGRPCAddr, fmt.Errorf("should differ from %s", keyMetricsAddr))
+	}
+
+	if (cfg.tlsCertFile == "") != (cfg.tlsKeyFile == "") {
+		p.fail(keyTLSKeyFile, fmt.Errorf("both %s and %s should be provided", keyTLSCertFile, keyTLSKeyFile))
+	}
+	if cfg.devTLS && cfg.tlsCertFile != "" {
+		p.fail(keyDevTLS, fmt.Errorf("can't be used together with %s", keyTLSCertFile))
+	}
+
+	if s[keyBaseURL].value == "" {
+		scheme := "http://"
+		if cfg.TLSEnabled() {
+			scheme = "https://"
+		}
+		s[keyBaseURL] = setting{value: scheme + publicAddr(cfg.serverAddr), source: sourceDerived}
+	}
+	builder.WithBaseURL(p.absoluteURL(keyBaseURL))
+
+	builder.WithOIDC(p.string(keyOIDCIssuer), p.string(keyOIDCClientID), p.string(keyOIDCSecret), p.absoluteURL(keyOIDCRedirectURL))
+	if (cfg.oidcIssuer == "") != (cfg.oidcClientID == "") {
+		p.fail(keyOIDCClientID, fmt.Errorf("both %s and %s should be provided", keyOIDCIssuer, keyOIDCClientID))
+	}
+	p.absoluteURL(keyOIDCIssuer)
+
+	cookie := auth.CookieConfig{
+		Name:   p.string(keyCookieName),
+		Domain: p.string(keyCookieDomain),
+		Path:   p.string(keyCookiePath),
+		Secure: p.bool(keyCookieSecure),
+		MaxAge: p.duration(keyCookieMaxAge),
+	}
+	if cookie.Name == "" {
+		p.fail(keyCookieName, errors.New("shouldn't be empty"))
+	}
+	p.positive(keyCookieMaxAge, cookie.MaxAge)
+	if cfg.TLSEnabled() && !cookie.Secure {
+		// Cookie с токеном по HTTPS всегда отправляется с флагом Secure
+		cookie.Secure = true
+		s[keyCookieSecure] = setting{value: "true", source: sourceDerived}
+	}
+	sameSite, err := auth.ParseSameSite(p.string(keyCookieSameSite))
+	if err != nil {
+		p.fail(keyCookieSameSite, err)
+	}
+	if sameSite == http.SameSiteNoneMode && !cookie.Secure {
+		p.fail(keyCookieSameSite, errors.New("cookie with SameSite=None should be secure"))
+	}
+	cookie.SameSite = sameSite
+	builder.WithCookie(cookie)
+
+	builder.WithRateLimits(
+		RateLimits{PerUser: p.limit(keyRateCreateUser), PerIP: p.limit(keyRateCreateIP)},
+		RateLimits{PerUser: p.limit(keyRateRedirectUser), PerIP: p.limit(keyRateRedirectIP)},
+	)
+
+	builder.WithRedirectDefaults(models.RedirectOptions{
+		RedirectCode:   int(p.int(keyRedirectCode)),
+		CacheControl:   p.string(keyCacheControl),
+		ReferrerPolicy: p.string(keyReferrerPolicy),
+		QueryMode:      models.PassthroughMode(p.string(keyQueryMode)),
+		PathMode:       models.PassthroughMode(p.string(keyPathMode)),
+	})
+	if !models.ValidRedirectCode(cfg.redirect.RedirectCode) {
+		p.fail(keyRedirectCode, fmt.Errorf("should be 301, 302, 307 or 308, got %d", cfg.redirect.RedirectCode))
+	}
+	if err := (models.RedirectOptions{CacheControl: cfg.redirect.CacheControl}).Validate(); err != nil {
+		p.fail(keyCacheControl, err)
+	}
+	if err := (models.RedirectOptions{ReferrerPolicy: cfg.redirect.ReferrerPolicy}).Validate(); err != nil {
+		p.fail(keyReferrerPolicy, err)
+	}
+	if !cfg.redirect.QueryMode.Valid() {
+		p.fail(keyQueryMode, fmt.Errorf("should be append, override or ignore, got %q", cfg.redirect.QueryMode))
+	}
+	if !cfg.redirect.PathMode.Valid() {
+		p.fail(keyPathMode, fmt.Errorf("should be append, override or ignore, got %q", cfg.redirect.PathMode))
+	}
+
+	builder.WithCompression(int(p.int(keyCompressMinSize)), p.int(keyMaxBodySize))
+	if cfg.compressMinSize < 0 {
+		p.fail(keyCompressMinSize, errors.New("shouldn't be negative"))
+	}
+	if cfg.maxBodySize <= 0 {
+		p.fail(keyMaxBodySize, errors.New("should be positive"))
+	}
+
+	builder.WithTracing(tracing.Config{
+		Exporter:    p.string(keyTraceExporter),
+		Endpoint:    p.string(keyTraceEndpoint),
+		Insecure:    p.bool(keyTraceInsecure),
+		FilePath:    p.string(keyTraceFile),
+		SampleRatio: p.float(keyTraceSampleRatio),
+	})
+	if !tracing.ValidExporter(cfg.tracing.Exporter) {
+		p.fail(keyTraceExporter, fmt.Errorf("unknown trace exporter %q", cfg.tracing.Exporter))
+	}
+	if r := cfg.tracing.SampleRatio; r < 0 || r > 1 {
+		p.fail(keyTraceSampleRatio, fmt.Errorf("should be from 0 to 1, got %v", r))
+	}
+
+	subnets, err := parseSubnets(p.string(keyTrustedSubnets))
+	if err != nil {
+		p.fail(keyTrustedSubnets, err)
+	}
+	builder.WithTrustedSubnets(subnets)
+
+	proxies, err := parseSubnets(p.string(keyTrustedProxies))
+	if err != nil {
+		p.fail(keyTrustedProxies, err)
+	}
+	builder.WithTrustedProxies(proxies)
+
+	if len(p.errs) > 0 {
+		return nil, fmt.Errorf("invalid configuration: %w", errors.Join(p.errs...))
+	}
+	cfg.settings = s
+	return cfg, nil
+}
+
+// Reload loads configuration again from the same config file, env and flags
+func (c Config) Reload() (*Config, error) {
+	next, err := c.loader.load()
+	if err != nil {
+		return nil, err
+	}
+	next.logger = c.logger
+	return next, nil
+}
+
+// Changes lists keys whose values differ in next config. Reloadable keys can be applied
+// to the running server, others take effect only after restart
+func (c Config) Changes(next *Config) (reloadable, restart []string) {
+	for _, o := range options {
+		if c.settings[o.key].value == next.settings[o.key].value {
+			continue
+		}
+		if o.reloadable {
+			reloadable = append(reloadable, o.key)
+		} else {
+			restart = append(restart, o.key)
+		}
+	}
+	return reloadable, restart
+}
+
+// publicAddr replaces wildcard listen host with localhost, e.g. :8080 becomes localhost:8080
+func publicAddr(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	if ip := net.ParseIP(host); host == "" || ip != nil && ip.IsUnspecified() {
+		host = "localhost"
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// WriteEffective writes configuration in effect as JSON, the output can be used as config file.
+// Secrets are redacted
+func (c Config) WriteEffective(w io.Writer) error {
+	root := make(map[string]interface{})
+	for _, o := range options {
+		var value interface{} = c.settings[o.key].value
+		switch {
+		case o.secret && o.key == keyPostgresConn:
+			value = redactDSN(c.settings[o.key].value)
+		case o.secret && value != "":
+			value = redacted
+		case o.isBool:
+			value, _ = strconv.ParseBool(c.settings[o.key].value)
+		}
+
+		section := root
+		path := strings.Split(o.key, ".")
+		for _, name := range path[:len(path)-1] {
+			next, ok := section[name].(map[string]interface{})
+			if !ok {
+				next = make(map[string]interface{})
+				section[name] = next
+			}
+			section = next
+		}
+		section[path[len(path)-1]] = value
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(root)
+}
+
+var dsnPassword = regexp.MustCompile(`(password=)('[^']*'|\S+)`)
+
+// redactDSN hides password in postgres URL or key=value connection string
+func redactDSN(dsn string) string {
+	if u, err := url.Parse(dsn); err == nil && u.Scheme != "" {
+		if u.User != nil {
+			if _, ok := u.User.Password(); ok {
+				u.User = url.UserPassword(u.User.Username(), redacted)
+			}
+		}
+		if q := u.Query(); q.Has("password") {
+			q.Set("password", redacted)
+			u.RawQuery = q.Encode()
+		}
+		return u.String()
+	}
+	return dsnPassword.ReplaceAllString(dsn, "${1}"+redacted)
+}
@@ -0,0 +1,191 @@
+package configs
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/maxzhirnov/urlshort/internal/auth"
+	"github.com/maxzhirnov/urlshort/internal/logging"
+	"github.com/maxzhirnov/urlshort/internal/models"
+	"github.com/maxzhirnov/urlshort/internal/tracing"
+)
+
+// Ключи настроек в конфигурационном файле, точка разделяет секции
+const (
+	keyServerAddr       = "server.address"
+	keyBaseURL          = "server.base_url"
+	keyTrustedSubnets   = "server.trusted_subnets"
+	keyTrustedProxies   = "server.trusted_proxies"
+	keyShutdownTimeout  = "server.shutdown_timeout"
+	keyDrainDelay       = "server.drain_delay"
+	keyFileStoragePath  = "storage.file_path"
+	keyPostgresConn     = "storage.postgres_dsn"
+	keyDevMode          = "dev_mode"
+	keyJWTKeysFile      = "auth.jwt_keys_file"
+	keyJWTKeys          = "auth.jwt_keys"
+	keyAdminIDs         = "auth.admin_ids"
+	keyAdminToken       = "auth.admin_token"
+	keyOIDCIssuer       = "oidc.issuer_url"
+	keyOIDCClientID     = "oidc.client_id"
+	keyOIDCSecret       = "oidc.client_secret"
+	keyOIDCRedirectURL  = "oidc.redirect_url"
+	keyCookieName       = "cookie.name"
+	keyCookieDomain     = "cookie.domain"
+	keyCookiePath       = "cookie.path"
+	keyCookieSecure     = "cookie.secure"
+	keyCookieSameSite   = "cookie.samesite"
+	keyCookieMaxAge     = "cookie.max_age"
+	keyRateCreateUser   = "rate_limit.create_per_user"
+	keyRateCreateIP     = "rate_limit.create_per_ip"
+	keyRateRedirectUser = "rate_limit.redirect_per_user"
+	keyRateRedirectIP   = "rate_limit.redirect_per_ip"
+	keyCompressMinSize  = "compression.min_size"
+	keyMaxBodySize      = "compression.max_body_size"
+	keyMetricsAddr      = "metrics.address"
+	keyTraceExporter    = "tracing.exporter"
+	keyTraceEndpoint    = "tracing.endpoint"
+	keyTraceInsecure    = "tracing.insecure"
+	keyTraceFile        = "tracing.file"
+	keyTraceSampleRatio = "tracing.sample_ratio"
+	keyTLSCertFile      = "tls.cert_file"
+	keyTLSKeyFile       = "tls.key_file"
+	keyDevTLS           = "tls.dev"
+	keyGRPCAddr         = "grpc.address"
+	keyLogLevel         = "log.level"
+	keyLogBackend       = "log.backend"
+	keyLogFormat        = "log.format"
+	keyLogSampleInitial = "log.sampling.initial"
+	keyLogSampleAfter   = "log.sampling.thereafter"
+	keyDeletionInterval = "deletion.interval"
+	keyRedirectCode     = "redirect.code"
+	keyCacheControl     = "redirect.cache_control"
+	keyReferrerPolicy   = "redirect.referrer_policy"
+	keyQueryMode        = "redirect.query_mode"
+	keyPathMode         = "redirect.path_mode"
+)
+
+// option is a setting which can be given in config file, env and command line
+type option struct {
+	key  string
+	env  string
+	flag string // пустой у секретов, чтобы они не попадали в список процессов
+	def  string
+	// isBool options are passed on command line without value, e.g. -dev
+	isBool bool
+	secret bool
+	// reloadable options are applied on SIGHUP without restart
+	reloadable bool
+	usage      string
+}
+
+var defaultCookie = auth.DefaultCookieConfig()
+
+var options = []option{
+	{key: keyServerAddr, env: "SERVER_ADDRESS", flag: "a", def: defaultServerAddr,
+		usage: "Provide server address"},
+	{key: keyBaseURL, env: "BASE_URL", flag: "b",
+		usage: "Provide domain which will be used for serving shorten URLs, defaults to scheme and server address"},
+	{key: keyFileStoragePath, env: "FILE_STORAGE_PATH", flag: "f", def: defaultFileStoragePath,
+		usage: "Provide full path to the file where urls data will be saved, empty keeps data in memory"},
+	{key: keyPostgresConn, env: "POSTGRES_CONN", flag: "d", secret: true,
+		usage: "Provide PostgreSQL DB connection string"},
+	{key: keyJWTKeysFile, env: "JWT_KEYS_FILE", flag: "jwt-keys",
+		usage: "Provide path to the json file with JWT signing keys"},
+	// Ключи в виде id:secret через запятую, первый подписывает новые токены
+	{key: keyJWTKeys, env: "JWT_KEYS", secret: true, reloadable: true},
+	{key: keyDevMode, env: "DEV_MODE", flag: "dev", def: "false", isBool: true,
+		usage: "Run in development mode, allows default JWT secret"},
+	{key: keyOIDCIssuer, env: "OIDC_ISSUER_URL", flag: "oidc-issuer",
+		usage: "Provide OpenID Connect issuer URL to enable SSO login"},
+	{key: keyOIDCClientID, env: "OIDC_CLIENT_ID", flag: "oidc-client-id",
+		usage: "Provide OpenID Connect client ID"},
+	{key: keyOIDCSecret, env: "OIDC_CLIENT_SECRET", secret: true},
+	{key: keyOIDCRedirectURL, env: "OIDC_REDIRECT_URL", flag: "oidc-redirect-url",
+		usage: "Provide OpenID Connect redirect URL, defaults to <base url>/api/auth/oidc/callback"},
+	{key: keyAdminIDs, env: "ADMIN_USER_IDS", flag: "admins",
+		usage: "Provide comma separated user ids granted admin role"},
+	{key: keyAdminToken, env: "ADMIN_TOKEN", secret: true},
+	{key: keyCookieName, env: "COOKIE_NAME", flag: "cookie-name", def: defaultCookie.Name, reloadable: true,
+		usage: "Provide name of the cookie with auth token"},
+	{key: keyCookieDomain, env: "COOKIE_DOMAIN", flag: "cookie-domain", def: defaultCookie.Domain, reloadable: true,
+		usage: "Provide domain of the auth cookie, empty means host-only cookie"},
+	{key: keyCookiePath, env: "COOKIE_PATH", flag: "cookie-path", def: defaultCookie.Path, reloadable: true,
+		usage: "Provide path of the auth cookie"},
+	{key: keyCookieSecure, env: "COOKIE_SECURE", flag: "cookie-secure", def: strconv.FormatBool(defaultCookie.Secure), isBool: true, reloadable: true,
+		usage: "Send auth cookie only over HTTPS"},
+	{key: keyCookieSameSite, env: "COOKIE_SAMESITE", flag: "cookie-samesite", def: "lax", reloadable: true,
+		usage: "Provide SameSite mode of the auth cookie: lax, strict or none"},
+	{key: keyCookieMaxAge, env: "COOKIE_MAX_AGE", flag: "cookie-max-age", def: defaultCookie.MaxAge.String(), reloadable: true,
+		usage: "Provide lifetime of the auth cookie and token, e.g. 720h"},
+	{key: keyRateCreateUser, env: "RATE_CREATE_USER", flag: "rate-create-user", def: defaultRateCreateUser, reloadable: true,
+		usage: "Provide limit of link creation requests per user, e.g. 60/m, 0 disables the limit"},
+	{key: keyRateCreateIP, env: "RATE_CREATE_IP", flag: "rate-create-ip", def: defaultRateCreateIP, reloadable: true,
+		usage: "Provide limit of link creation requests per client IP, e.g. 300/m, 0 disables the limit"},
+	{key: keyRateRedirectUser, env: "RATE_REDIRECT_USER", flag: "rate-redirect-user", def: defaultRateRedirectUser, reloadable: true,
+		usage: "Provide limit of redirects per user, e.g. 600/m, 0 disables the limit"},
+	{key: keyRateRedirectIP, env: "RATE_REDIRECT_IP", flag: "rate-redirect-ip", def: defaultRateRedirectIP, reloadable: true,
+		usage: "Provide limit of redirects per client IP, e.g. 1200/m, 0 disables the limit"},
+	{key: keyCompressMinSize, env: "COMPRESS_MIN_SIZE", flag: "compress-min-size", def: strconv.Itoa(defaultCompressMinSize),
+		usage: "Provide minimal size of response body in bytes which is compressed"},
+	{key: keyMaxBodySize, env: "MAX_BODY_SIZE", flag: "max-body-size", def: strconv.Itoa(defaultMaxBodySize),
+		usage: "Provide maximal size in bytes of decompressed request body"},
+	{key: keyMetricsAddr, env: "METRICS_ADDRESS", flag: "metrics-addr",
+		usage: "Provide separate address for serving /metrics, empty serves it on the main server"},
+	{key: keyTraceExporter, env: "TRACE_EXPORTER", flag: "trace-exporter", def: tracing.ExporterNone,
+		usage: "Provide exporter of traces: otlp, stdout or file, empty disables tracing"},
+	{key: keyTraceEndpoint, env: "TRACE_ENDPOINT", flag: "trace-endpoint",
+		usage: "Provide host:port of OTLP HTTP collector, defaults to OTEL_EXPORTER_OTLP_ENDPOINT"},
+	{key: keyTraceInsecure, env: "TRACE_INSECURE", flag: "trace-insecure", def: "false", isBool: true,
+		usage: "Send traces to OTLP collector without TLS"},
+	{key: keyTraceFile, env: "TRACE_FILE", flag: "trace-file",
+		usage: "Provide path to the file where file exporter writes traces"},
+	{key: keyTraceSampleRatio, env: "TRACE_SAMPLE_RATIO", flag: "trace-sample-ratio", def: defaultTraceSampleRatio,
+		usage: "Provide fraction of new traces which are recorded, from 0 to 1"},
+	{key: keyTrustedSubnets, env: "TRUSTED_SUBNET", flag: "t",
+		usage: "Provide comma separated CIDRs allowed to call internal endpoints, empty denies everyone"},
+	{key: keyTrustedProxies, env: "TRUSTED_PROXIES", flag: "trusted-proxies",
+		usage: "Provide comma separated CIDRs of reverse proxies whose X-Forwarded-For is used as client IP, empty uses address of the connection"},
+	{key: keyShutdownTimeout, env: "SHUTDOWN_TIMEOUT", flag: "shutdown-timeout", def: defaultShutdownTimeout,
+		usage: "Provide time given to in-flight requests and background workers to finish on shutdown"},
+	{key: keyDrainDelay, env: "SHUTDOWN_DRAIN_DELAY", flag: "shutdown-drain-delay", def: "0s",
+		usage: "Provide time between failing readiness check and stopping the server on shutdown"},
+	{key: keyTLSCertFile, env: "TLS_CERT_FILE", flag: "tls-cert",
+		usage: "Provide path to TLS certificate file to serve HTTPS, file is reloaded on change"},
+	{key: keyTLSKeyFile, env: "TLS_KEY_FILE", flag: "tls-key",
+		usage: "Provide path to TLS private key file"},
+	{key: keyDevTLS, env: "DEV_TLS", flag: "dev-tls", def: "false", isBool: true,
+		usage: "Serve HTTPS with in-memory self-signed certificate, for development only"},
+	{key: keyGRPCAddr, env: "GRPC_ADDRESS", flag: "grpc-addr",
+		usage: "Provide address of gRPC server, empty disables gRPC API"},
+	{key: keyLogLevel, env: "LOG_LEVEL", flag: "log-level", def: defaultLogLevel, reloadable: true,
+		usage: "Provide log level: debug, info, warn or error"},
+	{key: keyLogBackend, env: "LOG_BACKEND", flag: "log-backend", def: logging.BackendLogrus,
+		usage: "Provide logging backend: logrus or zap"},
+	{key: keyLogFormat, env: "LOG_FORMAT", flag: "log-format", def: logging.FormatJSON,
+		usage: "Provide log output format: json or console"},
+	{key: keyLogSampleInitial, env: "LOG_SAMPLING_INITIAL", flag: "log-sampling-initial", def: defaultLogSampleInitial,
+		usage: "Provide how many debug and info entries with the same message are written per second before sampling, 0 disables sampling. Access log entries are never sampled"},
+	{key: keyLogSampleAfter, env: "LOG_SAMPLING_THEREAFTER", flag: "log-sampling-thereafter", def: defaultLogSampleAfter,
+		usage: "Provide that every n-th entry is written once sampling started, 0 drops the rest"},
+	{key: keyDeletionInterval, env: "DELETION_INTERVAL", flag: "deletion-interval", def: defaultDeletionInterval, reloadable: true,
+		usage: "Provide how often deleted links are written to storage in batch"},
+	{key: keyRedirectCode, env: "REDIRECT_CODE", flag: "redirect-code", def: strconv.Itoa(http.StatusTemporaryRedirect),
+		usage: "Provide redirect status of links which don't set their own: 301, 302, 307 or 308"},
+	{key: keyCacheControl, env: "REDIRECT_CACHE_CONTROL", flag: "redirect-cache-control",
+		usage: "Provide Cache-Control header of redirects for links which don't set their own, empty omits the header"},
+	{key: keyReferrerPolicy, env: "REDIRECT_REFERRER_POLICY", flag: "redirect-referrer-policy",
+		usage: "Provide Referrer-Policy header of redirects for links which don't set their own, empty omits the header"},
+	{key: keyQueryMode, env: "REDIRECT_QUERY_MODE", flag: "redirect-query-mode", def: string(models.PassthroughIgnore),
+		usage: "Provide how query of the short link request is passed to original url for links which don't set their own: append, override or ignore"},
+	{key: keyPathMode, env: "REDIRECT_PATH_MODE", flag: "redirect-path-mode", def: string(models.PassthroughIgnore),
+		usage: "Provide how trailing path of the short link request is passed to original url for links which don't set their own: append, override or ignore"},
+}
+
+func lookupOption(key string) (option, bool) {
+	for _, o := range options {
+		if o.key == key {
+			return o, true
+		}
+	}
+	return option{}, false
+}
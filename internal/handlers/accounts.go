@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/maxzhirnov/urlshort/internal/auth"
+	"github.com/maxzhirnov/urlshort/internal/models"
+	"github.com/maxzhirnov/urlshort/internal/services"
+)
+
+type credentialsRequest struct {
+	Login    string `json:"login"`
+	Password string `json:"password"`
+	// Merge переносит ссылки текущего анонимного пользователя в аккаунт
+	Merge bool `json:"merge"`
+}
+
+type AccountDTO struct {
+	ID         string `json:"id"`
+	Login      string `json:"login"`
+	MergedURLs int    `json:"merged_urls"`
+}
+
+func (h *Handlers) HandleSignUp(c *gin.Context) {
+	var req credentialsRequest
+	if err := json.NewDecoder(c.Request.Body).Decode(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "you should provide correct data"})
+		return
+	}
+	defer c.Request.Body.Close()
+
+	user, merged, err := h.service.SignUp(c.Request.Context(), req.Login, req.Password, h.mergeSource(c, req.Merge))
+	switch {
+	case errors.Is(err, services.ErrInvalidLogin), errors.Is(err, services.ErrInvalidPassword):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, services.ErrLoginTaken):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		h.logger.Error("error signing up", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "something went wrong"})
+		return
+	}
+
+	if err := h.issueTokenCookie(c, user.ID); err != nil {
+		h.logger.Error("error generating token", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "something went wrong"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, newAccountDTO(user, merged))
+}
+
+func (h *Handlers) HandleLogin(c *gin.Context) {
+	var req credentialsRequest
+	if err := json.NewDecoder(c.Request.Body).Decode(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "you should provide correct data"})
+		return
+	}
+	defer c.Request.Body.Close()
+
+	user, merged, err := h.service.Login(c.Request.Context(), req.Login, req.Password, h.mergeSource(c, req.Merge))
+	if errors.Is(err, services.ErrInvalidCredentials) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		h.logger.Error("error logging in", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "something went wrong"})
+		return
+	}
+
+	if err := h.issueTokenCookie(c, user.ID); err != nil {
+		h.logger.Error("error generating token", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "something went wrong"})
+		return
+	}
+
+	c.JSON(http.StatusOK, newAccountDTO(user, merged))
+}
+
+func newAccountDTO(u models.User, merged int) AccountDTO {
+	return AccountDTO{
+		ID:         u.ID,
+		Login:      u.Login,
+		MergedURLs: merged,
+	}
+}
+
+// mergeSource returns uuid of the current user whose links should be moved to the account
+func (h *Handlers) mergeSource(c *gin.Context, merge bool) string {
+	if !merge {
+		return ""
+	}
+	userID, err := h.resolveUserID(c)
+	if err != nil {
+		return ""
+	}
+	return userID
+}
+
+// issueTokenCookie replaces token cookie with the token of the account, echoes it in header
+// and records the session so the user can see and revoke it later
+func (h *Handlers) issueTokenCookie(c *gin.Context, userID string) error {
+	token, err := h.auth.IssueToken(userID)
+	if err != nil {
+		return err
+	}
+
+	err = h.service.RecordSession(c.Request.Context(), models.Session{
+		ID:        token.ID,
+		UUID:      userID,
+		UserAgent: c.Request.UserAgent(),
+		IssuedAt:  token.IssuedAt,
+		ExpiresAt: token.ExpiresAt,
+	})
+	if err != nil {
+		return err
+	}
+
+	http.SetCookie(c.Writer, h.auth.NewCookie(token.Value))
+	c.Header(auth.TokenHeader, token.Value)
+	c.Set("jwt_token", token.Value)
+	return nil
+}
@@ -0,0 +1,251 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/maxzhirnov/urlshort/internal/models"
+	"github.com/maxzhirnov/urlshort/internal/services"
+)
+
+const adminTokenHeader = "X-Admin-Token"
+
+type AdminURLDTO struct {
+	ID          string `json:"id"`
+	ShortURL    string `json:"short_url"`
+	OriginalURL string `json:"original_url"`
+	UUID        string `json:"uuid"`
+	Deleted     bool   `json:"deleted"`
+	Disabled    bool   `json:"disabled"`
+}
+
+type AdminUserDTO struct {
+	ID         string    `json:"id"`
+	Login      string    `json:"login"`
+	ExternalID string    `json:"external_id,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type AdminUserInfoDTO struct {
+	ID         string        `json:"id"`
+	Registered bool          `json:"registered"`
+	Account    *AdminUserDTO `json:"account,omitempty"`
+	URLs       []AdminURLDTO `json:"urls"`
+	APIKeys    []APIKeyDTO   `json:"api_keys"`
+}
+
+// WithAdmins grants admin role to the listed user ids and to requests carrying the admin token
+func (h *Handlers) WithAdmins(userIDs []string, token string) *Handlers {
+	h.adminIDs = make(map[string]struct{}, len(userIDs))
+	for _, id := range userIDs {
+		h.adminIDs[id] = struct{}{}
+	}
+	h.adminToken = token
+	return h
+}
+
+// RequireAdmin lets through only requests of admins
+func (h *Handlers) RequireAdmin(c *gin.Context) {
+	if h.isAdmin(c) {
+		c.Next()
+		return
+	}
+	c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "admin role required"})
+}
+
+func (h *Handlers) isAdmin(c *gin.Context) bool {
+	if token := c.GetHeader(adminTokenHeader); token != "" && h.adminToken != "" {
+		return subtle.ConstantTimeCompare([]byte(token), []byte(h.adminToken)) == 1
+	}
+	userID, err := h.resolveUserID(c)
+	if err != nil {
+		return false
+	}
+	_, ok := h.adminIDs[userID]
+	return ok
+}
+
+func (h *Handlers) newAdminURLDTO(u models.ShortURL) AdminURLDTO {
+	return AdminURLDTO{
+		ID:          u.ID,
+		ShortURL:    h.baseURL + "/" + u.ID,
+		OriginalURL: u.OriginalURL,
+		UUID:        u.UUID,
+		Deleted:     u.DeletedFlag,
+		Disabled:    u.Disabled,
+	}
+}
+
+func newAdminUserDTO(u models.User) AdminUserDTO {
+	return AdminUserDTO{
+		ID:         u.ID,
+		Login:      u.Login,
+		ExternalID: u.ExternalID,
+		CreatedAt:  u.CreatedAt,
+	}
+}
+
+// HandleAdminSearchURLs searches links of every user by ?q= (id or part of url) and ?uuid=
+func (h *Handlers) HandleAdminSearchURLs(c *gin.Context) {
+	limit, offset, ok := pagination(c)
+	if !ok {
+		return
+	}
+
+	urls, err := h.service.SearchURLs(c.Request.Context(), models.URLFilter{
+		Query:  c.Query("q"),
+		UUID:   c.Query("uuid"),
+		Limit:  limit,
+		Offset: offset,
+	})
+	if err != nil {
+		h.logger.Error("error searching urls", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "something went wrong"})
+		return
+	}
+
+	res := make([]AdminURLDTO, len(urls))
+	for i, u := range urls {
+		res[i] = h.newAdminURLDTO(u)
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+func (h *Handlers) HandleAdminDeleteURLs(c *gin.Context) {
+	var ids []string
+	if err := json.NewDecoder(c.Request.Body).Decode(&ids); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "you should provide correct data"})
+		return
+	}
+	defer c.Request.Body.Close()
+
+	if err := h.service.AdminDeleteURLs(c.Request.Context(), ids); err != nil {
+		h.logger.Error("error deleting urls", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "something went wrong"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// HandleAdminSetURLsDisabled disables or enables links back, disabled links aren't redirected
+func (h *Handlers) HandleAdminSetURLsDisabled(c *gin.Context) {
+	var req struct {
+		IDs      []string `json:"ids"`
+		Disabled bool     `json:"disabled"`
+	}
+	if err := json.NewDecoder(c.Request.Body).Decode(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "you should provide correct data"})
+		return
+	}
+	defer c.Request.Body.Close()
+
+	if err := h.service.SetURLsDisabled(c.Request.Context(), req.IDs, req.Disabled); err != nil {
+		h.logger.Error("error disabling urls", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "something went wrong"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (h *Handlers) HandleAdminListUsers(c *gin.Context) {
+	limit, offset, ok := pagination(c)
+	if !ok {
+		return
+	}
+
+	users, err := h.service.ListUsers(c.Request.Context(), c.Query("q"), limit, offset)
+	if err != nil {
+		h.logger.Error("error listing users", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "something went wrong"})
+		return
+	}
+
+	res := make([]AdminUserDTO, len(users))
+	for i, u := range users {
+		res[i] = newAdminUserDTO(u)
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+func (h *Handlers) HandleAdminInspectUser(c *gin.Context) {
+	info, err := h.service.InspectUser(c.Request.Context(), c.Param("id"))
+	if errors.Is(err, services.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+	if err != nil {
+		h.logger.Error("error inspecting user", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "something went wrong"})
+		return
+	}
+
+	res := AdminUserInfoDTO{
+		ID:         info.ID,
+		Registered: info.Registered,
+		URLs:       make([]AdminURLDTO, len(info.URLs)),
+		APIKeys:    make([]APIKeyDTO, len(info.APIKeys)),
+	}
+	if info.Registered {
+		account := newAdminUserDTO(info.Account)
+		res.Account = &account
+	}
+	for i, u := range info.URLs {
+		res.URLs[i] = h.newAdminURLDTO(u)
+	}
+	for i, k := range info.APIKeys {
+		res.APIKeys[i] = newAPIKeyDTO(k)
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+func (h *Handlers) HandleAdminStats(c *gin.Context) {
+	stats, err := h.service.Stats(c.Request.Context())
+	if err != nil {
+		h.logger.Error("error loading stats", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "something went wrong"})
+		return
+	}
+	c.JSON(http.StatusOK, stats)
+}
+
+// InternalStatsDTO is returned to callers from trusted subnet
+type InternalStatsDTO struct {
+	URLs  int `json:"urls"`
+	Users int `json:"users"`
+}
+
+// HandleInternalStats returns number of links and of distinct users owning them,
+// access is restricted by TrustedSubnetMiddleware
+func (h *Handlers) HandleInternalStats(c *gin.Context) {
+	stats, err := h.service.Stats(c.Request.Context())
+	if err != nil {
+		h.logger.Error("error loading stats", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "something went wrong"})
+		return
+	}
+	c.JSON(http.StatusOK, InternalStatsDTO{URLs: stats.URLs, Users: stats.Users})
+}
+
+// pagination parses ?limit= and ?offset=, responds with 400 if they aren't numbers
+func pagination(c *gin.Context) (limit, offset int, ok bool) {
+	var err error
+	if v := c.Query("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit should be a number"})
+			return 0, 0, false
+		}
+	}
+	if v := c.Query("offset"); v != "" {
+		if offset, err = strconv.Atoi(v); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "offset should be a number"})
+			return 0, 0, false
+		}
+	}
+	return limit, offset, true
+}
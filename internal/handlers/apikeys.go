@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/maxzhirnov/urlshort/internal/models"
+	"github.com/maxzhirnov/urlshort/internal/services"
+)
+
+type APIKeyDTO struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Prefix    string    `json:"prefix"`
+	CreatedAt time.Time `json:"created_at"`
+	Revoked   bool      `json:"revoked"`
+}
+
+func newAPIKeyDTO(k models.APIKey) APIKeyDTO {
+	return APIKeyDTO{
+		ID:        k.ID,
+		Name:      k.Name,
+		Prefix:    k.Prefix,
+		CreatedAt: k.CreatedAt,
+		Revoked:   k.Revoked,
+	}
+}
+
+func (h *Handlers) HandleCreateAPIKey(c *gin.Context) {
+	userID, err := h.resolveUserID(c)
+	if err != nil {
+		h.logger.Error("error parsing user_id", "error", err)
+		h.respondAuthError(c, err)
+		return
+	}
+
+	var reqData struct {
+		Name string `json:"name"`
+	}
+	// Тело запроса необязательно, имя ключа можно не указывать
+	if err := json.NewDecoder(c.Request.Body).Decode(&reqData); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "you should provide correct data"})
+		return
+	}
+	defer c.Request.Body.Close()
+
+	key, plainKey, err := h.service.CreateAPIKey(c.Request.Context(), userID, reqData.Name)
+	if err != nil {
+		h.logger.Error("error creating api key", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "something went wrong"})
+		return
+	}
+
+	response := struct {
+		APIKeyDTO
+		Key string `json:"key"`
+	}{
+		APIKeyDTO: newAPIKeyDTO(key),
+		Key:       plainKey,
+	}
+	c.JSON(http.StatusCreated, response)
+}
+
+func (h *Handlers) HandleListAPIKeys(c *gin.Context) {
+	userID, err := h.resolveUserID(c)
+	if err != nil {
+		h.respondAuthError(c, err)
+		return
+	}
+
+	keys, err := h.service.GetAPIKeys(c.Request.Context(), userID)
+	if err != nil {
+		h.logger.Error("error loading api keys", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "something went wrong"})
+		return
+	}
+
+	res := make([]APIKeyDTO, len(keys))
+	for i, k := range keys {
+		res[i] = newAPIKeyDTO(k)
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+func (h *Handlers) HandleRevokeAPIKey(c *gin.Context) {
+	userID, err := h.resolveUserID(c)
+	if err != nil {
+		h.respondAuthError(c, err)
+		return
+	}
+
+	err = h.service.RevokeAPIKey(c.Request.Context(), c.Param("id"), userID)
+	if errors.Is(err, services.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "api key not found"})
+		return
+	}
+	if err != nil {
+		h.logger.Error("error revoking api key", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "something went wrong"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,358 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/maxzhirnov/urlshort/internal/auth"
+	"github.com/maxzhirnov/urlshort/internal/logging"
+	"github.com/maxzhirnov/urlshort/internal/models"
+	"github.com/maxzhirnov/urlshort/internal/pb"
+	"github.com/maxzhirnov/urlshort/internal/ratelimit"
+	"github.com/maxzhirnov/urlshort/internal/services"
+)
+
+// Metadata keys, gRPC metadata keys are always lower case
+const (
+	mdAuthorization = "authorization"
+	mdAPIKey        = "x-api-key"
+	mdAuthToken     = "x-auth-token"
+	mdRequestID     = "x-request-id"
+	mdRetryAfter    = "retry-after"
+)
+
+type grpcUserIDKey struct{}
+
+// GRPCServer implements pb.ShortenerServer on top of the same service as REST handlers
+type GRPCServer struct {
+	pb.UnimplementedShortenerServer
+
+	service service
+	baseURL string
+	auth    *auth.Auth
+	logger  logger
+
+	perUser *ratelimit.Limiter
+	perIP   *ratelimit.Limiter
+}
+
+func NewGRPCServer(s service, baseURL string, auth *auth.Auth, logger logger) *GRPCServer {
+	return &GRPCServer{
+		service: s,
+		baseURL: baseURL,
+		auth:    auth,
+		logger:  logger,
+	}
+}
+
+// WithRateLimit limits methods creating links with the same limiters as REST endpoints
+func (s *GRPCServer) WithRateLimit(perUser, perIP *ratelimit.Limiter) *GRPCServer {
+	s.perUser = perUser
+	s.perIP = perIP
+	return s
+}
+
+// Register creates grpc.Server with auth, rate limit and logging interceptors and registers the service
+func (s *GRPCServer) Register(opts ...grpc.ServerOption) *grpc.Server {
+	opts = append(opts, grpc.ChainUnaryInterceptor(s.loggingInterceptor, s.rateLimitInterceptor, s.authInterceptor))
+	server := grpc.NewServer(opts...)
+	pb.RegisterShortenerServer(server, s)
+	return server
+}
+
+// anonymousAllowed are methods which issue token of a new user when called without identity,
+// like TokenIssuerMiddleware does for REST
+var anonymousAllowed = map[string]bool{
+	pb.Shortener_Shorten_FullMethodName:      true,
+	pb.Shortener_ShortenBatch_FullMethodName: true,
+}
+
+// rateLimitedMethods create links and are limited like createLimit routes of REST
+var rateLimitedMethods = map[string]bool{
+	pb.Shortener_Shorten_FullMethodName:      true,
+	pb.Shortener_ShortenBatch_FullMethodName: true,
+}
+
+// publicMethods don't need caller identity at all
+var publicMethods = map[string]bool{
+	pb.Shortener_Expand_FullMethodName: true,
+	pb.Shortener_Ping_FullMethodName:   true,
+}
+
+func (s *GRPCServer) authInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if publicMethods[info.FullMethod] {
+		return handler(ctx, req)
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	userID, err := s.resolveUserID(ctx, md)
+	if errors.Is(err, services.ErrInvalidAPIKey) {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	if errors.Is(err, services.ErrAPIKeyUnavailable) {
+		return nil, status.Error(codes.Unavailable, services.ErrAPIKeyUnavailable.Error())
+	}
+	if err != nil {
+		if !anonymousAllowed[info.FullMethod] {
+			return nil, status.Error(codes.Unauthenticated, "not authorized")
+		}
+		userID = s.auth.GenerateUUID()
+		token, err := s.auth.GenerateToken(userID)
+		if err != nil {
+			s.logger.Error("Failed to generate token", "error", err)
+			return nil, status.Error(codes.Internal, "couldn't generate token")
+		}
+		if err := grpc.SetHeader(ctx, metadata.Pairs(mdAuthToken, token)); err != nil {
+			return nil, err
+		}
+	}
+
+	return handler(context.WithValue(ctx, grpcUserIDKey{}, userID), req)
+}
+
+// rateLimitInterceptor works like RateLimitMiddleware: rejected calls get ResourceExhausted and
+// retry-after header. It runs before auth so calls with invalid credentials are limited too
+func (s *GRPCServer) rateLimitInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if !rateLimitedMethods[info.FullMethod] || s.perIP == nil || s.perUser == nil {
+		return handler(ctx, req)
+	}
+
+	ip := peerIP(ctx)
+	if ok, retryAfter := s.perIP.Allow(ip); !ok {
+		s.logger.Warn("rate limit exceeded", "ip", ip, "method", info.FullMethod)
+		return nil, tooManyRequests(ctx, retryAfter)
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	if key := s.callerKey(md); key != "" {
+		if ok, retryAfter := s.perUser.Allow(key); !ok {
+			s.logger.Warn("rate limit exceeded", "caller", key, "method", info.FullMethod)
+			return nil, tooManyRequests(ctx, retryAfter)
+		}
+	}
+
+	return handler(ctx, req)
+}
+
+// callerKey is the same as for REST requests so both APIs share buckets of a caller
+func (s *GRPCServer) callerKey(md metadata.MD) string {
+	// Ключ не проверяется по базе, чтобы вызовы с неверными ключами тоже ограничивались
+	if key := apiKey(md); key != "" {
+		return "key:" + auth.HashAPIKey(key)
+	}
+	if userID := s.auth.ValidateToken(bearerToken(md)); userID != "" {
+		return "user:" + userID
+	}
+	return ""
+}
+
+func tooManyRequests(ctx context.Context, retryAfter time.Duration) error {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	_ = grpc.SetHeader(ctx, metadata.Pairs(mdRetryAfter, strconv.Itoa(seconds)))
+	return status.Error(codes.ResourceExhausted, "too many requests")
+}
+
+// peerIP returns ip of the client without port
+func peerIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+	addr := p.Addr.String()
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+// resolveUserID identifies the caller by api key or jwt token from metadata
+func (s *GRPCServer) resolveUserID(ctx context.Context, md metadata.MD) (string, error) {
+	if key := apiKey(md); key != "" {
+		return s.service.ResolveAPIKey(ctx, key)
+	}
+
+	token := bearerToken(md)
+	if token == "" {
+		return "", errors.New("no credentials in metadata")
+	}
+	issued, err := s.auth.ParseToken(token)
+	if err != nil {
+		return "", err
+	}
+	return issued.UserID, nil
+}
+
+// apiKey returns api key from x-api-key or from authorization with bearer scheme
+func apiKey(md metadata.MD) string {
+	if key := firstValue(md, mdAPIKey); key != "" {
+		return key
+	}
+	if token := bearerToken(md); auth.IsAPIKey(token) {
+		return token
+	}
+	return ""
+}
+
+func bearerToken(md metadata.MD) string {
+	const prefix = "bearer "
+	header := firstValue(md, mdAuthorization)
+	if len(header) <= len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return ""
+	}
+	return header[len(prefix):]
+}
+
+// loggingInterceptor propagates request id like RequestIDMiddleware and writes one line per call
+func (s *GRPCServer) loggingInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	requestID := firstValue(md, mdRequestID)
+	if requestID == "" || len(requestID) > 128 {
+		requestID = uuid.New().String()
+	}
+	ctx = logging.WithRequestID(ctx, requestID)
+	_ = grpc.SetHeader(ctx, metadata.Pairs(mdRequestID, requestID))
+
+	resp, err := handler(ctx, req)
+
+	var clientIP string
+	if p, ok := peer.FromContext(ctx); ok {
+		clientIP = p.Addr.String()
+	}
+	s.logger.Info(logging.MsgGRPCCallServed,
+		"method", info.FullMethod,
+		"code", status.Code(err).String(),
+		"latency_ms", float64(time.Since(start).Microseconds())/1000,
+		"client_ip", clientIP,
+		"request_id", requestID,
+	)
+	return resp, err
+}
+
+func firstValue(md metadata.MD, key string) string {
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func grpcUserID(ctx context.Context) string {
+	userID, _ := ctx.Value(grpcUserIDKey{}).(string)
+	return userID
+}
+
+func (s *GRPCServer) Shorten(ctx context.Context, req *pb.ShortenRequest) (*pb.ShortenResponse, error) {
+	if len(req.GetUrl()) < 3 {
+		return nil, status.Error(codes.InvalidArgument, "url should be valid url")
+	}
+
+	shortURL, err := s.service.Create(ctx, req.GetUrl(), grpcUserID(ctx), redirectOptions(req.GetRedirect()))
+	if errors.Is(err, services.ErrInvalidRedirect) {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if errors.Is(err, services.ErrEntityAlreadyExist) {
+		return nil, status.Errorf(codes.AlreadyExists, "url already shortened: %s/%s", s.baseURL, shortURL.ID)
+	}
+	if err != nil {
+		s.logger.Error(err.Error())
+		return nil, status.Error(codes.Internal, "something went wrong")
+	}
+	return &pb.ShortenResponse{Result: s.baseURL + "/" + shortURL.ID}, nil
+}
+
+func redirectOptions(o *pb.RedirectOptions) models.RedirectOptions {
+	return models.RedirectOptions{
+		RedirectCode:   int(o.GetRedirectCode()),
+		CacheControl:   o.GetCacheControl(),
+		ReferrerPolicy: o.GetReferrerPolicy(),
+		QueryMode:      models.PassthroughMode(o.GetQueryMode()),
+		PathMode:       models.PassthroughMode(o.GetPathMode()),
+	}
+}
+
+func (s *GRPCServer) ShortenBatch(ctx context.Context, req *pb.ShortenBatchRequest) (*pb.ShortenBatchResponse, error) {
+	urls := make([]services.BatchURL, len(req.GetItems()))
+	for i, item := range req.GetItems() {
+		urls[i] = services.BatchURL{OriginalURL: item.GetOriginalUrl(), Redirect: redirectOptions(item.GetRedirect())}
+	}
+
+	ids, err := s.service.CreateBatch(ctx, urls, grpcUserID(ctx))
+	if errors.Is(err, services.ErrInvalidRedirect) {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err != nil {
+		s.logger.Error("error creating batch", "error", err)
+		return nil, status.Error(codes.Internal, "something went wrong")
+	}
+
+	res := &pb.ShortenBatchResponse{Items: make([]*pb.BatchResult, len(ids))}
+	for i, id := range ids {
+		res.Items[i] = &pb.BatchResult{
+			CorrelationId: req.Items[i].GetCorrelationId(),
+			ShortUrl:      s.baseURL + "/" + id,
+		}
+	}
+	return res, nil
+}
+
+func (s *GRPCServer) Expand(ctx context.Context, req *pb.ExpandRequest) (*pb.ExpandResponse, error) {
+	url, err := s.service.Get(ctx, req.GetId())
+	if err != nil {
+		return nil, status.Error(codes.NotFound, "id not found")
+	}
+	if url.DeletedFlag {
+		return nil, status.Error(codes.FailedPrecondition, "requested url was deleted")
+	}
+	if url.Disabled {
+		return nil, status.Error(codes.PermissionDenied, "requested url was disabled")
+	}
+	return &pb.ExpandResponse{OriginalUrl: services.EnsureURLScheme(url.OriginalURL)}, nil
+}
+
+func (s *GRPCServer) ListUserURLs(ctx context.Context, _ *pb.ListUserURLsRequest) (*pb.ListUserURLsResponse, error) {
+	userURLs, err := s.service.GetAllUsersURLs(ctx, grpcUserID(ctx))
+	if err != nil {
+		s.logger.Error(err.Error())
+		return nil, status.Error(codes.Internal, "something went wrong")
+	}
+
+	res := &pb.ListUserURLsResponse{Urls: make([]*pb.UserURL, len(userURLs))}
+	for i, u := range userURLs {
+		res.Urls[i] = &pb.UserURL{
+			ShortUrl:    s.baseURL + "/" + u.ID,
+			OriginalUrl: u.OriginalURL,
+		}
+	}
+	return res, nil
+}
+
+func (s *GRPCServer) DeleteURLs(ctx context.Context, req *pb.DeleteURLsRequest) (*pb.DeleteURLsResponse, error) {
+	if len(req.GetIds()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "ids shouldn't be empty")
+	}
+	s.service.Delete(req.GetIds(), grpcUserID(ctx))
+	return &pb.DeleteURLsResponse{}, nil
+}
+
+func (s *GRPCServer) Ping(ctx context.Context, _ *pb.PingRequest) (*pb.PingResponse, error) {
+	if err := s.service.Ping(); err != nil {
+		return nil, status.Error(codes.Unavailable, "storage is unavailable")
+	}
+	return &pb.PingResponse{}, nil
+}
@@ -0,0 +1,243 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/maxzhirnov/urlshort/internal/auth"
+	"github.com/maxzhirnov/urlshort/internal/logging"
+	"github.com/maxzhirnov/urlshort/internal/models"
+	"github.com/maxzhirnov/urlshort/internal/pb"
+	"github.com/maxzhirnov/urlshort/internal/ratelimit"
+	"github.com/maxzhirnov/urlshort/internal/services"
+)
+
+func newTestGRPCClient(t *testing.T, m *mockURLShortenerService, a *auth.Auth) pb.ShortenerClient {
+	t.Helper()
+	return serveTestGRPC(t, NewGRPCServer(m, "http://localhost:8080", a, logging.NewLogrusLogger(logrus.ErrorLevel)))
+}
+
+func serveTestGRPC(t *testing.T, s *GRPCServer) pb.ShortenerClient {
+	t.Helper()
+	listener := bufconn.Listen(1 << 20)
+	server := s.Register()
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.DialContext(context.Background(), "bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return pb.NewShortenerClient(conn)
+}
+
+func TestGRPCServer_Shorten(t *testing.T) {
+	a := auth.NewAuth()
+	token, err := a.GenerateToken("known-user")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name      string
+		url       string
+		md        metadata.MD
+		wantCode  codes.Code
+		wantUUID  string
+		wantToken bool
+	}{
+		{
+			name:      "anonymous caller gets new token",
+			url:       "ya.ru",
+			wantCode:  codes.OK,
+			wantToken: true,
+		},
+		{
+			name:     "caller identified by token",
+			url:      "ya.ru",
+			md:       metadata.Pairs("authorization", "Bearer "+token),
+			wantCode: codes.OK,
+			wantUUID: "known-user",
+		},
+		{
+			name:     "caller identified by api key",
+			url:      "ya.ru",
+			md:       metadata.Pairs("x-api-key", "us_valid"),
+			wantCode: codes.OK,
+			wantUUID: "key-owner",
+		},
+		{
+			name:     "invalid api key",
+			url:      "ya.ru",
+			md:       metadata.Pairs("x-api-key", "us_invalid"),
+			wantCode: codes.Unauthenticated,
+		},
+		{
+			name:     "already shortened url",
+			url:      "google.com",
+			wantCode: codes.AlreadyExists,
+		},
+		{
+			name:     "invalid url",
+			url:      "y",
+			wantCode: codes.InvalidArgument,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &mockURLShortenerService{
+				CreateFunc: func(originalURL string) (models.ShortURL, error) {
+					if originalURL == "google.com" {
+						return models.ShortURL{ID: "existing"}, services.ErrEntityAlreadyExist
+					}
+					return models.ShortURL{ID: "abcdefgh", OriginalURL: originalURL}, nil
+				},
+				ResolveAPIKeyFunc: func(key string) (string, error) {
+					if key == "us_valid" {
+						return "key-owner", nil
+					}
+					return "", services.ErrInvalidAPIKey
+				},
+			}
+			client := newTestGRPCClient(t, m, a)
+
+			ctx := metadata.NewOutgoingContext(context.Background(), tt.md)
+			var header metadata.MD
+			res, err := client.Shorten(ctx, &pb.ShortenRequest{Url: tt.url}, grpc.Header(&header))
+			require.Equal(t, tt.wantCode, status.Code(err), err)
+			if tt.wantCode != codes.OK {
+				return
+			}
+
+			assert.Equal(t, "http://localhost:8080/abcdefgh", res.GetResult())
+			assert.NotEmpty(t, header.Get("x-request-id"))
+			if tt.wantToken {
+				tokens := header.Get("x-auth-token")
+				require.Len(t, tokens, 1)
+				assert.Equal(t, m.createdForUUID, a.ValidateToken(tokens[0]))
+				return
+			}
+			assert.Equal(t, tt.wantUUID, m.createdForUUID)
+			assert.Empty(t, header.Get("x-auth-token"))
+		})
+	}
+}
+
+func TestGRPCServer_RateLimit(t *testing.T) {
+	a := auth.NewAuth()
+	token, err := a.GenerateToken("known-user")
+	require.NoError(t, err)
+	m := &mockURLShortenerService{
+		CreateFunc: func(originalURL string) (models.ShortURL, error) {
+			return models.ShortURL{ID: "abcdefgh", OriginalURL: originalURL}, nil
+		},
+		ResolveAPIKeyFunc: func(string) (string, error) {
+			return "", services.ErrInvalidAPIKey
+		},
+	}
+
+	t.Run("per user", func(t *testing.T) {
+		perUser := ratelimit.NewLimiter(ratelimit.Limit{Burst: 2, Per: time.Hour})
+		perIP := ratelimit.NewLimiter(ratelimit.Limit{})
+		client := serveTestGRPC(t, NewGRPCServer(m, "http://localhost:8080", a, logging.NewLogrusLogger(logrus.ErrorLevel)).
+			WithRateLimit(perUser, perIP))
+		ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+token)
+
+		for i := 0; i < 2; i++ {
+			_, err := client.Shorten(ctx, &pb.ShortenRequest{Url: "ya.ru"})
+			require.NoError(t, err)
+		}
+		var header metadata.MD
+		_, err := client.ShortenBatch(ctx, &pb.ShortenBatchRequest{}, grpc.Header(&header))
+		assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+		assert.NotEmpty(t, header.Get("retry-after"))
+
+		// Анонимные вызовы ограничены только по ip
+		_, err = client.Shorten(context.Background(), &pb.ShortenRequest{Url: "ya.ru"})
+		assert.NoError(t, err)
+	})
+
+	t.Run("per ip with invalid credentials", func(t *testing.T) {
+		perUser := ratelimit.NewLimiter(ratelimit.Limit{})
+		perIP := ratelimit.NewLimiter(ratelimit.Limit{Burst: 1, Per: time.Hour})
+		client := serveTestGRPC(t, NewGRPCServer(m, "http://localhost:8080", a, logging.NewLogrusLogger(logrus.ErrorLevel)).
+			WithRateLimit(perUser, perIP))
+
+		_, err := client.Shorten(metadata.AppendToOutgoingContext(context.Background(), "x-api-key", "us_invalid"),
+			&pb.ShortenRequest{Url: "ya.ru"})
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+		_, err = client.Shorten(context.Background(), &pb.ShortenRequest{Url: "ya.ru"})
+		assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+		// Методы, которые не создают ссылки, не ограничиваются
+		_, err = client.Ping(context.Background(), &pb.PingRequest{})
+		assert.NotEqual(t, codes.ResourceExhausted, status.Code(err))
+	})
+}
+
+func TestGRPCServer_Expand(t *testing.T) {
+	m := &mockURLShortenerService{
+		GetFunc: func(id string) (models.ShortURL, error) {
+			switch id {
+			case "deleted":
+				return models.ShortURL{ID: id, OriginalURL: "ya.ru", DeletedFlag: true}, nil
+			case "disabled":
+				return models.ShortURL{ID: id, OriginalURL: "ya.ru", Disabled: true}, nil
+			case "ok":
+				return models.ShortURL{ID: id, OriginalURL: "ya.ru"}, nil
+			}
+			return models.ShortURL{}, errors.New("not found")
+		},
+	}
+	client := newTestGRPCClient(t, m, auth.NewAuth())
+
+	tests := []struct {
+		id       string
+		wantCode codes.Code
+	}{
+		{id: "ok", wantCode: codes.OK},
+		{id: "missing", wantCode: codes.NotFound},
+		{id: "deleted", wantCode: codes.FailedPrecondition},
+		{id: "disabled", wantCode: codes.PermissionDenied},
+	}
+	for _, tt := range tests {
+		t.Run(tt.id, func(t *testing.T) {
+			res, err := client.Expand(context.Background(), &pb.ExpandRequest{Id: tt.id})
+			require.Equal(t, tt.wantCode, status.Code(err))
+			if tt.wantCode == codes.OK {
+				assert.Equal(t, "http://ya.ru", res.GetOriginalUrl())
+			}
+		})
+	}
+}
+
+func TestGRPCServer_RequiresIdentity(t *testing.T) {
+	client := newTestGRPCClient(t, &mockURLShortenerService{}, auth.NewAuth())
+
+	_, err := client.ListUserURLs(context.Background(), &pb.ListUserURLsRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	_, err = client.DeleteURLs(context.Background(), &pb.DeleteURLsRequest{Ids: []string{"abc"}})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	_, err = client.Ping(context.Background(), &pb.PingRequest{})
+	assert.NoError(t, err)
+}
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -10,6 +11,8 @@ import (
 	"github.com/gin-gonic/gin"
 
 	"github.com/maxzhirnov/urlshort/internal/auth"
+	"github.com/maxzhirnov/urlshort/internal/health"
+	"github.com/maxzhirnov/urlshort/internal/metrics"
 	"github.com/maxzhirnov/urlshort/internal/models"
 	"github.com/maxzhirnov/urlshort/internal/services"
 )
@@ -22,31 +25,103 @@ type logger interface {
 	Debug(string, ...interface{})
 }
 
+type redirectMetrics interface {
+	Redirect(result string)
+}
+
+type noopMetrics struct{}
+
+func (noopMetrics) Redirect(string) {}
+
 type service interface {
-	Create(url, uuid string) (models.ShortURL, error)
-	CreateBatch(urls []string, uuid string) (ids []string, err error)
-	Get(id string) (url models.ShortURL, err error)
-	GetAllUsersURLs(uuid string) ([]models.ShortURL, error)
+	Create(ctx context.Context, url, uuid string, redirect models.RedirectOptions) (models.ShortURL, error)
+	CreateBatch(ctx context.Context, urls []services.BatchURL, uuid string) (ids []string, err error)
+	Get(ctx context.Context, id string) (url models.ShortURL, err error)
+	GetAllUsersURLs(ctx context.Context, uuid string) ([]models.ShortURL, error)
 	Ping() error
 	Delete(ids []string, id string)
+	CreateAPIKey(ctx context.Context, uuid, name string) (models.APIKey, string, error)
+	GetAPIKeys(ctx context.Context, uuid string) ([]models.APIKey, error)
+	RevokeAPIKey(ctx context.Context, id, uuid string) error
+	ResolveAPIKey(ctx context.Context, key string) (string, error)
+	SignUp(ctx context.Context, login, password, mergeFrom string) (models.User, int, error)
+	Login(ctx context.Context, login, password, mergeFrom string) (models.User, int, error)
+	LoginExternal(ctx context.Context, externalID, mergeFrom string) (models.User, int, error)
+	SearchURLs(ctx context.Context, filter models.URLFilter) ([]models.ShortURL, error)
+	AdminDeleteURLs(ctx context.Context, ids []string) error
+	SetURLsDisabled(ctx context.Context, ids []string, disabled bool) error
+	ListUsers(ctx context.Context, query string, limit, offset int) ([]models.User, error)
+	InspectUser(ctx context.Context, id string) (services.UserInfo, error)
+	Stats(ctx context.Context) (models.Stats, error)
+	RecordSession(ctx context.Context, session models.Session) error
+	GetActiveSessions(ctx context.Context, uuid string) ([]models.Session, error)
+	RevokeSession(ctx context.Context, id, uuid string) (models.Session, error)
+	RevokeToken(ctx context.Context, session models.Session) error
+	CreateOrganization(ctx context.Context, name, ownerUUID string) (models.Organization, error)
+	GetOrganizations(ctx context.Context, uuid string) ([]services.UserOrg, error)
+	GetOrgMembers(ctx context.Context, orgID, uuid string) ([]models.Membership, error)
+	SetMemberRole(ctx context.Context, orgID, actorUUID, memberUUID string, role models.OrgRole) error
+	RemoveMember(ctx context.Context, orgID, actorUUID, memberUUID string) error
+	GetOrgURLs(ctx context.Context, orgID, uuid string) ([]models.ShortURL, error)
+	CreateOrgURL(ctx context.Context, orgID, uuid, originalURL string, redirect models.RedirectOptions) (models.ShortURL, error)
+	TransferURLs(ctx context.Context, orgID, uuid string, ids []string) (int, error)
+	DeleteOrgURLs(ctx context.Context, orgID, uuid string, ids []string) error
+	GetRedirectRules(ctx context.Context, id, uuid string) ([]models.RedirectRule, error)
+	SetRedirectRules(ctx context.Context, id, uuid string, rules []models.RedirectRule) error
 }
 
 type Handlers struct {
 	service service
 	baseURL string
 	auth    *auth.Auth
+	oidc    oidcProvider
 	logger  logger
+	metrics redirectMetrics
+	health  readinessChecker
+	// storageBackend is reported by ping, e.g. memory, file or postgres
+	storageBackend string
+	// redirect fills redirect options which are not set on the link
+	redirect models.RedirectOptions
+
+	adminIDs   map[string]struct{}
+	adminToken string
 }
 
 func NewHandlers(s service, baseURL string, auth *auth.Auth, logger logger) *Handlers {
 	return &Handlers{
-		service: s,
-		baseURL: baseURL,
-		auth:    auth,
-		logger:  logger,
+		service:        s,
+		baseURL:        baseURL,
+		auth:           auth,
+		logger:         logger,
+		metrics:        noopMetrics{},
+		health:         health.NewChecker(),
+		storageBackend: "memory",
+		redirect: models.RedirectOptions{
+			RedirectCode: http.StatusTemporaryRedirect,
+			QueryMode:    models.PassthroughIgnore,
+			PathMode:     models.PassthroughIgnore,
+		},
 	}
 }
 
+// WithStorageBackend sets name of the storage reported by ping
+func (h *Handlers) WithStorageBackend(backend string) *Handlers {
+	h.storageBackend = backend
+	return h
+}
+
+// WithRedirectDefaults sets redirect code and headers used for links which don't set their own
+func (h *Handlers) WithRedirectDefaults(defaults models.RedirectOptions) *Handlers {
+	h.redirect = defaults.WithDefaults(h.redirect)
+	return h
+}
+
+// WithMetrics enables counting of redirects by result
+func (h *Handlers) WithMetrics(m redirectMetrics) *Handlers {
+	h.metrics = m
+	return h
+}
+
 func (h *Handlers) HandleCreate(c *gin.Context) {
 	defer c.Request.Body.Close()
 	originalURLData, err := io.ReadAll(c.Request.Body)
@@ -62,13 +137,21 @@ func (h *Handlers) HandleCreate(c *gin.Context) {
 
 	originalURL := string(originalURLData)
 
-	userID, err := h.getUserIDFromJWTToken(c)
+	userID, err := h.resolveUserID(c)
+	if errors.Is(err, services.ErrAPIKeyUnavailable) {
+		h.respondAuthError(c, err)
+		return
+	}
+	if errors.Is(err, services.ErrInvalidAPIKey) {
+		c.String(http.StatusUnauthorized, err.Error())
+		return
+	}
 	if err != nil {
 		h.logger.Warn(err.Error())
 	}
 
 	statusCode := http.StatusCreated
-	shortenURLObject, err := h.service.Create(originalURL, userID)
+	shortenURLObject, err := h.service.Create(c.Request.Context(), originalURL, userID, models.RedirectOptions{})
 
 	if errors.Is(err, services.ErrEntityAlreadyExist) {
 		statusCode = http.StatusConflict
@@ -90,20 +173,44 @@ func (h *Handlers) HandleCreate(c *gin.Context) {
 
 func (h *Handlers) HandleRedirect(c *gin.Context) {
 	id := c.Param("ID")
-	url, err := h.service.Get(id)
+	url, err := h.service.Get(c.Request.Context(), id)
 	if err != nil {
+		h.metrics.Redirect(metrics.RedirectNotFound)
 		c.String(http.StatusNotFound, "id not found")
 		return
 	}
 	if url.DeletedFlag {
+		h.metrics.Redirect(metrics.RedirectGone)
 		c.String(http.StatusGone, "requested url was deleted")
+		return
+	}
+	if url.Disabled {
+		h.metrics.Redirect(metrics.RedirectDisabled)
+		c.String(http.StatusForbidden, "requested url was disabled")
+		return
+	}
+	h.metrics.Redirect(metrics.RedirectOK)
+	redirect := url.RedirectOptions.WithDefaults(h.redirect)
+	if redirect.CacheControl != "" {
+		c.Header("Cache-Control", redirect.CacheControl)
 	}
-	c.Redirect(http.StatusTemporaryRedirect, services.EnsureURLScheme(url.OriginalURL))
+	if redirect.ReferrerPolicy != "" {
+		c.Header("Referrer-Policy", redirect.ReferrerPolicy)
+	}
+	destination := url.OriginalURL
+	if len(url.Rules) > 0 {
+		// Ответ зависит от клиента, кэши должны различать его по этим заголовкам
+		c.Header("Vary", "User-Agent, Accept-Language")
+		destination = services.ResolveDestination(url, services.ParseClient(c.Request.UserAgent(), c.GetHeader("Accept-Language")))
+	}
+	target := services.RedirectTarget(destination, redirect, c.Param("rest"), c.Request.URL.RawQuery)
+	c.Redirect(redirect.RedirectCode, target)
 }
 
 func (h *Handlers) HandleShorten(c *gin.Context) {
 	var reqData struct {
 		URL string `json:"url"`
+		models.RedirectOptions
 	}
 
 	if err := json.NewDecoder(c.Request.Body).Decode(&reqData); err != nil {
@@ -117,12 +224,24 @@ func (h *Handlers) HandleShorten(c *gin.Context) {
 		return
 	}
 
-	userID, err := h.getUserIDFromJWTToken(c)
+	userID, err := h.resolveUserID(c)
+	if errors.Is(err, services.ErrAPIKeyUnavailable) {
+		h.respondAuthError(c, err)
+		return
+	}
+	if errors.Is(err, services.ErrInvalidAPIKey) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		h.logger.Warn(err.Error())
 	}
 
-	shortenURLObject, err := h.service.Create(reqData.URL, userID)
+	shortenURLObject, err := h.service.Create(c.Request.Context(), reqData.URL, userID, reqData.RedirectOptions)
+	if errors.Is(err, services.ErrInvalidRedirect) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	if errors.Is(err, services.ErrEntityAlreadyExist) {
 		h.logger.Error(err.Error())
 		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
@@ -146,28 +265,41 @@ func (h *Handlers) HandleShortenBatch(c *gin.Context) {
 	var request = make([]struct {
 		CorrelationID string `json:"correlation_id"`
 		OriginalURL   string `json:"original_url"`
+		models.RedirectOptions
 	}, 0)
 
 	if err := json.NewDecoder(c.Request.Body).Decode(&request); err != nil {
-		h.logger.Error("error decoding json", err)
+		h.logger.Error("error decoding json", "error", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "something went wrong"})
 		return
 	}
 	defer c.Request.Body.Close()
 
-	userID, err := h.getUserIDFromJWTToken(c)
+	userID, err := h.resolveUserID(c)
+	if errors.Is(err, services.ErrAPIKeyUnavailable) {
+		h.respondAuthError(c, err)
+		return
+	}
+	if errors.Is(err, services.ErrInvalidAPIKey) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		h.logger.Warn(err.Error())
 	}
 
-	urlsToShort := make([]string, 0)
+	urlsToShort := make([]services.BatchURL, 0, len(request))
 	for _, u := range request {
-		urlsToShort = append(urlsToShort, u.OriginalURL)
+		urlsToShort = append(urlsToShort, services.BatchURL{OriginalURL: u.OriginalURL, Redirect: u.RedirectOptions})
 	}
 
-	ids, err := h.service.CreateBatch(urlsToShort, userID)
+	ids, err := h.service.CreateBatch(c.Request.Context(), urlsToShort, userID)
+	if errors.Is(err, services.ErrInvalidRedirect) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
-		h.logger.Error("error creating batch", err)
+		h.logger.Error("error creating batch", "error", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "something went wrong"})
 		return
 	}
@@ -185,13 +317,15 @@ func (h *Handlers) HandleShortenBatch(c *gin.Context) {
 	c.JSON(http.StatusCreated, response)
 }
 
+// HandlePing checks the storage and names it in the response, it answers 500 on failure
+// as autotests expect, readiness probe is /readyz
 func (h *Handlers) HandlePing(c *gin.Context) {
-	err := h.service.Ping()
-	if err != nil {
-		c.String(http.StatusInternalServerError, "something went wrong")
+	if err := h.service.Ping(); err != nil {
+		h.logger.Error("storage ping failed", "storage", h.storageBackend, "error", err)
+		c.String(http.StatusInternalServerError, "%s storage is unavailable", h.storageBackend)
 		return
 	}
-	c.String(http.StatusOK, "connected to database")
+	c.String(http.StatusOK, "%s storage is available", h.storageBackend)
 }
 
 type ShowAllUsersURLsDTO struct {
@@ -207,13 +341,21 @@ func (h *Handlers) newShowAllUsersURLsDTO(su models.ShortURL) ShowAllUsersURLsDT
 }
 
 func (h *Handlers) HandleShowAllUsersURLs(c *gin.Context) {
-	userID, err := h.getUserIDFromJWTToken(c)
+	userID, err := h.resolveUserID(c)
+	if errors.Is(err, services.ErrAPIKeyUnavailable) {
+		h.respondAuthError(c, err)
+		return
+	}
+	if errors.Is(err, services.ErrInvalidAPIKey) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusNoContent, "empty")
 		return
 	}
 
-	userURLs, err := h.service.GetAllUsersURLs(userID)
+	userURLs, err := h.service.GetAllUsersURLs(c.Request.Context(), userID)
 	if len(userURLs) == 0 {
 		c.JSON(http.StatusNoContent, "empty")
 		return
@@ -232,17 +374,45 @@ func (h *Handlers) HandleShowAllUsersURLs(c *gin.Context) {
 	c.JSON(http.StatusOK, res)
 }
 
+// resolveUserID identifies the caller by api key from X-API-Key or Authorization header
+// and falls back to jwt_token cookie
+func (h *Handlers) resolveUserID(c *gin.Context) (string, error) {
+	var (
+		userID string
+		err    error
+	)
+	if key, ok := auth.APIKeyFromRequest(c.Request); ok {
+		userID, err = h.service.ResolveAPIKey(c.Request.Context(), key)
+	} else {
+		userID, err = h.getUserIDFromJWTToken(c)
+	}
+	if err == nil {
+		// Используется в access логе
+		c.Set("user_id", userID)
+	}
+	return userID, err
+}
+
+// respondAuthError answers 503 when api key couldn't be checked and 401 otherwise,
+// failed storage shouldn't make clients drop their valid keys
+func (h *Handlers) respondAuthError(c *gin.Context, err error) {
+	if errors.Is(err, services.ErrAPIKeyUnavailable) {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": services.ErrAPIKeyUnavailable.Error()})
+		return
+	}
+	c.JSON(http.StatusUnauthorized, gin.H{"error": "not authorized"})
+}
+
 func (h *Handlers) getUserIDFromJWTToken(c *gin.Context) (string, error) {
 	var jwtToken string
-	var err error
 	// Пытаемся получить jwtToken из контекста
 	if tempToken, exists := c.Get("jwt_token"); exists {
 		jwtToken = tempToken.(string)
 	} else {
-		// Если токена нет в контексте, пытаемся получить его из куки
-		jwtToken, err = c.Cookie("jwt_token")
-		if err != nil {
-			return "", err
+		// Если токена нет в контексте, пытаемся получить его из заголовка Authorization или куки
+		var ok bool
+		if jwtToken, ok = h.auth.TokenFromRequest(c.Request); !ok {
+			return "", http.ErrNoCookie
 		}
 	}
 
@@ -255,16 +425,20 @@ func (h *Handlers) getUserIDFromJWTToken(c *gin.Context) (string, error) {
 }
 
 func (h *Handlers) HandleDeleteURL(c *gin.Context) {
-	userID, err := h.getUserIDFromJWTToken(c)
+	userID, err := h.resolveUserID(c)
 	if err != nil {
-		h.logger.Error("error parsing user_id", err.Error())
+		h.logger.Error("error parsing user_id", "error", err)
+		if errors.Is(err, services.ErrAPIKeyUnavailable) {
+			h.respondAuthError(c, err)
+			return
+		}
 		c.JSON(http.StatusUnauthorized, "not authorized")
 		return
 	}
 
 	var ids []string
 	if err := json.NewDecoder(c.Request.Body).Decode(&ids); err != nil {
-		h.logger.Error("error unmarshalling body", err.Error())
+		h.logger.Error("error unmarshalling body", "error", err)
 		c.JSON(http.StatusBadRequest, gin.H{"error": "you should provide correct data"})
 		return
 	}
@@ -2,7 +2,9 @@ package handlers
 
 import (
 	"bytes"
+	"context"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
@@ -15,38 +17,199 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"github.com/maxzhirnov/urlshort/internal/auth"
+	"github.com/maxzhirnov/urlshort/internal/health"
 	"github.com/maxzhirnov/urlshort/internal/logging"
 	"github.com/maxzhirnov/urlshort/internal/models"
+	"github.com/maxzhirnov/urlshort/internal/services"
 )
 
 type mockURLShortenerService struct {
-	CreateFunc func(originalURL string) (url models.ShortURL, err error)
-	GetFunc    func(id string) (url models.ShortURL, err error)
+	CreateFunc        func(originalURL string) (url models.ShortURL, err error)
+	GetFunc           func(id string) (url models.ShortURL, err error)
+	ResolveAPIKeyFunc func(key string) (string, error)
+	pingErr           error
+	createdForUUID    string
+	createdRedirect   models.RedirectOptions
+	batch             []services.BatchURL
+	sessions          []models.Session
+	revoked           []models.Session
+	rules             []models.RedirectRule
 }
 
-func (m *mockURLShortenerService) Create(url, uuid string) (models.ShortURL, error) {
+func (m *mockURLShortenerService) Create(ctx context.Context, url, uuid string, redirect models.RedirectOptions) (models.ShortURL, error) {
+	m.createdForUUID = uuid
+	m.createdRedirect = redirect
+	if err := redirect.Validate(); err != nil {
+		return models.ShortURL{}, fmt.Errorf("%w: %v", services.ErrInvalidRedirect, err)
+	}
 	return m.CreateFunc(url)
 }
 
-func (m *mockURLShortenerService) CreateBatch(urls []string, uuid string) (ids []string, err error) {
-	return nil, err
+func (m *mockURLShortenerService) CreateBatch(ctx context.Context, urls []services.BatchURL, uuid string) (ids []string, err error) {
+	m.batch = urls
+	for i := range urls {
+		ids = append(ids, fmt.Sprintf("id%d", i+1))
+	}
+	return ids, err
 }
 
 func (m *mockURLShortenerService) Delete(ids []string, id string) {
 }
 
-func (m *mockURLShortenerService) GetAllUsersURLs(uuid string) ([]models.ShortURL, error) {
+func (m *mockURLShortenerService) GetAllUsersURLs(ctx context.Context, uuid string) ([]models.ShortURL, error) {
 	return make([]models.ShortURL, 0), nil
 }
 
-func (m *mockURLShortenerService) Get(id string) (models.ShortURL, error) {
+func (m *mockURLShortenerService) Get(ctx context.Context, id string) (models.ShortURL, error) {
 	return m.GetFunc(id)
 }
 
 func (m *mockURLShortenerService) Ping() error {
+	return m.pingErr
+}
+
+func (m *mockURLShortenerService) CreateAPIKey(ctx context.Context, uuid, name string) (models.APIKey, string, error) {
+	return models.APIKey{UUID: uuid, Name: name}, "us_key", nil
+}
+
+func (m *mockURLShortenerService) GetAPIKeys(ctx context.Context, uuid string) ([]models.APIKey, error) {
+	return make([]models.APIKey, 0), nil
+}
+
+func (m *mockURLShortenerService) RevokeAPIKey(ctx context.Context, id, uuid string) error {
+	return nil
+}
+
+func (m *mockURLShortenerService) ResolveAPIKey(ctx context.Context, key string) (string, error) {
+	return m.ResolveAPIKeyFunc(key)
+}
+
+func (m *mockURLShortenerService) SignUp(ctx context.Context, login, password, mergeFrom string) (models.User, int, error) {
+	return models.User{ID: "account", Login: login}, 0, nil
+}
+
+func (m *mockURLShortenerService) LoginExternal(ctx context.Context, externalID, mergeFrom string) (models.User, int, error) {
+	return models.User{ID: "external-account", Login: externalID, ExternalID: externalID}, 0, nil
+}
+
+func (m *mockURLShortenerService) SearchURLs(ctx context.Context, filter models.URLFilter) ([]models.ShortURL, error) {
+	return []models.ShortURL{{ID: "abc", OriginalURL: "example.com", UUID: "owner"}}, nil
+}
+
+func (m *mockURLShortenerService) AdminDeleteURLs(ctx context.Context, ids []string) error {
+	return nil
+}
+
+func (m *mockURLShortenerService) SetURLsDisabled(ctx context.Context, ids []string, disabled bool) error {
+	return nil
+}
+
+func (m *mockURLShortenerService) ListUsers(ctx context.Context, query string, limit, offset int) ([]models.User, error) {
+	return make([]models.User, 0), nil
+}
+
+func (m *mockURLShortenerService) InspectUser(ctx context.Context, id string) (services.UserInfo, error) {
+	return services.UserInfo{}, services.ErrNotFound
+}
+
+func (m *mockURLShortenerService) Stats(ctx context.Context) (models.Stats, error) {
+	return models.Stats{}, nil
+}
+
+func (m *mockURLShortenerService) RecordSession(ctx context.Context, session models.Session) error {
+	m.sessions = append(m.sessions, session)
+	return nil
+}
+
+func (m *mockURLShortenerService) GetActiveSessions(ctx context.Context, uuid string) ([]models.Session, error) {
+	return m.sessions, nil
+}
+
+func (m *mockURLShortenerService) RevokeSession(ctx context.Context, id, uuid string) (models.Session, error) {
+	return models.Session{}, services.ErrNotFound
+}
+
+func (m *mockURLShortenerService) RevokeToken(ctx context.Context, session models.Session) error {
+	m.revoked = append(m.revoked, session)
+	return nil
+}
+
+func (m *mockURLShortenerService) CreateOrganization(ctx context.Context, name, ownerUUID string) (models.Organization, error) {
+	return models.Organization{ID: "org", Name: name}, nil
+}
+
+func (m *mockURLShortenerService) GetOrganizations(ctx context.Context, uuid string) ([]services.UserOrg, error) {
+	return make([]services.UserOrg, 0), nil
+}
+
+func (m *mockURLShortenerService) GetOrgMembers(ctx context.Context, orgID, uuid string) ([]models.Membership, error) {
+	return make([]models.Membership, 0), nil
+}
+
+func (m *mockURLShortenerService) SetMemberRole(ctx context.Context, orgID, actorUUID, memberUUID string, role models.OrgRole) error {
+	if !role.Valid() {
+		return services.ErrInvalidRole
+	}
+	return nil
+}
+
+func (m *mockURLShortenerService) RemoveMember(ctx context.Context, orgID, actorUUID, memberUUID string) error {
 	return nil
 }
 
+// GetOrgURLs знает только организацию "org", в которой пользователь "viewer" может только читать
+func (m *mockURLShortenerService) GetOrgURLs(ctx context.Context, orgID, uuid string) ([]models.ShortURL, error) {
+	if orgID != "org" {
+		return nil, services.ErrNotFound
+	}
+	return []models.ShortURL{{ID: "abc", OriginalURL: "example.com", UUID: "creator", OrgID: orgID}}, nil
+}
+
+func (m *mockURLShortenerService) CreateOrgURL(ctx context.Context, orgID, uuid, originalURL string, redirect models.RedirectOptions) (models.ShortURL, error) {
+	if uuid == "viewer" {
+		return models.ShortURL{}, services.ErrForbidden
+	}
+	m.createdRedirect = redirect
+	return models.ShortURL{ID: "abc", OriginalURL: originalURL, UUID: uuid, OrgID: orgID}, nil
+}
+
+func (m *mockURLShortenerService) TransferURLs(ctx context.Context, orgID, uuid string, ids []string) (int, error) {
+	return len(ids), nil
+}
+
+func (m *mockURLShortenerService) DeleteOrgURLs(ctx context.Context, orgID, uuid string, ids []string) error {
+	return nil
+}
+
+// Правила есть только у ссылки "abc" пользователя "owner", "viewer" может их только читать
+func (m *mockURLShortenerService) GetRedirectRules(ctx context.Context, id, uuid string) ([]models.RedirectRule, error) {
+	if id != "abc" || (uuid != "owner" && uuid != "viewer") {
+		return nil, services.ErrNotFound
+	}
+	return []models.RedirectRule{{OS: models.OSiOS, URL: "https://apps.apple.com/app/id1"}}, nil
+}
+
+func (m *mockURLShortenerService) SetRedirectRules(ctx context.Context, id, uuid string, rules []models.RedirectRule) error {
+	if err := models.ValidateRedirectRules(rules); err != nil {
+		return fmt.Errorf("%w: %v", services.ErrInvalidRedirect, err)
+	}
+	if _, err := m.GetRedirectRules(ctx, id, uuid); err != nil {
+		return err
+	}
+	if uuid == "viewer" {
+		return services.ErrForbidden
+	}
+	m.rules = rules
+	return nil
+}
+
+func (m *mockURLShortenerService) Login(ctx context.Context, login, password, mergeFrom string) (models.User, int, error) {
+	if password != "password" {
+		return models.User{}, 0, services.ErrInvalidCredentials
+	}
+	return models.User{ID: "account", Login: login}, 0, nil
+}
+
 func Test_handleCreate(t *testing.T) {
 	type want struct {
 		statusCode  int
@@ -155,15 +318,18 @@ func Test_handleCreate(t *testing.T) {
 
 func Test_handleRedirect(t *testing.T) {
 	type want struct {
-		statusCode int
-		location   string
+		statusCode     int
+		location       string
+		cacheControl   string
+		referrerPolicy string
 	}
 	tests := []struct {
-		name    string
-		method  string
-		reqURL  string
-		getFunc func(id string) (models.ShortURL, error)
-		want    want
+		name     string
+		method   string
+		reqURL   string
+		getFunc  func(id string) (models.ShortURL, error)
+		defaults models.RedirectOptions
+		want     want
 	}{
 		{
 			name:   "success test case",
@@ -177,6 +343,41 @@ func Test_handleRedirect(t *testing.T) {
 				location:   "http://ya.ru",
 			},
 		},
+		{
+			name:   "link redirect options",
+			method: http.MethodGet,
+			reqURL: "/12345678",
+			getFunc: func(id string) (models.ShortURL, error) {
+				return models.ShortURL{OriginalURL: "https://ya.ru", ID: "12345678", RedirectOptions: models.RedirectOptions{
+					RedirectCode:   http.StatusPermanentRedirect,
+					CacheControl:   "public, max-age=86400",
+					ReferrerPolicy: "no-referrer",
+				}}, nil
+			},
+			want: want{
+				statusCode:     http.StatusPermanentRedirect,
+				location:       "https://ya.ru",
+				cacheControl:   "public, max-age=86400",
+				referrerPolicy: "no-referrer",
+			},
+		},
+		{
+			name:   "server defaults",
+			method: http.MethodGet,
+			reqURL: "/12345678",
+			getFunc: func(id string) (models.ShortURL, error) {
+				return models.ShortURL{OriginalURL: "https://ya.ru", ID: "12345678", RedirectOptions: models.RedirectOptions{
+					ReferrerPolicy: "origin",
+				}}, nil
+			},
+			defaults: models.RedirectOptions{RedirectCode: http.StatusFound, CacheControl: "no-store", ReferrerPolicy: "no-referrer"},
+			want: want{
+				statusCode:     http.StatusFound,
+				location:       "https://ya.ru",
+				cacheControl:   "no-store",
+				referrerPolicy: "origin",
+			},
+		},
 		{
 			name:    "test case error",
 			method:  http.MethodGet,
@@ -195,7 +396,7 @@ func Test_handleRedirect(t *testing.T) {
 			c.Request = httptest.NewRequest(tt.method, tt.reqURL, nil)
 			m := &mockURLShortenerService{}
 			m.GetFunc = tt.getFunc
-			handlers := NewHandlers(m, "", nil, nil)
+			handlers := NewHandlers(m, "", nil, nil).WithRedirectDefaults(tt.defaults)
 			h := handlers.HandleRedirect
 			h(c)
 
@@ -203,6 +404,62 @@ func Test_handleRedirect(t *testing.T) {
 			defer res.Body.Close()
 			assert.Equal(t, tt.want.statusCode, res.StatusCode)
 			assert.Equal(t, tt.want.location, res.Header.Get("Location"))
+			assert.Equal(t, tt.want.cacheControl, res.Header.Get("Cache-Control"))
+			assert.Equal(t, tt.want.referrerPolicy, res.Header.Get("Referrer-Policy"))
+		})
+	}
+}
+
+func Test_handleRedirectPassthrough(t *testing.T) {
+	tests := []struct {
+		name     string
+		reqURL   string
+		link     models.RedirectOptions
+		defaults models.RedirectOptions
+		location string
+	}{
+		{
+			name:     "ignore by default",
+			reqURL:   "/12345678/docs?utm_source=x",
+			location: "https://ya.ru/search?q=go",
+		},
+		{
+			name:     "append query and path",
+			reqURL:   "/12345678/docs/intro?utm_source=x",
+			link:     models.RedirectOptions{QueryMode: models.PassthroughAppend, PathMode: models.PassthroughAppend},
+			location: "https://ya.ru/search/docs/intro?q=go&utm_source=x",
+		},
+		{
+			name:     "override from server defaults",
+			reqURL:   "/12345678/maps?q=rust",
+			defaults: models.RedirectOptions{QueryMode: models.PassthroughOverride, PathMode: models.PassthroughOverride},
+			location: "https://ya.ru/maps?q=rust",
+		},
+		{
+			name:     "link mode wins over defaults",
+			reqURL:   "/12345678?utm_source=x",
+			link:     models.RedirectOptions{QueryMode: models.PassthroughIgnore},
+			defaults: models.RedirectOptions{QueryMode: models.PassthroughAppend},
+			location: "https://ya.ru/search?q=go",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &mockURLShortenerService{}
+			m.GetFunc = func(id string) (models.ShortURL, error) {
+				assert.Equal(t, "12345678", id)
+				return models.ShortURL{OriginalURL: "https://ya.ru/search?q=go", ID: id, RedirectOptions: tt.link}, nil
+			}
+			handlers := NewHandlers(m, "", nil, nil).WithRedirectDefaults(tt.defaults)
+			r := gin.New()
+			r.GET("/:ID", handlers.HandleRedirect)
+			r.GET("/:ID/*rest", handlers.HandleRedirect)
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.reqURL, nil))
+
+			assert.Equal(t, http.StatusTemporaryRedirect, w.Code)
+			assert.Equal(t, tt.location, w.Header().Get("Location"))
 		})
 	}
 }
@@ -215,6 +472,7 @@ func TestHandleShorten(t *testing.T) {
 		input          []byte
 		expectedStatus int
 		mockFunc       func(originalURL string) (url models.ShortURL, err error)
+		wantRedirect   models.RedirectOptions
 	}{
 		{
 			name:           "invalid json",
@@ -244,6 +502,21 @@ func TestHandleShorten(t *testing.T) {
 				return models.ShortURL{ID: "123456"}, nil
 			},
 		},
+		{
+			name:           "redirect options",
+			input:          []byte(`{"url": "https://example.com", "redirect_code": 301, "cache_control": "max-age=600", "referrer_policy": "origin"}`),
+			expectedStatus: http.StatusCreated,
+			mockFunc: func(originalURL string) (url models.ShortURL, err error) {
+				return models.ShortURL{ID: "123456"}, nil
+			},
+			wantRedirect: models.RedirectOptions{RedirectCode: 301, CacheControl: "max-age=600", ReferrerPolicy: "origin"},
+		},
+		{
+			name:           "invalid redirect code",
+			input:          []byte(`{"url": "https://example.com", "redirect_code": 200}`),
+			expectedStatus: http.StatusBadRequest,
+			wantRedirect:   models.RedirectOptions{RedirectCode: 200},
+		},
 	}
 
 	for _, tt := range tests {
@@ -266,6 +539,683 @@ func TestHandleShorten(t *testing.T) {
 			router.ServeHTTP(resp, req)
 
 			assert.Equal(t, tt.expectedStatus, resp.Code)
+			assert.Equal(t, tt.wantRedirect, mockService.createdRedirect)
+		})
+	}
+}
+
+func TestHandleShortenBatch(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockService := &mockURLShortenerService{}
+	sh := NewHandlers(mockService, "http://example.com", auth.NewAuth(), logging.NewLogrusLogger(logrus.ErrorLevel))
+	router := gin.New()
+	router.POST("/api/shorten/batch", sh.HandleShortenBatch)
+
+	body := `[
+		{"correlation_id": "1", "original_url": "https://a.example"},
+		{"correlation_id": "2", "original_url": "https://b.example", "redirect_code": 308, "cache_control": "no-cache"}
+	]`
+	req := httptest.NewRequest(http.MethodPost, "/api/shorten/batch", strings.NewReader(body))
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusCreated, resp.Code)
+	assert.Equal(t, []services.BatchURL{
+		{OriginalURL: "https://a.example"},
+		{OriginalURL: "https://b.example", Redirect: models.RedirectOptions{RedirectCode: 308, CacheControl: "no-cache"}},
+	}, mockService.batch)
+	assert.JSONEq(t, `[
+		{"correlation_id": "1", "short_url": "http://example.com/id1"},
+		{"correlation_id": "2", "short_url": "http://example.com/id2"}
+	]`, resp.Body.String())
+}
+
+func TestHandleShorten_APIKey(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name           string
+		header         string
+		value          string
+		expectedStatus int
+		expectedUUID   string
+	}{
+		{
+			name:           "x-api-key header",
+			header:         "X-API-Key",
+			value:          "us_valid",
+			expectedStatus: http.StatusCreated,
+			expectedUUID:   "key-owner",
+		},
+		{
+			name:           "bearer header",
+			header:         "Authorization",
+			value:          "Bearer us_valid",
+			expectedStatus: http.StatusCreated,
+			expectedUUID:   "key-owner",
+		},
+		{
+			name:           "revoked key",
+			header:         "X-API-Key",
+			value:          "us_revoked",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "storage unavailable",
+			header:         "X-API-Key",
+			value:          "us_unchecked",
+			expectedStatus: http.StatusServiceUnavailable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			mockService := &mockURLShortenerService{
+				CreateFunc: func(originalURL string) (url models.ShortURL, err error) {
+					return models.ShortURL{ID: "123456"}, nil
+				},
+				ResolveAPIKeyFunc: func(key string) (string, error) {
+					switch key {
+					case "us_valid":
+						return "key-owner", nil
+					case "us_unchecked":
+						return "", services.ErrAPIKeyUnavailable
+					}
+					return "", services.ErrInvalidAPIKey
+				},
+			}
+			sh := NewHandlers(mockService, "http://example.com", auth.NewAuth(), logging.NewLogrusLogger(logrus.DebugLevel))
+			router.POST("/shorten", sh.HandleShorten)
+
+			req, _ := http.NewRequest(http.MethodPost, "/shorten", strings.NewReader(`{"url": "https://example.com"}`))
+			req.Header.Set(tt.header, tt.value)
+			resp := httptest.NewRecorder()
+
+			router.ServeHTTP(resp, req)
+
+			assert.Equal(t, tt.expectedStatus, resp.Code)
+			assert.Equal(t, tt.expectedUUID, mockService.createdForUUID)
+		})
+	}
+}
+
+func TestHandleLogin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name           string
+		input          string
+		expectedStatus int
+		expectCookie   bool
+	}{
+		{
+			name:           "valid credentials",
+			input:          `{"login": "user", "password": "password"}`,
+			expectedStatus: http.StatusOK,
+			expectCookie:   true,
+		},
+		{
+			name:           "wrong password",
+			input:          `{"login": "user", "password": "wrong"}`,
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "invalid json",
+			input:          `{"login": `,
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			authService := auth.NewAuth()
+			sh := NewHandlers(&mockURLShortenerService{}, "http://example.com", authService, logging.NewLogrusLogger(logrus.DebugLevel))
+			router.POST("/login", sh.HandleLogin)
+
+			req, _ := http.NewRequest(http.MethodPost, "/login", strings.NewReader(tt.input))
+			resp := httptest.NewRecorder()
+			router.ServeHTTP(resp, req)
+
+			assert.Equal(t, tt.expectedStatus, resp.Code)
+			cookies := resp.Result().Cookies()
+			if !tt.expectCookie {
+				assert.Empty(t, cookies)
+				return
+			}
+			require.Len(t, cookies, 1)
+			assert.Equal(t, "account", authService.ValidateToken(cookies[0].Value))
+		})
+	}
+}
+
+func TestRequireAdmin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	authService := auth.NewAuth()
+	adminToken, err := authService.GenerateToken("admin-user")
+	require.NoError(t, err)
+	userToken, err := authService.GenerateToken("regular-user")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name           string
+		header         string
+		value          string
+		cookie         string
+		expectedStatus int
+	}{
+		{
+			name:           "admin token",
+			header:         "X-Admin-Token",
+			value:          "admin-secret",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "wrong admin token",
+			header:         "X-Admin-Token",
+			value:          "guess",
+			cookie:         adminToken,
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "admin user id",
+			cookie:         adminToken,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "regular user",
+			cookie:         userToken,
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "anonymous",
+			expectedStatus: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			sh := NewHandlers(&mockURLShortenerService{}, "http://example.com", authService, logging.NewLogrusLogger(logrus.DebugLevel)).
+				WithAdmins([]string{"admin-user"}, "admin-secret")
+			router.GET("/admin/urls", sh.RequireAdmin, sh.HandleAdminSearchURLs)
+
+			req, _ := http.NewRequest(http.MethodGet, "/admin/urls?q=example", nil)
+			if tt.header != "" {
+				req.Header.Set(tt.header, tt.value)
+			}
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: "jwt_token", Value: tt.cookie})
+			}
+			resp := httptest.NewRecorder()
+			router.ServeHTTP(resp, req)
+
+			assert.Equal(t, tt.expectedStatus, resp.Code)
+		})
+	}
+}
+
+func TestHandleReadiness(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name           string
+		storageErr     error
+		draining       bool
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "ready",
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"status":"ok","components":{"storage":{"status":"ok"}}}`,
+		},
+		{
+			name:           "storage unavailable",
+			storageErr:     errors.New("connection refused"),
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedBody:   `{"status":"fail","components":{"storage":{"status":"fail","error":"connection refused"}}}`,
+		},
+		{
+			name:           "draining",
+			draining:       true,
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedBody:   `{"status":"draining"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checker := health.NewChecker().Add("storage", func(context.Context) error { return tt.storageErr })
+			if tt.draining {
+				checker.SetDraining()
+			}
+			sh := NewHandlers(&mockURLShortenerService{}, "http://example.com", auth.NewAuth(), logging.NewLogrusLogger(logrus.DebugLevel)).
+				WithHealth(checker)
+			router := gin.New()
+			router.GET("/healthz", sh.HandleLiveness)
+			router.GET("/readyz", sh.HandleReadiness)
+
+			resp := httptest.NewRecorder()
+			router.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+			assert.Equal(t, tt.expectedStatus, resp.Code)
+			assert.JSONEq(t, tt.expectedBody, resp.Body.String())
+
+			// Liveness не зависит ни от компонентов, ни от остановки
+			resp = httptest.NewRecorder()
+			router.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+			assert.Equal(t, http.StatusOK, resp.Code)
+		})
+	}
+}
+
+func TestHandleLogout(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	authService := auth.NewAuth()
+	mockService := &mockURLShortenerService{}
+	sh := NewHandlers(mockService, "http://example.com", authService, logging.NewLogrusLogger(logrus.DebugLevel))
+
+	router := gin.New()
+	router.POST("/login", sh.HandleLogin)
+	router.POST("/logout", sh.HandleLogout)
+	router.GET("/sessions", sh.HandleListSessions)
+
+	req, _ := http.NewRequest(http.MethodPost, "/login", strings.NewReader(`{"login": "user", "password": "password"}`))
+	req.Header.Set("User-Agent", "laptop")
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	require.Equal(t, http.StatusOK, resp.Code)
+	token := resp.Header().Get(auth.TokenHeader)
+	require.NotEmpty(t, token)
+	require.Len(t, mockService.sessions, 1)
+	assert.Equal(t, "laptop", mockService.sessions[0].UserAgent)
+
+	req, _ = http.NewRequest(http.MethodGet, "/sessions", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Contains(t, resp.Body.String(), `"current":true`)
+
+	req, _ = http.NewRequest(http.MethodPost, "/logout", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusNoContent, resp.Code)
+	require.Len(t, mockService.revoked, 1)
+	assert.Equal(t, mockService.sessions[0].ID, mockService.revoked[0].ID)
+
+	// Отозванный токен больше не принимается
+	assert.Equal(t, "", authService.ValidateToken(token))
+}
+
+func TestHandleOrgURLs(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	authService := auth.NewAuth()
+	viewerToken, err := authService.GenerateToken("viewer")
+	require.NoError(t, err)
+	editorToken, err := authService.GenerateToken("editor")
+	require.NoError(t, err)
+
+	mockService := &mockURLShortenerService{}
+	sh := NewHandlers(mockService, "http://example.com", authService, logging.NewLogrusLogger(logrus.DebugLevel))
+	router := gin.New()
+	router.GET("/api/orgs/:org/urls", sh.HandleListOrgURLs)
+	router.POST("/api/orgs/:org/urls", sh.HandleCreateOrgURL)
+	router.PUT("/api/orgs/:org/members/:uuid", sh.HandleSetOrgMember)
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		body           string
+		token          string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "anonymous",
+			method:         http.MethodGet,
+			path:           "/api/orgs/org/urls",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "list urls",
+			method:         http.MethodGet,
+			path:           "/api/orgs/org/urls",
+			token:          viewerToken,
+			expectedStatus: http.StatusOK,
+			expectedBody:   `"created_by":"creator"`,
+		},
+		{
+			name:           "unknown org",
+			method:         http.MethodGet,
+			path:           "/api/orgs/other/urls",
+			token:          viewerToken,
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "viewer can't create",
+			method:         http.MethodPost,
+			path:           "/api/orgs/org/urls",
+			body:           `{"url": "https://example.com"}`,
+			token:          viewerToken,
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "editor creates",
+			method:         http.MethodPost,
+			path:           "/api/orgs/org/urls",
+			body:           `{"url": "https://example.com", "redirect_code": 301}`,
+			token:          editorToken,
+			expectedStatus: http.StatusCreated,
+			expectedBody:   `"short_url":"http://example.com/abc"`,
+		},
+		{
+			name:           "invalid role",
+			method:         http.MethodPut,
+			path:           "/api/orgs/org/members/someone",
+			body:           `{"role": "admin"}`,
+			token:          editorToken,
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			resp := httptest.NewRecorder()
+			router.ServeHTTP(resp, req)
+
+			assert.Equal(t, tt.expectedStatus, resp.Code)
+			assert.Contains(t, resp.Body.String(), tt.expectedBody)
+		})
+	}
+	assert.Equal(t, http.StatusMovedPermanently, mockService.createdRedirect.RedirectCode)
+}
+
+func TestHandleRedirectRules(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	authService := auth.NewAuth()
+	ownerToken, err := authService.GenerateToken("owner")
+	require.NoError(t, err)
+	viewerToken, err := authService.GenerateToken("viewer")
+	require.NoError(t, err)
+
+	mockService := &mockURLShortenerService{}
+	sh := NewHandlers(mockService, "http://example.com", authService, logging.NewLogrusLogger(logrus.DebugLevel))
+	router := gin.New()
+	router.GET("/api/user/urls/:id/rules", sh.HandleGetRedirectRules)
+	router.PUT("/api/user/urls/:id/rules", sh.HandleSetRedirectRules)
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		body           string
+		token          string
+		expectedStatus int
+		expectedBody   string
+		wantRules      []models.RedirectRule
+	}{
+		{
+			name:           "anonymous",
+			method:         http.MethodGet,
+			path:           "/api/user/urls/abc/rules",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "get rules",
+			method:         http.MethodGet,
+			path:           "/api/user/urls/abc/rules",
+			token:          viewerToken,
+			expectedStatus: http.StatusOK,
+			expectedBody:   `[{"os":"ios","url":"https://apps.apple.com/app/id1"}]`,
+		},
+		{
+			name:           "unknown link",
+			method:         http.MethodGet,
+			path:           "/api/user/urls/xyz/rules",
+			token:          ownerToken,
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "set rules",
+			method:         http.MethodPut,
+			path:           "/api/user/urls/abc/rules",
+			body:           `[{"os": "android", "url": "https://play.google.com/store/apps/details?id=app"}, {"language": "ru", "url": "https://example.ru"}]`,
+			token:          ownerToken,
+			expectedStatus: http.StatusOK,
+			expectedBody:   `"os":"android"`,
+			wantRules: []models.RedirectRule{
+				{OS: models.OSAndroid, URL: "https://play.google.com/store/apps/details?id=app"},
+				{Language: "ru", URL: "https://example.ru"},
+			},
+		},
+		{
+			name:           "clear rules",
+			method:         http.MethodPut,
+			path:           "/api/user/urls/abc/rules",
+			body:           `[]`,
+			token:          ownerToken,
+			expectedStatus: http.StatusOK,
+			expectedBody:   `[]`,
+			wantRules:      []models.RedirectRule{},
+		},
+		{
+			name:           "viewer can't set",
+			method:         http.MethodPut,
+			path:           "/api/user/urls/abc/rules",
+			body:           `[{"os": "ios", "url": "https://apps.apple.com/app/id1"}]`,
+			token:          viewerToken,
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "unknown os",
+			method:         http.MethodPut,
+			path:           "/api/user/urls/abc/rules",
+			body:           `[{"os": "symbian", "url": "https://example.com"}]`,
+			token:          ownerToken,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   "os should be ios, android or desktop",
+		},
+		{
+			name:           "rule without conditions",
+			method:         http.MethodPut,
+			path:           "/api/user/urls/abc/rules",
+			body:           `[{"url": "https://example.com"}]`,
+			token:          ownerToken,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "invalid json",
+			method:         http.MethodPut,
+			path:           "/api/user/urls/abc/rules",
+			body:           `{"os": "ios"}`,
+			token:          ownerToken,
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockService.rules = nil
+			req, _ := http.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			resp := httptest.NewRecorder()
+			router.ServeHTTP(resp, req)
+
+			assert.Equal(t, tt.expectedStatus, resp.Code)
+			assert.Contains(t, resp.Body.String(), tt.expectedBody)
+			if tt.wantRules != nil {
+				assert.Equal(t, tt.wantRules, mockService.rules)
+			}
+		})
+	}
+}
+
+func Test_handleRedirectRules(t *testing.T) {
+	link := models.ShortURL{
+		ID:          "12345678",
+		OriginalURL: "https://example.com/app",
+		Rules: []models.RedirectRule{
+			{OS: models.OSiOS, URL: "https://apps.apple.com/app/id1"},
+			{OS: models.OSAndroid, URL: "https://play.google.com/store/apps/details?id=app"},
+			{Language: "ru", URL: "https://example.ru/app"},
+		},
+		RedirectOptions: models.RedirectOptions{QueryMode: models.PassthroughAppend},
+	}
+	tests := []struct {
+		name           string
+		userAgent      string
+		acceptLanguage string
+		location       string
+	}{
+		{
+			name:      "iphone",
+			userAgent: "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.0 Mobile/15E148 Safari/604.1",
+			location:  "https://apps.apple.com/app/id1?utm_source=x",
+		},
+		{
+			name:           "android",
+			userAgent:      "Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Mobile Safari/537.36",
+			acceptLanguage: "ru-RU,ru;q=0.9",
+			location:       "https://play.google.com/store/apps/details?id=app&utm_source=x",
+		},
+		{
+			name:           "desktop in russian",
+			userAgent:      "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
+			acceptLanguage: "ru-RU,ru;q=0.9,en;q=0.8",
+			location:       "https://example.ru/app?utm_source=x",
+		},
+		{
+			name:      "fallback to original url",
+			userAgent: "curl/8.4.0",
+			location:  "https://example.com/app?utm_source=x",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &mockURLShortenerService{}
+			m.GetFunc = func(id string) (models.ShortURL, error) { return link, nil }
+			handlers := NewHandlers(m, "", nil, nil)
+			r := gin.New()
+			r.GET("/:ID", handlers.HandleRedirect)
+
+			req := httptest.NewRequest(http.MethodGet, "/12345678?utm_source=x", nil)
+			req.Header.Set("User-Agent", tt.userAgent)
+			req.Header.Set("Accept-Language", tt.acceptLanguage)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusTemporaryRedirect, w.Code)
+			assert.Equal(t, tt.location, w.Header().Get("Location"))
+			assert.Equal(t, "User-Agent, Accept-Language", w.Header().Get("Vary"))
+		})
+	}
+}
+
+type mockOIDCProvider struct{}
+
+func (mockOIDCProvider) AuthCodeURL(mergeFrom string) (string, string, error) {
+	return "https://idp.example.com/authorize?state=started", "started", nil
+}
+
+func (mockOIDCProvider) Exchange(ctx context.Context, state, code string) (auth.OIDCIdentity, error) {
+	if state != "started" {
+		return auth.OIDCIdentity{}, auth.ErrUnknownOIDCState
+	}
+	return auth.OIDCIdentity{ExternalID: "https://idp.example.com|42", Subject: "42"}, nil
+}
+
+func TestHandleOIDCCallback_State(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	sh := NewHandlers(&mockURLShortenerService{}, "http://example.com", auth.NewAuth(), logging.NewLogrusLogger(logrus.DebugLevel)).
+		WithOIDC(mockOIDCProvider{})
+	router := gin.New()
+	router.GET("/api/auth/oidc/login", sh.HandleOIDCLogin)
+	router.GET("/api/auth/oidc/callback", sh.HandleOIDCCallback)
+
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/api/auth/oidc/login", nil))
+	require.Equal(t, http.StatusFound, resp.Code)
+	var stateCookie *http.Cookie
+	for _, c := range resp.Result().Cookies() {
+		if c.Name == oidcStateCookie {
+			stateCookie = c
+		}
+	}
+	require.NotNil(t, stateCookie)
+	assert.Equal(t, "started", stateCookie.Value)
+	assert.True(t, stateCookie.HttpOnly)
+	assert.Equal(t, http.SameSiteLaxMode, stateCookie.SameSite)
+
+	tests := []struct {
+		name           string
+		cookie         string
+		expectedStatus int
+	}{
+		// Ссылка на callback чужого логина, открытая в браузере жертвы
+		{name: "no state cookie", expectedStatus: http.StatusBadRequest},
+		{name: "state of another login", cookie: "other", expectedStatus: http.StatusBadRequest},
+		{name: "same browser", cookie: "started", expectedStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/auth/oidc/callback?state=started&code=code", nil)
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: oidcStateCookie, Value: tt.cookie})
+			}
+			resp := httptest.NewRecorder()
+			router.ServeHTTP(resp, req)
+			assert.Equal(t, tt.expectedStatus, resp.Code)
+			if tt.expectedStatus == http.StatusOK {
+				assert.Contains(t, resp.Header().Values("Set-Cookie"), "oidc_state=; Path=/api/auth/oidc; Max-Age=0; HttpOnly; SameSite=Lax")
+			}
+		})
+	}
+}
+
+func TestHandlePing(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name           string
+		pingErr        error
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "storage available",
+			expectedStatus: http.StatusOK,
+			expectedBody:   "file storage is available",
+		},
+		{
+			name:           "storage unavailable",
+			pingErr:        errors.New("disk is full"),
+			expectedStatus: http.StatusInternalServerError,
+			expectedBody:   "file storage is unavailable",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sh := NewHandlers(&mockURLShortenerService{pingErr: tt.pingErr}, "http://example.com", auth.NewAuth(),
+				logging.NewLogrusLogger(logrus.ErrorLevel)).WithStorageBackend("file")
+			router := gin.New()
+			router.GET("/ping", sh.HandlePing)
+
+			resp := httptest.NewRecorder()
+			router.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/ping", nil))
+
+			assert.Equal(t, tt.expectedStatus, resp.Code)
+			assert.Equal(t, tt.expectedBody, resp.Body.String())
 		})
 	}
 }
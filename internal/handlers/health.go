@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/maxzhirnov/urlshort/internal/health"
+)
+
+type readinessChecker interface {
+	Check(ctx context.Context) health.Report
+}
+
+// WithHealth sets checks run by readiness probe, by default it has no checks and reports ok
+func (h *Handlers) WithHealth(c readinessChecker) *Handlers {
+	h.health = c
+	return h
+}
+
+// HandleLiveness reports that process is able to serve HTTP, it doesn't touch dependencies
+// so a broken database makes the instance unready instead of restarting it
+func (h *Handlers) HandleLiveness(c *gin.Context) {
+	c.JSON(http.StatusOK, health.Report{Status: health.StatusOK})
+}
+
+// HandleReadiness runs component checks, responds with 503 if any of them failed
+// or shutdown has started
+func (h *Handlers) HandleReadiness(c *gin.Context) {
+	report := h.health.Check(c.Request.Context())
+	if report.Status == health.StatusFail {
+		h.logger.Warn("readiness check failed", "status", report.Status, "components", report.Components)
+	}
+	if !report.Ready() {
+		c.JSON(http.StatusServiceUnavailable, report)
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/maxzhirnov/urlshort/internal/auth"
+)
+
+// oidcStateCookie binds the login to the browser which started it, without it
+// a callback url of somebody else's login would log the victim into that account
+const (
+	oidcStateCookie     = "oidc_state"
+	oidcStateCookiePath = "/api/auth/oidc"
+)
+
+type oidcProvider interface {
+	AuthCodeURL(mergeFrom string) (authURL, state string, err error)
+	Exchange(ctx context.Context, state, code string) (auth.OIDCIdentity, error)
+}
+
+// WithOIDC enables login through OpenID Connect provider
+func (h *Handlers) WithOIDC(p oidcProvider) *Handlers {
+	h.oidc = p
+	return h
+}
+
+// HandleOIDCLogin redirects to the identity provider, ?merge=true moves links
+// of the current anonymous user to the account after login
+func (h *Handlers) HandleOIDCLogin(c *gin.Context) {
+	if h.oidc == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "oidc login is not configured"})
+		return
+	}
+
+	merge, _ := strconv.ParseBool(c.Query("merge"))
+	redirectURL, state, err := h.oidc.AuthCodeURL(h.mergeSource(c, merge))
+	if errors.Is(err, auth.ErrTooManyPendingLogins) {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		h.logger.Error("error starting oidc login", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "something went wrong"})
+		return
+	}
+
+	h.setOIDCStateCookie(c, state, int(auth.OIDCLoginTTL.Seconds()))
+	c.Redirect(http.StatusFound, redirectURL)
+}
+
+func (h *Handlers) HandleOIDCCallback(c *gin.Context) {
+	if h.oidc == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "oidc login is not configured"})
+		return
+	}
+
+	if providerErr := c.Query("error"); providerErr != "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": providerErr, "description": c.Query("error_description")})
+		return
+	}
+
+	state := c.Query("state")
+	cookie, err := c.Request.Cookie(oidcStateCookie)
+	if err != nil || state == "" || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "login was started in another browser"})
+		return
+	}
+	h.setOIDCStateCookie(c, "", -1)
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+	identity, err := h.oidc.Exchange(ctx, state, c.Query("code"))
+	if errors.Is(err, auth.ErrUnknownOIDCState) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		h.logger.Error("error finishing oidc login", "error", err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "couldn't authenticate with identity provider"})
+		return
+	}
+
+	user, merged, err := h.service.LoginExternal(c.Request.Context(), identity.ExternalID, identity.MergeFrom)
+	if err != nil {
+		h.logger.Error("error logging in external user", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "something went wrong"})
+		return
+	}
+
+	if err := h.issueTokenCookie(c, user.ID); err != nil {
+		h.logger.Error("error generating token", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "something went wrong"})
+		return
+	}
+
+	c.JSON(http.StatusOK, newAccountDTO(user, merged))
+}
+
+// setOIDCStateCookie sets state of the started login, negative maxAge removes the cookie.
+// SameSite=Lax still sends it on the top-level redirect back from the provider
+func (h *Handlers) setOIDCStateCookie(c *gin.Context, state string, maxAge int) {
+	secure := false
+	if h.auth != nil {
+		secure = h.auth.CookieConfig().Secure
+	}
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     oidcStateCookiePath,
+		MaxAge:   maxAge,
+		Secure:   secure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
@@ -0,0 +1,266 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/maxzhirnov/urlshort/internal/models"
+	"github.com/maxzhirnov/urlshort/internal/services"
+)
+
+type OrgDTO struct {
+	ID        string         `json:"id"`
+	Name      string         `json:"name"`
+	Role      models.OrgRole `json:"role"`
+	CreatedAt time.Time      `json:"created_at"`
+}
+
+type OrgMemberDTO struct {
+	UUID string         `json:"uuid"`
+	Role models.OrgRole `json:"role"`
+}
+
+type OrgURLDTO struct {
+	ID          string `json:"id"`
+	ShortURL    string `json:"short_url"`
+	OriginalURL string `json:"original_url"`
+	CreatedBy   string `json:"created_by"`
+	Deleted     bool   `json:"deleted"`
+	Disabled    bool   `json:"disabled"`
+}
+
+func (h *Handlers) newOrgURLDTO(u models.ShortURL) OrgURLDTO {
+	return OrgURLDTO{
+		ID:          u.ID,
+		ShortURL:    h.baseURL + "/" + u.ID,
+		OriginalURL: u.OriginalURL,
+		CreatedBy:   u.UUID,
+		Deleted:     u.DeletedFlag,
+		Disabled:    u.Disabled,
+	}
+}
+
+func (h *Handlers) HandleCreateOrg(c *gin.Context) {
+	userID, err := h.resolveUserID(c)
+	if err != nil {
+		h.respondAuthError(c, err)
+		return
+	}
+
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(c.Request.Body).Decode(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "you should provide correct data"})
+		return
+	}
+	defer c.Request.Body.Close()
+
+	org, err := h.service.CreateOrganization(c.Request.Context(), req.Name, userID)
+	if err != nil {
+		h.respondOrgError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, OrgDTO{ID: org.ID, Name: org.Name, Role: models.RoleOwner, CreatedAt: org.CreatedAt})
+}
+
+func (h *Handlers) HandleListOrgs(c *gin.Context) {
+	userID, err := h.resolveUserID(c)
+	if err != nil {
+		h.respondAuthError(c, err)
+		return
+	}
+
+	orgs, err := h.service.GetOrganizations(c.Request.Context(), userID)
+	if err != nil {
+		h.respondOrgError(c, err)
+		return
+	}
+
+	res := make([]OrgDTO, len(orgs))
+	for i, o := range orgs {
+		res[i] = OrgDTO{ID: o.ID, Name: o.Name, Role: o.Role, CreatedAt: o.CreatedAt}
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+func (h *Handlers) HandleListOrgMembers(c *gin.Context) {
+	userID, err := h.resolveUserID(c)
+	if err != nil {
+		h.respondAuthError(c, err)
+		return
+	}
+
+	members, err := h.service.GetOrgMembers(c.Request.Context(), c.Param("org"), userID)
+	if err != nil {
+		h.respondOrgError(c, err)
+		return
+	}
+
+	res := make([]OrgMemberDTO, len(members))
+	for i, m := range members {
+		res[i] = OrgMemberDTO{UUID: m.UUID, Role: m.Role}
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+// HandleSetOrgMember adds user to organization or changes the role, body is {"role": "editor"}
+func (h *Handlers) HandleSetOrgMember(c *gin.Context) {
+	userID, err := h.resolveUserID(c)
+	if err != nil {
+		h.respondAuthError(c, err)
+		return
+	}
+
+	var req struct {
+		Role models.OrgRole `json:"role"`
+	}
+	if err := json.NewDecoder(c.Request.Body).Decode(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "you should provide correct data"})
+		return
+	}
+	defer c.Request.Body.Close()
+
+	if err := h.service.SetMemberRole(c.Request.Context(), c.Param("org"), userID, c.Param("uuid"), req.Role); err != nil {
+		h.respondOrgError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, OrgMemberDTO{UUID: c.Param("uuid"), Role: req.Role})
+}
+
+func (h *Handlers) HandleRemoveOrgMember(c *gin.Context) {
+	userID, err := h.resolveUserID(c)
+	if err != nil {
+		h.respondAuthError(c, err)
+		return
+	}
+
+	if err := h.service.RemoveMember(c.Request.Context(), c.Param("org"), userID, c.Param("uuid")); err != nil {
+		h.respondOrgError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (h *Handlers) HandleListOrgURLs(c *gin.Context) {
+	userID, err := h.resolveUserID(c)
+	if err != nil {
+		h.respondAuthError(c, err)
+		return
+	}
+
+	urls, err := h.service.GetOrgURLs(c.Request.Context(), c.Param("org"), userID)
+	if err != nil {
+		h.respondOrgError(c, err)
+		return
+	}
+
+	res := make([]OrgURLDTO, len(urls))
+	for i, u := range urls {
+		res[i] = h.newOrgURLDTO(u)
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+func (h *Handlers) HandleCreateOrgURL(c *gin.Context) {
+	userID, err := h.resolveUserID(c)
+	if err != nil {
+		h.respondAuthError(c, err)
+		return
+	}
+
+	var req struct {
+		URL string `json:"url"`
+		models.RedirectOptions
+	}
+	if err := json.NewDecoder(c.Request.Body).Decode(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "you should provide correct data"})
+		return
+	}
+	defer c.Request.Body.Close()
+
+	if len(req.URL) < 3 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "url should be valid url"})
+		return
+	}
+
+	url, err := h.service.CreateOrgURL(c.Request.Context(), c.Param("org"), userID, req.URL, req.RedirectOptions)
+	if errors.Is(err, services.ErrInvalidRedirect) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, services.ErrEntityAlreadyExist) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		h.respondOrgError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, h.newOrgURLDTO(url))
+}
+
+// HandleTransferOrgURLs moves links created by the caller to organization, body is a list of ids
+func (h *Handlers) HandleTransferOrgURLs(c *gin.Context) {
+	userID, err := h.resolveUserID(c)
+	if err != nil {
+		h.respondAuthError(c, err)
+		return
+	}
+
+	var ids []string
+	if err := json.NewDecoder(c.Request.Body).Decode(&ids); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "you should provide correct data"})
+		return
+	}
+	defer c.Request.Body.Close()
+
+	moved, err := h.service.TransferURLs(c.Request.Context(), c.Param("org"), userID, ids)
+	if err != nil {
+		h.respondOrgError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"moved": moved})
+}
+
+func (h *Handlers) HandleDeleteOrgURLs(c *gin.Context) {
+	userID, err := h.resolveUserID(c)
+	if err != nil {
+		h.respondAuthError(c, err)
+		return
+	}
+
+	var ids []string
+	if err := json.NewDecoder(c.Request.Body).Decode(&ids); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "you should provide correct data"})
+		return
+	}
+	defer c.Request.Body.Close()
+
+	if err := h.service.DeleteOrgURLs(c.Request.Context(), c.Param("org"), userID, ids); err != nil {
+		h.respondOrgError(c, err)
+		return
+	}
+	c.JSON(http.StatusAccepted, "accepted")
+}
+
+func (h *Handlers) respondOrgError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "organization not found"})
+	case errors.Is(err, services.ErrForbidden):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrInvalidOrgName),
+		errors.Is(err, services.ErrInvalidRole),
+		errors.Is(err, services.ErrInvalidUUID),
+		errors.Is(err, services.ErrLastOwner):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		h.logger.Error("error handling organization request", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "something went wrong"})
+	}
+}
@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/maxzhirnov/urlshort/internal/models"
+	"github.com/maxzhirnov/urlshort/internal/services"
+)
+
+// HandleGetRedirectRules returns device and language rules of the link
+func (h *Handlers) HandleGetRedirectRules(c *gin.Context) {
+	userID, err := h.resolveUserID(c)
+	if err != nil {
+		h.respondAuthError(c, err)
+		return
+	}
+
+	rules, err := h.service.GetRedirectRules(c.Request.Context(), c.Param("id"), userID)
+	if err != nil {
+		h.respondRulesError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, rules)
+}
+
+// HandleSetRedirectRules replaces rules of the link, body is a list of rules and empty list removes them
+func (h *Handlers) HandleSetRedirectRules(c *gin.Context) {
+	userID, err := h.resolveUserID(c)
+	if err != nil {
+		h.respondAuthError(c, err)
+		return
+	}
+
+	var rules []models.RedirectRule
+	if err := json.NewDecoder(c.Request.Body).Decode(&rules); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "you should provide correct data"})
+		return
+	}
+	defer c.Request.Body.Close()
+
+	if err := h.service.SetRedirectRules(c.Request.Context(), c.Param("id"), userID, rules); err != nil {
+		h.respondRulesError(c, err)
+		return
+	}
+	if rules == nil {
+		rules = []models.RedirectRule{}
+	}
+	c.JSON(http.StatusOK, rules)
+}
+
+func (h *Handlers) respondRulesError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "url not found"})
+	case errors.Is(err, services.ErrForbidden):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrInvalidRedirect):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		h.logger.Error("error handling redirect rules request", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "something went wrong"})
+	}
+}
@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/maxzhirnov/urlshort/internal/auth"
+	"github.com/maxzhirnov/urlshort/internal/models"
+	"github.com/maxzhirnov/urlshort/internal/services"
+)
+
+type SessionDTO struct {
+	ID        string    `json:"id"`
+	UserAgent string    `json:"user_agent"`
+	IssuedAt  time.Time `json:"issued_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Current   bool      `json:"current"`
+}
+
+// HandleLogout revokes token of the request and clears the cookie
+func (h *Handlers) HandleLogout(c *gin.Context) {
+	token, err := h.currentToken(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "not authorized"})
+		return
+	}
+
+	// Токены, выданные до появления jti, отозвать нельзя, остается только удалить куку
+	if token.ID != "" {
+		err := h.service.RevokeToken(c.Request.Context(), models.Session{
+			ID:        token.ID,
+			UUID:      token.UserID,
+			UserAgent: c.Request.UserAgent(),
+			IssuedAt:  token.IssuedAt,
+			ExpiresAt: token.ExpiresAt,
+		})
+		if err != nil {
+			h.logger.Error("error revoking token", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "something went wrong"})
+			return
+		}
+		h.auth.Revoke(token.ID, token.ExpiresAt)
+	}
+
+	cookie := h.auth.NewCookie("")
+	cookie.MaxAge = -1
+	http.SetCookie(c.Writer, cookie)
+	c.Status(http.StatusNoContent)
+}
+
+func (h *Handlers) HandleListSessions(c *gin.Context) {
+	token, err := h.currentToken(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "not authorized"})
+		return
+	}
+
+	sessions, err := h.service.GetActiveSessions(c.Request.Context(), token.UserID)
+	if err != nil {
+		h.logger.Error("error loading sessions", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "something went wrong"})
+		return
+	}
+
+	res := make([]SessionDTO, len(sessions))
+	for i, s := range sessions {
+		res[i] = SessionDTO{
+			ID:        s.ID,
+			UserAgent: s.UserAgent,
+			IssuedAt:  s.IssuedAt,
+			ExpiresAt: s.ExpiresAt,
+			Current:   s.ID == token.ID,
+		}
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+func (h *Handlers) HandleRevokeSession(c *gin.Context) {
+	userID, err := h.resolveUserID(c)
+	if err != nil {
+		h.respondAuthError(c, err)
+		return
+	}
+
+	session, err := h.service.RevokeSession(c.Request.Context(), c.Param("id"), userID)
+	if errors.Is(err, services.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "session not found"})
+		return
+	}
+	if err != nil {
+		h.logger.Error("error revoking session", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "something went wrong"})
+		return
+	}
+
+	h.auth.Revoke(session.ID, session.ExpiresAt)
+	c.Status(http.StatusNoContent)
+}
+
+// currentToken returns claims of the token the request is authenticated with
+func (h *Handlers) currentToken(c *gin.Context) (auth.IssuedToken, error) {
+	if tempToken, exists := c.Get("jwt_token"); exists {
+		return h.auth.ParseToken(tempToken.(string))
+	}
+	jwtToken, ok := h.auth.TokenFromRequest(c.Request)
+	if !ok {
+		return auth.IssuedToken{}, http.ErrNoCookie
+	}
+	return h.auth.ParseToken(jwtToken)
+}
@@ -0,0 +1,132 @@
+// Package health runs readiness checks of application components
+// and keeps track of the draining state during shutdown
+package health
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	StatusOK       = "ok"
+	StatusFail     = "fail"
+	StatusDraining = "draining"
+)
+
+// defaultTimeout bounds a single check, a hung dependency is reported as failure
+const defaultTimeout = 2 * time.Second
+
+var errTimeout = errors.New("check timed out")
+
+// Check returns nil when the component is able to serve requests
+type Check func(ctx context.Context) error
+
+type ComponentStatus struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type Report struct {
+	Status     string                     `json:"status"`
+	Components map[string]ComponentStatus `json:"components,omitempty"`
+}
+
+func (r Report) Ready() bool {
+	return r.Status == StatusOK
+}
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+type Checker struct {
+	timeout  time.Duration
+	mu       sync.RWMutex
+	checks   []namedCheck
+	draining atomic.Bool
+}
+
+func NewChecker() *Checker {
+	return &Checker{timeout: defaultTimeout}
+}
+
+// WithTimeout changes time given to each check
+func (c *Checker) WithTimeout(timeout time.Duration) *Checker {
+	c.timeout = timeout
+	return c
+}
+
+// Add registers check of the component, name is used as a key in the report
+func (c *Checker) Add(name string, check Check) *Checker {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.checks = append(c.checks, namedCheck{name: name, check: check})
+	return c
+}
+
+// SetDraining makes every following readiness check fail, it's called when shutdown starts
+func (c *Checker) SetDraining() {
+	c.draining.Store(true)
+}
+
+func (c *Checker) Draining() bool {
+	return c.draining.Load()
+}
+
+// Check runs all checks concurrently. Checks aren't run while draining,
+// dependencies may be already closing at that moment
+func (c *Checker) Check(ctx context.Context) Report {
+	if c.Draining() {
+		return Report{Status: StatusDraining}
+	}
+
+	c.mu.RLock()
+	checks := c.checks
+	c.mu.RUnlock()
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	statuses := make([]ComponentStatus, len(checks))
+	var wg sync.WaitGroup
+	for i, nc := range checks {
+		wg.Add(1)
+		go func(i int, check Check) {
+			defer wg.Done()
+			statuses[i] = run(ctx, check)
+		}(i, nc.check)
+	}
+	wg.Wait()
+
+	report := Report{Status: StatusOK, Components: make(map[string]ComponentStatus, len(checks))}
+	for i, nc := range checks {
+		report.Components[nc.name] = statuses[i]
+		if statuses[i].Status != StatusOK {
+			report.Status = StatusFail
+		}
+	}
+	return report
+}
+
+// run doesn't wait for the check after ctx is done, e.g. Ping of storage doesn't accept context
+func run(ctx context.Context, check Check) ComponentStatus {
+	done := make(chan error, 1)
+	go func() {
+		done <- check(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = errTimeout
+	}
+	if err != nil {
+		return ComponentStatus{Status: StatusFail, Error: err.Error()}
+	}
+	return ComponentStatus{Status: StatusOK}
+}
@@ -0,0 +1,81 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChecker_Check(t *testing.T) {
+	ok := func(context.Context) error { return nil }
+	failing := func(context.Context) error { return errors.New("connection refused") }
+	hanging := func(context.Context) error {
+		time.Sleep(time.Second)
+		return nil
+	}
+
+	tests := []struct {
+		name   string
+		checks map[string]Check
+		want   Report
+	}{
+		{
+			name: "no checks",
+			want: Report{Status: StatusOK, Components: map[string]ComponentStatus{}},
+		},
+		{
+			name:   "all ok",
+			checks: map[string]Check{"storage": ok, "deletion_worker": ok},
+			want: Report{Status: StatusOK, Components: map[string]ComponentStatus{
+				"storage":         {Status: StatusOK},
+				"deletion_worker": {Status: StatusOK},
+			}},
+		},
+		{
+			name:   "one failing",
+			checks: map[string]Check{"storage": failing, "deletion_worker": ok},
+			want: Report{Status: StatusFail, Components: map[string]ComponentStatus{
+				"storage":         {Status: StatusFail, Error: "connection refused"},
+				"deletion_worker": {Status: StatusOK},
+			}},
+		},
+		{
+			name:   "timeout",
+			checks: map[string]Check{"storage": hanging},
+			want: Report{Status: StatusFail, Components: map[string]ComponentStatus{
+				"storage": {Status: StatusFail, Error: errTimeout.Error()},
+			}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewChecker().WithTimeout(50 * time.Millisecond)
+			for name, check := range tt.checks {
+				c.Add(name, check)
+			}
+			got := c.Check(context.Background())
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.want.Status == StatusOK, got.Ready())
+		})
+	}
+}
+
+func TestChecker_Draining(t *testing.T) {
+	called := false
+	c := NewChecker().Add("storage", func(context.Context) error {
+		called = true
+		return nil
+	})
+
+	assert.True(t, c.Check(context.Background()).Ready())
+	called = false
+
+	c.SetDraining()
+	got := c.Check(context.Background())
+	assert.Equal(t, Report{Status: StatusDraining}, got)
+	assert.False(t, got.Ready())
+	assert.False(t, called)
+}
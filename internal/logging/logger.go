@@ -0,0 +1,106 @@
+package logging
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Backends
+const (
+	BackendLogrus = "logrus"
+	BackendZap    = "zap"
+)
+
+// timeKey is the same in JSON output of both backends
+const timeKey = "time"
+
+// Output formats
+const (
+	FormatJSON    = "json"
+	FormatConsole = "console"
+)
+
+type Config struct {
+	// Backend is logrus or zap
+	Backend string
+	// Level is one of debug, info, warn or error
+	Level string
+	// Format is json or console, console output is meant for humans only
+	Format   string
+	Sampling Sampling
+}
+
+// Access log messages are never sampled, every served request is written
+const (
+	MsgRequestServed  = "request served"
+	MsgGRPCCallServed = "grpc call served"
+)
+
+// Sampling limits debug and info entries with the same message written per second:
+// first Initial entries are written, then every Thereafter-th one.
+// Zero Initial disables sampling, zero Thereafter drops everything after Initial
+type Sampling struct {
+	Initial    int
+	Thereafter int
+}
+
+// Logger is implemented by both backends. Arguments after message are key-value pairs
+// written as separate fields
+type Logger interface {
+	Debug(string, ...interface{})
+	Info(string, ...interface{})
+	Warn(string, ...interface{})
+	Error(string, ...interface{})
+	Fatal(string, ...interface{})
+	// SetLevel changes level of the logger, it's safe to call while other goroutines are logging
+	SetLevel(string) error
+	// Sync flushes buffered entries
+	Sync() error
+}
+
+// ValidBackend checks backend name
+func ValidBackend(backend string) bool {
+	return backend == BackendLogrus || backend == BackendZap
+}
+
+// ValidFormat checks output format
+func ValidFormat(format string) bool {
+	return format == FormatJSON || format == FormatConsole
+}
+
+// New creates logger writing to stderr
+func New(cfg Config) (Logger, error) {
+	return newLogger(cfg, os.Stderr)
+}
+
+func newLogger(cfg Config, w io.Writer) (Logger, error) {
+	if cfg.Format == "" {
+		cfg.Format = FormatJSON
+	}
+	if cfg.Level == "" {
+		cfg.Level = "info"
+	}
+	if !ValidFormat(cfg.Format) {
+		return nil, fmt.Errorf("unknown log format %q", cfg.Format)
+	}
+
+	switch cfg.Backend {
+	case "", BackendLogrus:
+		return newLogrus(cfg, w)
+	case BackendZap:
+		return newZap(cfg, w)
+	default:
+		return nil, fmt.Errorf("unknown log backend %q", cfg.Backend)
+	}
+}
+
+// normalizeLevel accepts warning as logrus does
+func normalizeLevel(level string) string {
+	level = strings.ToLower(level)
+	if level == "warning" {
+		return "warn"
+	}
+	return level
+}
@@ -0,0 +1,114 @@
+package logging
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func entries(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	t.Helper()
+	var result []map[string]interface{}
+	scanner := bufio.NewScanner(buf)
+	for scanner.Scan() {
+		entry := make(map[string]interface{})
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &entry), scanner.Text())
+		result = append(result, entry)
+	}
+	return result
+}
+
+func TestNew_StructuredFields(t *testing.T) {
+	for _, backend := range []string{BackendLogrus, BackendZap} {
+		t.Run(backend, func(t *testing.T) {
+			var buf bytes.Buffer
+			logger, err := newLogger(Config{Backend: backend, Level: "info"}, &buf)
+			require.NoError(t, err)
+
+			logger.Debug("hidden")
+			logger.Info("request served", "status", 201, "path", "/api/shorten", "error", errors.New("boom"),
+				"latency", 1500*time.Millisecond)
+			require.NoError(t, logger.SetLevel("debug"))
+			logger.Debug("visible")
+			require.NoError(t, logger.Sync())
+
+			got := entries(t, &buf)
+			require.Len(t, got, 2)
+			assert.Equal(t, "request served", got[0]["msg"])
+			assert.Equal(t, "info", got[0]["level"])
+			assert.EqualValues(t, 201, got[0]["status"])
+			assert.Equal(t, "/api/shorten", got[0]["path"])
+			assert.Equal(t, "boom", got[0]["error"])
+			assert.Equal(t, "1.5s", got[0]["latency"])
+			assert.NotEmpty(t, got[0]["time"])
+			assert.Regexp(t, `^logging/logger_test\.go:\d+$`, got[0]["caller"])
+			assert.Equal(t, "visible", got[1]["msg"])
+		})
+	}
+}
+
+func TestNew_Sampling(t *testing.T) {
+	for _, backend := range []string{BackendLogrus, BackendZap} {
+		t.Run(backend, func(t *testing.T) {
+			var buf bytes.Buffer
+			logger, err := newLogger(Config{
+				Backend:  backend,
+				Level:    "debug",
+				Sampling: Sampling{Initial: 2, Thereafter: 3},
+			}, &buf)
+			require.NoError(t, err)
+
+			for i := 0; i < 10; i++ {
+				logger.Debug("redirect", "n", i)
+				logger.Warn("rate limit exceeded")
+				logger.Info(MsgRequestServed)
+			}
+			logger.Info("other")
+
+			counts := make(map[string]int)
+			for _, e := range entries(t, &buf) {
+				counts[e["msg"].(string)]++
+			}
+			// Первые 2, затем 5-я и 8-я записи
+			assert.Equal(t, 4, counts["redirect"])
+			// Предупреждения и ошибки не сэмплируются
+			assert.Equal(t, 10, counts["rate limit exceeded"])
+			assert.Equal(t, 1, counts["other"])
+			// Access log пишется целиком
+			assert.Equal(t, 10, counts[MsgRequestServed])
+		})
+	}
+}
+
+func TestNew_Validation(t *testing.T) {
+	_, err := newLogger(Config{Backend: "log15"}, &bytes.Buffer{})
+	assert.Error(t, err)
+	_, err = newLogger(Config{Format: "xml"}, &bytes.Buffer{})
+	assert.Error(t, err)
+	_, err = newLogger(Config{Backend: BackendZap, Level: "verbose"}, &bytes.Buffer{})
+	assert.Error(t, err)
+
+	logger, err := newLogger(Config{Backend: BackendZap, Level: "warning"}, &bytes.Buffer{})
+	require.NoError(t, err)
+	assert.NoError(t, logger.SetLevel("WARNING"))
+}
+
+func TestSampler_Window(t *testing.T) {
+	now := time.Now()
+	s := newSampler(Sampling{Initial: 1})
+	s.now = func() time.Time { return now }
+
+	assert.True(t, s.allow("a"))
+	assert.False(t, s.allow("a"))
+	assert.True(t, s.allow("b"))
+
+	now = now.Add(time.Second)
+	assert.True(t, s.allow("a"))
+	assert.Nil(t, newSampler(Sampling{}))
+}
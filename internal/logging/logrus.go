@@ -2,90 +2,127 @@ package logging
 
 import (
 	"fmt"
+	"io"
 	"runtime"
+	"strings"
 
 	"github.com/sirupsen/logrus"
 )
 
-type CustomFormatter struct {
-	logrus.TextFormatter
-}
-
-func (f *CustomFormatter) Format(entry *logrus.Entry) ([]byte, error) {
-	bytes, err := f.TextFormatter.Format(entry)
-	if err != nil {
-		return nil, err
-	}
-
-	for i := 9; i > 0; i-- {
-		_, file, line, ok := runtime.Caller(i)
-		if ok {
-			callerInfo := fmt.Sprintf("%s:%d ", file, line)
-			bytes = append([]byte(callerInfo), bytes...)
-			break
-		}
-	}
-
-	return bytes, nil
-}
-
+// LogrusLogger writes key-value pairs as logrus fields, file and line of the call
+// are written in caller field
 type LogrusLogger struct {
-	logger *logrus.Logger
+	logger  *logrus.Logger
+	sampler *sampler
 }
 
+// NewLogrusLogger creates logger with console output and without sampling
 func NewLogrusLogger(level logrus.Level) *LogrusLogger {
 	logger := logrus.New()
 	logger.Level = level
-	logger.Formatter = &CustomFormatter{
-		TextFormatter: logrus.TextFormatter{
-			FullTimestamp: true,
-			ForceColors:   true,
-		},
-	}
+	logger.Formatter = &logrus.TextFormatter{FullTimestamp: true}
 
 	return &LogrusLogger{
 		logger: logger,
 	}
 }
 
-func (l LogrusLogger) Debug(msg string, args ...interface{}) {
-	if len(args) == 0 {
-		l.logger.Debug(msg)
-	} else {
-		l.logger.Debug(msg, args)
+func newLogrus(cfg Config, w io.Writer) (*LogrusLogger, error) {
+	l := NewLogrusLogger(logrus.InfoLevel)
+	l.logger.Out = w
+	l.sampler = newSampler(cfg.Sampling)
+	if cfg.Format == FormatJSON {
+		l.logger.Formatter = &logrus.JSONFormatter{
+			FieldMap: logrus.FieldMap{logrus.FieldKeyTime: timeKey},
+		}
 	}
-
+	if err := l.SetLevel(cfg.Level); err != nil {
+		return nil, err
+	}
+	return l, nil
 }
 
-func (l LogrusLogger) Info(msg string, args ...interface{}) {
-	if len(args) == 0 {
-		l.logger.Info(msg)
-	} else {
-		l.logger.Info(msg, args)
+// SetLevel changes level of the logger, it's safe to call while other goroutines are logging
+func (l LogrusLogger) SetLevel(level string) error {
+	parsed, err := logrus.ParseLevel(level)
+	if err != nil {
+		return err
 	}
+	l.logger.SetLevel(parsed)
+	return nil
+}
 
+// Sync does nothing, logrus doesn't buffer entries
+func (l LogrusLogger) Sync() error {
+	return nil
+}
+
+func (l LogrusLogger) Debug(msg string, args ...interface{}) {
+	l.log(logrus.DebugLevel, msg, args)
+}
+
+func (l LogrusLogger) Info(msg string, args ...interface{}) {
+	l.log(logrus.InfoLevel, msg, args)
 }
 
 func (l LogrusLogger) Warn(msg string, args ...interface{}) {
-	if len(args) == 0 {
-		l.logger.Warn(msg)
-	} else {
-		l.logger.Warn(msg, args)
-	}
+	l.log(logrus.WarnLevel, msg, args)
 }
 
 func (l LogrusLogger) Error(msg string, args ...interface{}) {
-	if len(args) == 0 {
-		l.logger.Error(msg)
-	} else {
-		l.logger.Error(msg, args)
-	}
+	l.log(logrus.ErrorLevel, msg, args)
 }
 
 func (l LogrusLogger) Fatal(msg string, args ...interface{}) {
-	if len(args) == 0 {
-		l.logger.Fatal(msg)
-	} else {
-		l.logger.Fatal(msg, args)
+	l.log(logrus.FatalLevel, msg, args)
+	l.logger.Exit(1)
+}
+
+func (l LogrusLogger) log(level logrus.Level, msg string, args []interface{}) {
+	if !l.logger.IsLevelEnabled(level) {
+		return
+	}
+	if level >= logrus.InfoLevel && !l.sampler.allow(msg) {
+		return
+	}
+
+	entry := l.logger.WithFields(fields(args))
+	// 2 пропускает log и метод уровня, чтобы в caller попал вызывающий код
+	if _, file, line, ok := runtime.Caller(2); ok {
+		entry = entry.WithField("caller", fmt.Sprintf("%s:%d", trimPath(file), line))
+	}
+	entry.Log(level, msg)
+}
+
+// fields converts key-value pairs, value without key is written under !BADKEY as zap does.
+// Stringers are written as strings, so durations and models look the same in both backends
+func fields(args []interface{}) logrus.Fields {
+	f := make(logrus.Fields, len(args)/2+1)
+	for i := 0; i < len(args); i += 2 {
+		if i+1 == len(args) {
+			f["!BADKEY"] = args[i]
+			break
+		}
+		key, ok := args[i].(string)
+		if !ok {
+			key = fmt.Sprint(args[i])
+		}
+		f[key] = args[i+1]
+		if s, ok := args[i+1].(fmt.Stringer); ok {
+			f[key] = s.String()
+		}
+	}
+	return f
+}
+
+// trimPath keeps package directory and file name as zap does
+func trimPath(file string) string {
+	i := strings.LastIndexByte(file, '/')
+	if i == -1 {
+		return file
+	}
+	if j := strings.LastIndexByte(file[:i], '/'); j != -1 {
+		return file[j+1:]
 	}
+	return file
 }
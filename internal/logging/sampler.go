@@ -0,0 +1,53 @@
+package logging
+
+import (
+	"sync"
+	"time"
+)
+
+// sampler counts entries by message within one second windows
+type sampler struct {
+	initial    uint64
+	thereafter uint64
+	now        func() time.Time
+
+	mu     sync.Mutex
+	window time.Time
+	counts map[string]uint64
+}
+
+// newSampler returns nil when sampling is disabled, nil sampler allows everything
+func newSampler(s Sampling) *sampler {
+	if s.Initial <= 0 {
+		return nil
+	}
+	sm := &sampler{
+		initial: uint64(s.Initial),
+		now:     time.Now,
+		counts:  make(map[string]uint64),
+	}
+	if s.Thereafter > 0 {
+		sm.thereafter = uint64(s.Thereafter)
+	}
+	return sm
+}
+
+func (s *sampler) allow(msg string) bool {
+	if s == nil || msg == MsgRequestServed || msg == MsgGRPCCallServed {
+		return true
+	}
+	now := s.now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.window) >= time.Second {
+		s.window = now
+		s.counts = make(map[string]uint64, len(s.counts))
+	}
+	s.counts[msg]++
+	n := s.counts[msg]
+	if n <= s.initial {
+		return true
+	}
+	return s.thereafter > 0 && (n-s.initial)%s.thereafter == 0
+}
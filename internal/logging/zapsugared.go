@@ -1,39 +1,87 @@
 package logging
 
-import "go.uber.org/zap"
+import (
+	"io"
+	"os"
 
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// ZapSugaredAdapter writes key-value pairs as zap fields
 type ZapSugaredAdapter struct {
 	*zap.SugaredLogger
+	level   zap.AtomicLevel
+	sampler *sampler
 }
 
+// NewZapSugared creates logger with JSON output at info level and without sampling
 func NewZapSugared() (*ZapSugaredAdapter, error) {
-	logger, err := zap.NewProduction(zap.AddCallerSkip(1))
+	return newZap(Config{
+		Backend: BackendZap,
+		Level:   "info",
+		Format:  FormatJSON,
+	}, os.Stderr)
+}
 
-	if err != nil {
+func newZap(cfg Config, w io.Writer) (*ZapSugaredAdapter, error) {
+	encoderConfig := zap.NewProductionEncoderConfig()
+	encoderConfig.TimeKey = timeKey
+	encoderConfig.EncodeTime = zapcore.RFC3339TimeEncoder
+	encoderConfig.EncodeDuration = zapcore.StringDurationEncoder
+	encoder := zapcore.NewJSONEncoder(encoderConfig)
+	if cfg.Format == FormatConsole {
+		encoderConfig = zap.NewDevelopmentEncoderConfig()
+		encoder = zapcore.NewConsoleEncoder(encoderConfig)
+	}
+
+	z := &ZapSugaredAdapter{
+		level:   zap.NewAtomicLevel(),
+		sampler: newSampler(cfg.Sampling),
+	}
+	if err := z.SetLevel(cfg.Level); err != nil {
 		return nil, err
 	}
-	sugared := logger.Sugar()
-	return &ZapSugaredAdapter{
-		sugared,
-	}, nil
+	core := zapcore.NewCore(encoder, zapcore.Lock(zapcore.AddSync(w)), z.level)
+	z.SugaredLogger = zap.New(core, zap.AddCaller(), zap.AddCallerSkip(1)).Sugar()
+	return z, nil
+}
+
+// SetLevel changes level of the logger, it's safe to call while other goroutines are logging
+func (z *ZapSugaredAdapter) SetLevel(level string) error {
+	parsed, err := zapcore.ParseLevel(normalizeLevel(level))
+	if err != nil {
+		return err
+	}
+	z.level.SetLevel(parsed)
+	return nil
 }
 
 func (z *ZapSugaredAdapter) Debug(msg string, keysAndValues ...interface{}) {
-	z.SugaredLogger.Debugln(msg, keysAndValues)
+	if z.sampled(zap.DebugLevel, msg) {
+		z.SugaredLogger.Debugw(msg, keysAndValues...)
+	}
 }
 
 func (z *ZapSugaredAdapter) Info(msg string, keysAndValues ...interface{}) {
-	z.SugaredLogger.Infoln(msg, keysAndValues)
+	if z.sampled(zap.InfoLevel, msg) {
+		z.SugaredLogger.Infow(msg, keysAndValues...)
+	}
 }
 
 func (z *ZapSugaredAdapter) Error(msg string, keysAndValues ...interface{}) {
-	z.SugaredLogger.Errorln(msg, keysAndValues)
+	z.SugaredLogger.Errorw(msg, keysAndValues...)
 }
 
 func (z *ZapSugaredAdapter) Fatal(msg string, keysAndValues ...interface{}) {
-	z.SugaredLogger.Fatalln(msg, keysAndValues)
+	z.SugaredLogger.Fatalw(msg, keysAndValues...)
 }
 
 func (z *ZapSugaredAdapter) Warn(msg string, keysAndValues ...interface{}) {
-	z.SugaredLogger.Warnln(msg, keysAndValues)
+	z.SugaredLogger.Warnw(msg, keysAndValues...)
+}
+
+// sampled reports whether enabled entry passes sampling, disabled entries aren't counted
+func (z *ZapSugaredAdapter) sampled(level zapcore.Level, msg string) bool {
+	return z.level.Enabled(level) && z.sampler.allow(msg)
 }
@@ -0,0 +1,128 @@
+// Package metrics collects application metrics and exposes them in Prometheus text format
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "urlshortener"
+
+// Redirect results
+const (
+	RedirectOK       = "ok"
+	RedirectNotFound = "not_found"
+	RedirectGone     = "gone"
+	RedirectDisabled = "disabled"
+)
+
+type Metrics struct {
+	registry *prometheus.Registry
+
+	httpRequests    *prometheus.CounterVec
+	httpDuration    *prometheus.HistogramVec
+	linksCreated    prometheus.Counter
+	linkConflicts   prometheus.Counter
+	redirects       *prometheus.CounterVec
+	storageDuration *prometheus.HistogramVec
+}
+
+// New creates metrics in a separate registry together with go runtime and process collectors
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		httpRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_requests_total",
+			Help:      "Number of served HTTP requests.",
+		}, []string{"method", "route", "status"}),
+		httpDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Latency of HTTP requests.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route", "status"}),
+		linksCreated: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "links_created_total",
+			Help:      "Number of created short links.",
+		}),
+		linkConflicts: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "link_conflicts_total",
+			Help:      "Number of attempts to shorten already shortened url.",
+		}),
+		redirects: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "redirects_total",
+			Help:      "Number of redirect requests by result: ok, not_found, gone, disabled.",
+		}, []string{"result"}),
+		storageDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "storage_operation_duration_seconds",
+			Help:      "Latency of storage operations.",
+			Buckets:   []float64{.0001, .0005, .001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+		}, []string{"backend", "operation"}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.httpRequests,
+		m.httpDuration,
+		m.linksCreated,
+		m.linkConflicts,
+		m.redirects,
+		m.storageDuration,
+	)
+
+	// Результаты редиректов создаются заранее, чтобы нулевые значения были видны сразу
+	for _, result := range []string{RedirectOK, RedirectNotFound, RedirectGone, RedirectDisabled} {
+		m.redirects.WithLabelValues(result)
+	}
+
+	return m
+}
+
+// Handler serves metrics in Prometheus text format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// RegisterDeletionQueue exposes number of link deletions waiting to be written to storage
+func (m *Metrics) RegisterDeletionQueue(depth func() int) {
+	m.registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "deletion_queue_depth",
+		Help:      "Number of link deletions waiting to be written to storage.",
+	}, func() float64 {
+		return float64(depth())
+	}))
+}
+
+func (m *Metrics) ObserveHTTP(method, route string, status int, d time.Duration) {
+	code := strconv.Itoa(status)
+	m.httpRequests.WithLabelValues(method, route, code).Inc()
+	m.httpDuration.WithLabelValues(method, route, code).Observe(d.Seconds())
+}
+
+func (m *Metrics) LinksCreated(n int) {
+	m.linksCreated.Add(float64(n))
+}
+
+func (m *Metrics) LinkConflict() {
+	m.linkConflicts.Inc()
+}
+
+func (m *Metrics) Redirect(result string) {
+	m.redirects.WithLabelValues(result).Inc()
+}
+
+func (m *Metrics) ObserveStorage(backend, operation string, d time.Duration) {
+	m.storageDuration.WithLabelValues(backend, operation).Observe(d.Seconds())
+}
@@ -0,0 +1,48 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics_Handler(t *testing.T) {
+	m := New()
+	depth := 3
+	m.RegisterDeletionQueue(func() int { return depth })
+
+	m.ObserveHTTP(http.MethodGet, "/:ID", http.StatusTemporaryRedirect, 10*time.Millisecond)
+	m.LinksCreated(2)
+	m.LinkConflict()
+	m.Redirect(RedirectGone)
+	m.ObserveStorage("memory", "get_url_by_id", time.Millisecond)
+
+	w := httptest.NewRecorder()
+	m.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	body := w.Body.String()
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "http requests", want: `urlshortener_http_requests_total{method="GET",route="/:ID",status="307"} 1`},
+		{name: "http latency", want: `urlshortener_http_request_duration_seconds_count{method="GET",route="/:ID",status="307"} 1`},
+		{name: "links created", want: `urlshortener_links_created_total 2`},
+		{name: "conflicts", want: `urlshortener_link_conflicts_total 1`},
+		{name: "gone redirects", want: `urlshortener_redirects_total{result="gone"} 1`},
+		{name: "unused redirect result is exported", want: `urlshortener_redirects_total{result="not_found"} 0`},
+		{name: "deletion queue", want: `urlshortener_deletion_queue_depth 3`},
+		{name: "storage latency", want: `urlshortener_storage_operation_duration_seconds_count{backend="memory",operation="get_url_by_id"} 1`},
+		{name: "runtime metrics", want: `go_goroutines`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Contains(t, body, tt.want)
+		})
+	}
+}
@@ -1,13 +1,21 @@
 package middleware
 
 import (
+	"bufio"
 	"bytes"
 	"compress/gzip"
+	"compress/zlib"
+	"errors"
 	"io"
+	"mime"
+	"net"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/gin-gonic/gin"
+	"github.com/klauspost/compress/zstd"
 )
 
 type logger interface {
@@ -18,51 +26,370 @@ type logger interface {
 	Debug(string, ...interface{})
 }
 
-type gzipResponseWriter struct {
-	writer *gzip.Writer
-	gin.ResponseWriter
+const (
+	encodingGzip    = "gzip"
+	encodingDeflate = "deflate"
+	encodingZstd    = "zstd"
+)
+
+// supportedEncodings in order of preference when client accepts several with equal q-value
+var supportedEncodings = []string{encodingZstd, encodingGzip, encodingDeflate}
+
+// compressibleTypes are media types of responses worth compressing, every text/* type is compressed too
+var compressibleTypes = map[string]struct{}{
+	gin.MIMEJSON:               {},
+	"application/javascript":   {},
+	gin.MIMEXML:                {},
+	"application/problem+json": {},
+	"image/svg+xml":            {},
 }
 
-func (g *gzipResponseWriter) Write(data []byte) (int, error) {
-	g.writer.Reset(g.ResponseWriter)
-	defer g.writer.Close()
-	return g.writer.Write(data)
+// GzipOptions configures compression of responses and decompression of request bodies
+type GzipOptions struct {
+	// Level is compression level of gzip and deflate, see compress/flate constants
+	Level int
+	// MinSize is the smallest response body which is compressed, smaller ones are sent as is
+	MinSize int
+	// MaxDecompressedSize limits size of decompressed request body, zero means no limit
+	MaxDecompressedSize int64
 }
 
-func GzipMiddleware(gzipWriter *gzip.Writer, logger logger) gin.HandlerFunc {
+// encoder is implemented by gzip, zlib and zstd writers
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(io.Writer)
+}
+
+type encoderPools map[string]*sync.Pool
+
+func newEncoderPools(level int) encoderPools {
+	return encoderPools{
+		encodingGzip: {New: func() any {
+			w, _ := gzip.NewWriterLevel(io.Discard, level)
+			return w
+		}},
+		encodingDeflate: {New: func() any {
+			w, _ := zlib.NewWriterLevel(io.Discard, level)
+			return w
+		}},
+		encodingZstd: {New: func() any {
+			w, _ := zstd.NewWriter(io.Discard, zstd.WithEncoderLevel(zstd.SpeedFastest), zstd.WithEncoderConcurrency(1))
+			return w
+		}},
+	}
+}
+
+// GzipMiddleware decompresses request bodies encoded with gzip, deflate or zstd and compresses
+// responses with the best encoding accepted by the client. Responses are streamed: the decision
+// to compress is taken once MinSize bytes are written, the handler finishes or calls Flush
+func GzipMiddleware(opts GzipOptions, logger logger) gin.HandlerFunc {
+	// Level проверяется сразу, чтобы не получить nil writer из пула во время запроса
+	if _, err := gzip.NewWriterLevel(io.Discard, opts.Level); err != nil {
+		logger.Warn("invalid compression level, using default", "level", opts.Level)
+		opts.Level = gzip.DefaultCompression
+	}
+	pools := newEncoderPools(opts.Level)
+
 	return func(c *gin.Context) {
 		// Decoding
-		if c.GetHeader("Content-Encoding") == "gzip" {
-			gr, err := gzip.NewReader(c.Request.Body)
+		if encoding := strings.ToLower(strings.TrimSpace(c.GetHeader("Content-Encoding"))); encoding != "" && encoding != "identity" {
+			status, err := decompressBody(c.Request, encoding, opts.MaxDecompressedSize)
 			if err != nil {
-				logger.Error(err.Error())
-				c.AbortWithStatus(http.StatusBadRequest)
+				logger.Warn("couldn't decompress request body", "encoding", encoding, "error", err)
+				c.AbortWithStatusJSON(status, gin.H{"error": err.Error()})
 				return
 			}
-			defer gr.Close()
+		}
 
-			bodyBytes, err := io.ReadAll(gr)
-			if err != nil {
-				logger.Error(err.Error())
-				c.AbortWithStatus(http.StatusBadRequest)
-				return
+		// Encoding
+		// Ответ зависит от Accept-Encoding, даже если клиент не принимает сжатие
+		c.Writer.Header().Add("Vary", "Accept-Encoding")
+		encoding := negotiateEncoding(c.GetHeader("Accept-Encoding"))
+		if encoding == "" || c.Request.Method == http.MethodHead {
+			c.Next()
+			return
+		}
+
+		original := c.Writer
+		cw := &compressWriter{
+			ResponseWriter: original,
+			encoding:       encoding,
+			pool:           pools[encoding],
+			minSize:        opts.MinSize,
+			status:         http.StatusOK,
+		}
+		c.Writer = cw
+		defer func() {
+			if err := cw.finish(); err != nil {
+				logger.Error("error finishing compressed response", "error", err)
 			}
-			c.Request.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
+			c.Writer = original
+		}()
+		c.Next()
+	}
+}
+
+var (
+	errBodyTooLarge        = errors.New("decompressed request body is too large")
+	errUnsupportedEncoding = errors.New("unsupported content encoding")
+)
+
+// decompressBody replaces request body with decompressed one and returns status to respond with on error
+func decompressBody(r *http.Request, encoding string, limit int64) (int, error) {
+	var (
+		decoded io.Reader
+		closer  func()
+	)
+	switch encoding {
+	case encodingGzip, "x-gzip":
+		gr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			return http.StatusBadRequest, err
 		}
+		decoded, closer = gr, func() { gr.Close() }
+	case encodingDeflate:
+		zr, err := zlib.NewReader(r.Body)
+		if err != nil {
+			return http.StatusBadRequest, err
+		}
+		decoded, closer = zr, func() { zr.Close() }
+	case encodingZstd:
+		opts := []zstd.DOption{zstd.WithDecoderConcurrency(1)}
+		if limit > 0 {
+			opts = append(opts, zstd.WithDecoderMaxMemory(uint64(limit)))
+		}
+		zr, err := zstd.NewReader(r.Body, opts...)
+		if err != nil {
+			return http.StatusBadRequest, err
+		}
+		decoded, closer = zr, zr.Close
+	default:
+		return http.StatusUnsupportedMediaType, errUnsupportedEncoding
+	}
+	defer closer()
+	defer r.Body.Close()
 
-		// Encoding
-		contentType := c.GetHeader("Content-Type")
-		acceptEncoding := c.GetHeader("Accept-Encoding")
-		if strings.Contains(acceptEncoding, "gzip") {
-			if strings.Contains(contentType, gin.MIMEJSON) || strings.Contains(contentType, gin.MIMEHTML) {
-				logger.Info("gziping data")
-				c.Writer = &gzipResponseWriter{
-					writer:         gzipWriter,
-					ResponseWriter: c.Writer,
+	// Читаем на байт больше лимита, чтобы отличить тело ровно по лимиту от слишком большого
+	if limit > 0 {
+		decoded = io.LimitReader(decoded, limit+1)
+	}
+	body, err := io.ReadAll(decoded)
+	if errors.Is(err, zstd.ErrDecoderSizeExceeded) || errors.Is(err, zstd.ErrWindowSizeExceeded) {
+		return http.StatusRequestEntityTooLarge, errBodyTooLarge
+	}
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	if limit > 0 && int64(len(body)) > limit {
+		return http.StatusRequestEntityTooLarge, errBodyTooLarge
+	}
+
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.ContentLength = int64(len(body))
+	r.Header.Del("Content-Encoding")
+	r.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	return 0, nil
+}
+
+// negotiateEncoding picks supported encoding with the highest q-value from Accept-Encoding,
+// returns empty string if response should be sent as is
+func negotiateEncoding(acceptEncoding string) string {
+	if acceptEncoding == "" {
+		return ""
+	}
+
+	qualities := make(map[string]float64)
+	wildcard := -1.0
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding == "" {
+			continue
+		}
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			name, value, ok := strings.Cut(strings.TrimSpace(param), "=")
+			if ok && strings.EqualFold(strings.TrimSpace(name), "q") {
+				parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+				if err != nil || parsed < 0 || parsed > 1 {
+					parsed = 0
 				}
-				c.Writer.Header().Set("Content-Encoding", "gzip")
+				q = parsed
 			}
 		}
-		c.Next()
+		if coding == "x-gzip" {
+			coding = encodingGzip
+		}
+		if coding == "*" {
+			wildcard = q
+			continue
+		}
+		qualities[coding] = q
+	}
+
+	best, bestQ := "", 0.0
+	for _, encoding := range supportedEncodings {
+		q, ok := qualities[encoding]
+		if !ok {
+			q = wildcard
+		}
+		if q > bestQ {
+			best, bestQ = encoding, q
+		}
+	}
+	return best
+}
+
+func isCompressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	if strings.HasPrefix(mediaType, "text/") {
+		return true
+	}
+	_, ok := compressibleTypes[mediaType]
+	return ok
+}
+
+// compressWriter buffers response until it knows whether the body is worth compressing,
+// after that writes go either to the encoder or straight to the underlying writer
+type compressWriter struct {
+	gin.ResponseWriter
+	encoding string
+	pool     *sync.Pool
+	minSize  int
+
+	status  int
+	buf     []byte
+	decided bool
+	enc     encoder
+}
+
+func (w *compressWriter) WriteHeader(code int) {
+	if code > 0 && !w.decided {
+		w.status = code
+	}
+}
+
+func (w *compressWriter) WriteHeaderNow() {
+	if !w.decided {
+		w.decide(false)
+	}
+}
+
+func (w *compressWriter) Status() int {
+	if !w.decided {
+		return w.status
+	}
+	return w.ResponseWriter.Status()
+}
+
+func (w *compressWriter) Written() bool {
+	return w.decided
+}
+
+func (w *compressWriter) Write(data []byte) (int, error) {
+	if !w.decided {
+		w.buf = append(w.buf, data...)
+		if len(w.buf) < w.minSize {
+			return len(data), nil
+		}
+		if err := w.decide(false); err != nil {
+			return 0, err
+		}
+		return len(data), nil
+	}
+	if w.enc != nil {
+		return w.enc.Write(data)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *compressWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// Flush sends everything written so far, streamed responses are compressed regardless of MinSize
+func (w *compressWriter) Flush() {
+	if !w.decided {
+		w.decide(true)
+	}
+	if w.enc != nil {
+		w.enc.Flush()
+	}
+	w.ResponseWriter.Flush()
+}
+
+func (w *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if w.decided && w.enc != nil {
+		return nil, nil, errors.New("connection can't be hijacked after compressed response started")
+	}
+	w.decided = true
+	return w.ResponseWriter.Hijack()
+}
+
+// decide sends headers and buffered body, compressing them if the response allows it.
+// streaming skips MinSize check as the final size of flushed response is unknown
+func (w *compressWriter) decide(streaming bool) error {
+	w.decided = true
+	header := w.Header()
+	if w.shouldCompress(streaming) {
+		header.Set("Content-Encoding", w.encoding)
+		header.Del("Content-Length")
+		w.enc = w.pool.Get().(encoder)
+		w.enc.Reset(w.ResponseWriter)
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	w.ResponseWriter.WriteHeaderNow()
+
+	buf := w.buf
+	w.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	if w.enc != nil {
+		_, err := w.enc.Write(buf)
+		return err
+	}
+	_, err := w.ResponseWriter.Write(buf)
+	return err
+}
+
+func (w *compressWriter) shouldCompress(streaming bool) bool {
+	if !streaming && len(w.buf) < w.minSize {
+		return false
+	}
+	if w.status < http.StatusOK || w.status == http.StatusNoContent || w.status == http.StatusNotModified {
+		return false
+	}
+	header := w.Header()
+	if header.Get("Content-Encoding") != "" {
+		return false
+	}
+	contentType := header.Get("Content-Type")
+	if contentType == "" {
+		contentType = http.DetectContentType(w.buf)
+		header.Set("Content-Type", contentType)
+	}
+	return isCompressible(contentType)
+}
+
+// finish is called after the handler, it sends buffered body and returns encoder to the pool
+func (w *compressWriter) finish() error {
+	if !w.decided {
+		// Тело целиком в буфере, если оно меньше minSize, decide отправит его без сжатия
+		if err := w.decide(false); err != nil {
+			return err
+		}
+	}
+	if w.enc == nil {
+		return nil
 	}
+	err := w.enc.Close()
+	w.enc.Reset(io.Discard)
+	w.pool.Put(w.enc)
+	w.enc = nil
+	return err
 }
@@ -3,34 +3,31 @@ package middleware
 import (
 	"bytes"
 	"compress/gzip"
+	"compress/zlib"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/gin-gonic/gin"
+	"github.com/klauspost/compress/zstd"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 type mockLogger struct{}
 
-func (l mockLogger) Warn(s string, i ...interface{}) {
-	//TODO implement me
-	panic("implement me")
-}
-
+func (l mockLogger) Warn(msg string, keysAndValues ...interface{})  {}
 func (l mockLogger) Info(msg string, keysAndValues ...interface{})  {}
 func (l mockLogger) Error(msg string, keysAndValues ...interface{}) {}
 func (l mockLogger) Fatal(msg string, keysAndValues ...interface{}) {}
 func (l mockLogger) Debug(msg string, keysAndValues ...interface{}) {}
 
 func TestGzipMiddleware(t *testing.T) {
-	gzipWriter, err := gzip.NewWriterLevel(nil, gzip.BestSpeed)
-	if err != nil {
-		t.Error(err)
-	}
 	r := gin.Default()
-	r.Use(GzipMiddleware(gzipWriter, &mockLogger{}))
+	r.Use(GzipMiddleware(GzipOptions{Level: gzip.BestSpeed}, &mockLogger{}))
 
 	r.POST("/test", func(c *gin.Context) {
 		buf := new(bytes.Buffer)
@@ -67,3 +64,181 @@ func TestGzipMiddleware(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, "response body", respBodyString)
 }
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		acceptEncoding string
+		want           string
+	}{
+		{acceptEncoding: "", want: ""},
+		{acceptEncoding: "gzip", want: "gzip"},
+		{acceptEncoding: "deflate, gzip", want: "gzip"},
+		{acceptEncoding: "gzip, deflate, br, zstd", want: "zstd"},
+		{acceptEncoding: "gzip;q=0.5, deflate;q=0.8", want: "deflate"},
+		{acceptEncoding: "zstd;q=0, gzip;q=0.1", want: "gzip"},
+		{acceptEncoding: "br", want: ""},
+		{acceptEncoding: "*", want: "zstd"},
+		{acceptEncoding: "*;q=0.5, gzip;q=0.9", want: "gzip"},
+		{acceptEncoding: "gzip;q=0, *;q=0", want: ""},
+		{acceptEncoding: "identity", want: ""},
+		{acceptEncoding: "GZIP;Q=1", want: "gzip"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.acceptEncoding, func(t *testing.T) {
+			assert.Equal(t, tt.want, negotiateEncoding(tt.acceptEncoding))
+		})
+	}
+}
+
+func TestGzipMiddleware_Responses(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	largeJSON := `{"result":"` + strings.Repeat("a", 2048) + `"}`
+
+	r := gin.New()
+	r.Use(GzipMiddleware(GzipOptions{Level: gzip.BestSpeed, MinSize: 1024}, &mockLogger{}))
+	r.GET("/large", func(c *gin.Context) {
+		c.Data(http.StatusOK, gin.MIMEJSON, []byte(largeJSON))
+	})
+	r.GET("/small", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"result": "ok"})
+	})
+	r.GET("/image", func(c *gin.Context) {
+		c.Data(http.StatusOK, "image/png", bytes.Repeat([]byte{1}, 2048))
+	})
+	r.GET("/stream", func(c *gin.Context) {
+		c.Header("Content-Type", "text/plain")
+		c.Writer.WriteString("first chunk")
+		c.Writer.Flush()
+		c.Writer.WriteString(" second chunk")
+	})
+
+	decoders := map[string]func(io.Reader) (io.Reader, error){
+		"gzip": func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+		"deflate": func(r io.Reader) (io.Reader, error) {
+			return zlib.NewReader(r)
+		},
+		"zstd": func(r io.Reader) (io.Reader, error) { return zstd.NewReader(r) },
+	}
+
+	tests := []struct {
+		name         string
+		path         string
+		accept       string
+		wantEncoding string
+		wantBody     string
+	}{
+		{name: "gzip", path: "/large", accept: "gzip", wantEncoding: "gzip", wantBody: largeJSON},
+		{name: "deflate", path: "/large", accept: "deflate", wantEncoding: "deflate", wantBody: largeJSON},
+		{name: "zstd", path: "/large", accept: "gzip;q=0.5, zstd", wantEncoding: "zstd", wantBody: largeJSON},
+		{name: "not accepted", path: "/large", accept: "br", wantBody: largeJSON},
+		{name: "below min size", path: "/small", accept: "gzip", wantBody: `{"result":"ok"}`},
+		{name: "not compressible", path: "/image", accept: "gzip", wantBody: string(bytes.Repeat([]byte{1}, 2048))},
+		{name: "flushed stream", path: "/stream", accept: "gzip", wantEncoding: "gzip", wantBody: "first chunk second chunk"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			req.Header.Set("Accept-Encoding", tt.accept)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, tt.wantEncoding, w.Header().Get("Content-Encoding"))
+			assert.Equal(t, "Accept-Encoding", w.Header().Get("Vary"))
+
+			body := io.Reader(w.Body)
+			if tt.wantEncoding != "" {
+				var err error
+				body, err = decoders[tt.wantEncoding](w.Body)
+				require.NoError(t, err)
+			}
+			data, err := io.ReadAll(body)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantBody, string(data))
+		})
+	}
+}
+
+func TestGzipMiddleware_Concurrent(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(GzipMiddleware(GzipOptions{Level: gzip.BestSpeed}, &mockLogger{}))
+	r.GET("/:id", func(c *gin.Context) {
+		c.String(http.StatusOK, strings.Repeat(c.Param("id"), 1000))
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			req := httptest.NewRequest(http.MethodGet, "/"+id, nil)
+			req.Header.Set("Accept-Encoding", "gzip")
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			gr, err := gzip.NewReader(w.Body)
+			if !assert.NoError(t, err) {
+				return
+			}
+			data, err := io.ReadAll(gr)
+			assert.NoError(t, err)
+			assert.Equal(t, strings.Repeat(id, 1000), string(data))
+		}(string(rune('a' + i)))
+	}
+	wg.Wait()
+}
+
+func TestGzipMiddleware_RequestLimit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(GzipMiddleware(GzipOptions{Level: gzip.BestSpeed, MaxDecompressedSize: 1024}, &mockLogger{}))
+	r.POST("/", func(c *gin.Context) {
+		data, err := io.ReadAll(c.Request.Body)
+		require.NoError(t, err)
+		c.String(http.StatusOK, "%d", len(data))
+	})
+
+	compress := func(encoding string, size int) *bytes.Buffer {
+		var b bytes.Buffer
+		var w io.WriteCloser
+		switch encoding {
+		case "gzip":
+			w = gzip.NewWriter(&b)
+		case "deflate":
+			w = zlib.NewWriter(&b)
+		case "zstd":
+			w, _ = zstd.NewWriter(&b)
+		}
+		w.Write(bytes.Repeat([]byte("a"), size))
+		w.Close()
+		return &b
+	}
+
+	tests := []struct {
+		name       string
+		encoding   string
+		body       io.Reader
+		wantStatus int
+	}{
+		{name: "gzip within limit", encoding: "gzip", body: compress("gzip", 1024), wantStatus: http.StatusOK},
+		{name: "deflate within limit", encoding: "deflate", body: compress("deflate", 100), wantStatus: http.StatusOK},
+		{name: "zstd within limit", encoding: "zstd", body: compress("zstd", 100), wantStatus: http.StatusOK},
+		{name: "gzip bomb", encoding: "gzip", body: compress("gzip", 1<<20), wantStatus: http.StatusRequestEntityTooLarge},
+		{name: "zstd bomb", encoding: "zstd", body: compress("zstd", 1<<20), wantStatus: http.StatusRequestEntityTooLarge},
+		{name: "broken body", encoding: "gzip", body: strings.NewReader("not gzip"), wantStatus: http.StatusBadRequest},
+		{name: "unknown encoding", encoding: "br", body: strings.NewReader("data"), wantStatus: http.StatusUnsupportedMediaType},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/", tt.body)
+			req.Header.Set("Content-Encoding", tt.encoding)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			assert.Equal(t, tt.wantStatus, w.Code)
+		})
+	}
+}
@@ -4,29 +4,75 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/maxzhirnov/urlshort/internal/logging"
 )
 
+const maxRequestIDLen = 128
+
+// RequestIDMiddleware takes request id from X-Request-ID header or generates new one, puts it
+// into request context and echoes it in the response
+func RequestIDMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.GetHeader(logging.RequestIDHeader)
+		if !validRequestID(requestID) {
+			requestID = uuid.New().String()
+		}
+
+		c.Set("request_id", requestID)
+		c.Request = c.Request.WithContext(logging.WithRequestID(c.Request.Context(), requestID))
+		c.Header(logging.RequestIDHeader, requestID)
+		c.Next()
+	}
+}
+
+// validRequestID accepts only short printable ids so that clients can't inject anything into logs
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// LoggingMiddleware writes a single access log line per request. It should be registered
+// after RequestIDMiddleware, user id is known only if a handler or middleware resolved it
 func LoggingMiddleware(logger logger) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		startTime := time.Now()
+		start := time.Now()
 		c.Next()
 
-		endTime := time.Now()
-		latencyTime := endTime.Sub(startTime)
+		size := c.Writer.Size()
+		if size < 0 {
+			size = 0
+		}
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
 
-		reqMethod := c.Request.Method
-		reqURI := c.Request.RequestURI
-		resStatusCode := c.Writer.Status()
-		resContentLength := c.Writer.Size()
+		// trace_id links the log line with the request trace, empty when tracing is disabled
+		var traceID string
+		if sc := trace.SpanContextFromContext(c.Request.Context()); sc.HasTraceID() {
+			traceID = sc.TraceID().String()
+		}
 
-		logger.Info("API request",
-			"method", reqMethod,
-			"uri", reqURI,
-			"latency", latencyTime.Seconds(),
+		logger.Info(logging.MsgRequestServed,
+			"method", c.Request.Method,
+			"route", route,
+			"status", c.Writer.Status(),
+			"bytes", size,
+			"latency_ms", float64(time.Since(start).Microseconds())/1000,
+			"user_id", c.GetString("user_id"),
+			"client_ip", c.ClientIP(),
+			"request_id", logging.RequestID(c.Request.Context()),
+			"trace_id", traceID,
 		)
-
-		logger.Info("API response",
-			"status_code", resStatusCode,
-			"content_length", resContentLength)
 	}
 }
@@ -0,0 +1,109 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/maxzhirnov/urlshort/internal/logging"
+)
+
+type recordingLogger struct {
+	mockLogger
+	messages []string
+	fields   []map[string]interface{}
+}
+
+func (l *recordingLogger) Info(msg string, keysAndValues ...interface{}) {
+	fields := make(map[string]interface{}, len(keysAndValues)/2)
+	for i := 0; i+1 < len(keysAndValues); i += 2 {
+		fields[keysAndValues[i].(string)] = keysAndValues[i+1]
+	}
+	l.messages = append(l.messages, msg)
+	l.fields = append(l.fields, fields)
+}
+
+func TestLoggingMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name          string
+		path          string
+		requestID     string
+		wantRoute     string
+		wantStatus    int
+		wantRequestID string
+	}{
+		{
+			name:          "propagated request id",
+			path:          "/abc",
+			requestID:     "req-42",
+			wantRoute:     "/:ID",
+			wantStatus:    http.StatusTemporaryRedirect,
+			wantRequestID: "req-42",
+		},
+		{
+			name:       "generated request id",
+			path:       "/abc",
+			wantRoute:  "/:ID",
+			wantStatus: http.StatusTemporaryRedirect,
+		},
+		{
+			name:       "invalid request id is replaced",
+			path:       "/abc",
+			requestID:  strings.Repeat("x", 200),
+			wantRoute:  "/:ID",
+			wantStatus: http.StatusTemporaryRedirect,
+		},
+		{
+			name:       "unmatched route",
+			path:       "/api/unknown",
+			wantRoute:  "unmatched",
+			wantStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := &recordingLogger{}
+			var handlerRequestID string
+			r := gin.New()
+			r.Use(RequestIDMiddleware(), LoggingMiddleware(l))
+			r.GET("/:ID", func(c *gin.Context) {
+				handlerRequestID = logging.RequestID(c.Request.Context())
+				c.Set("user_id", "user")
+				c.Redirect(http.StatusTemporaryRedirect, "https://example.com")
+			})
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.requestID != "" {
+				req.Header.Set(logging.RequestIDHeader, tt.requestID)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			require.Len(t, l.fields, 1)
+			fields := l.fields[0]
+			requestID := w.Header().Get(logging.RequestIDHeader)
+			assert.NotEmpty(t, requestID)
+			if tt.wantRequestID != "" {
+				assert.Equal(t, tt.wantRequestID, requestID)
+			} else {
+				assert.NotEqual(t, tt.requestID, requestID)
+			}
+			assert.Equal(t, requestID, fields["request_id"])
+			assert.Equal(t, tt.wantRoute, fields["route"])
+			assert.Equal(t, tt.wantStatus, fields["status"])
+			assert.Equal(t, http.MethodGet, fields["method"])
+			if tt.wantStatus == http.StatusTemporaryRedirect {
+				assert.Equal(t, requestID, handlerRequestID)
+				assert.Equal(t, "user", fields["user_id"])
+			}
+		})
+	}
+}
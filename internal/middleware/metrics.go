@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type httpMetrics interface {
+	ObserveHTTP(method, route string, status int, d time.Duration)
+}
+
+// knownMethods are recorded as is, other methods are recorded as "OTHER" so clients
+// can't create unlimited number of series
+var knownMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodPost:    true,
+	http.MethodPut:     true,
+	http.MethodPatch:   true,
+	http.MethodDelete:  true,
+	http.MethodConnect: true,
+	http.MethodOptions: true,
+	http.MethodTrace:   true,
+}
+
+// MetricsMiddleware records count and latency of requests by route pattern and status,
+// requests which didn't match any route are recorded with "unmatched" route
+func MetricsMiddleware(m httpMetrics) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		method := c.Request.Method
+		if !knownMethods[method] {
+			method = "OTHER"
+		}
+		m.ObserveHTTP(method, route, c.Writer.Status(), time.Since(start))
+	}
+}
@@ -0,0 +1,73 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type observation struct {
+	method string
+	route  string
+	status int
+}
+
+type recordingMetrics struct {
+	observed []observation
+}
+
+func (m *recordingMetrics) ObserveHTTP(method, route string, status int, _ time.Duration) {
+	m.observed = append(m.observed, observation{method: method, route: route, status: status})
+}
+
+func TestMetricsMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		want   observation
+	}{
+		{
+			name:   "matched route is recorded by pattern",
+			method: http.MethodGet,
+			path:   "/abc",
+			want:   observation{method: http.MethodGet, route: "/:ID", status: http.StatusTemporaryRedirect},
+		},
+		{
+			name:   "unmatched route",
+			method: http.MethodGet,
+			path:   "/a/b/c",
+			want:   observation{method: http.MethodGet, route: "unmatched", status: http.StatusNotFound},
+		},
+		{
+			name:   "non-standard method",
+			method: "FOOBAR",
+			path:   "/abc",
+			want:   observation{method: "OTHER", route: "unmatched", status: http.StatusNotFound},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &recordingMetrics{}
+			r := gin.New()
+			r.Use(MetricsMiddleware(m))
+			r.GET("/:ID", func(c *gin.Context) {
+				c.Redirect(http.StatusTemporaryRedirect, "https://example.com")
+			})
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, nil))
+
+			require.Len(t, m.observed, 1)
+			assert.Equal(t, tt.want, m.observed[0])
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/maxzhirnov/urlshort/internal/auth"
+	"github.com/maxzhirnov/urlshort/internal/ratelimit"
+)
+
+// RateLimitMiddleware rejects requests with 429 when either the caller's or the client ip's
+// bucket is empty. Callers are identified by api key or by user id from the token,
+// requests without a valid token are limited only by ip
+func RateLimitMiddleware(perUser, perIP *ratelimit.Limiter, a *auth.Auth, l logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if ok, retryAfter := perIP.Allow(c.ClientIP()); !ok {
+			l.Warn("rate limit exceeded", "ip", c.ClientIP(), "path", c.FullPath())
+			abortTooManyRequests(c, retryAfter)
+			return
+		}
+
+		if key := callerKey(c, a); key != "" {
+			if ok, retryAfter := perUser.Allow(key); !ok {
+				l.Warn("rate limit exceeded", "caller", key, "path", c.FullPath())
+				abortTooManyRequests(c, retryAfter)
+				return
+			}
+		}
+
+		c.Next()
+	}
+}
+
+func callerKey(c *gin.Context, a *auth.Auth) string {
+	// Ключ не проверяется по базе, чтобы запросы с неверными ключами тоже ограничивались
+	if key, ok := auth.APIKeyFromRequest(c.Request); ok {
+		return "key:" + auth.HashAPIKey(key)
+	}
+
+	token, ok := c.Get("jwt_token")
+	if !ok {
+		if token, ok = a.TokenFromRequest(c.Request); !ok {
+			return ""
+		}
+	}
+	if userID := a.ValidateToken(token.(string)); userID != "" {
+		return "user:" + userID
+	}
+	return ""
+}
+
+func abortTooManyRequests(c *gin.Context, retryAfter time.Duration) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "too many requests"})
+}
@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/maxzhirnov/urlshort/internal/auth"
+	"github.com/maxzhirnov/urlshort/internal/ratelimit"
+)
+
+func TestRateLimitMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	a := auth.NewAuth()
+	firstUser, err := a.GenerateToken("first")
+	require.NoError(t, err)
+	secondUser, err := a.GenerateToken("second")
+	require.NoError(t, err)
+
+	perUser := ratelimit.NewLimiter(ratelimit.Limit{Burst: 1, Per: time.Minute})
+	perIP := ratelimit.NewLimiter(ratelimit.Limit{Burst: 3, Per: time.Minute})
+
+	r := gin.New()
+	r.POST("/api/shorten", RateLimitMiddleware(perUser, perIP, a, &mockLogger{}), func(c *gin.Context) {
+		c.Status(http.StatusCreated)
+	})
+
+	send := func(token, ip string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/shorten", nil)
+		req.RemoteAddr = ip + ":12345"
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusCreated, send(firstUser, "10.0.0.1").Code)
+
+	resp := send(firstUser, "10.0.0.1")
+	assert.Equal(t, http.StatusTooManyRequests, resp.Code)
+	assert.Equal(t, "60", resp.Header().Get("Retry-After"))
+
+	// Лимит пользователя не зависит от ip, лимит ip - от пользователя
+	assert.Equal(t, http.StatusTooManyRequests, send(firstUser, "10.0.0.2").Code)
+	assert.Equal(t, http.StatusCreated, send(secondUser, "10.0.0.1").Code)
+	assert.Equal(t, http.StatusTooManyRequests, send("", "10.0.0.1").Code)
+	assert.Equal(t, http.StatusCreated, send("", "10.0.0.3").Code)
+}
@@ -1,7 +1,6 @@
 package middleware
 
 import (
-	"errors"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
@@ -9,29 +8,35 @@ import (
 	"github.com/maxzhirnov/urlshort/internal/auth"
 )
 
-const cookieExpireTime = 3600 * 24 * 365
-
+// TokenIssuerMiddleware issues token of a new anonymous user if request has no valid token
+// in Authorization header or cookie. New token is set as cookie and echoed in X-Auth-Token header
 func TokenIssuerMiddleware(a *auth.Auth, l logger) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// Проверяем имеется ли кука jwt_token
-		cookie, err := c.Request.Cookie("jwt_token")
+		// Клиенты с api ключом идентифицируются по нему, кука им не нужна
+		if _, ok := auth.APIKeyFromRequest(c.Request); ok {
+			c.Next()
+			return
+		}
 
-		// Если куки нет или она не валидна
-		if errors.Is(err, http.ErrNoCookie) || (err == nil && a.ValidateToken(cookie.Value) == "") {
-			l.Error(err.Error())
-			userID := a.GenerateUUID()
-			jwtToken, err := a.GenerateToken(userID)
-			if err != nil {
-				l.Error("Failed to generate token: ", err)
-				c.JSON(http.StatusInternalServerError, gin.H{"message": "Couldn't generate token"})
+		if token, ok := a.TokenFromRequest(c.Request); ok {
+			if userID := a.ValidateToken(token); userID != "" {
+				c.Set("user_id", userID)
+				c.Next()
 				return
 			}
-			c.SetCookie("jwt_token", jwtToken, cookieExpireTime, "/", "localhost", false, true)
-			c.Set("jwt_token", jwtToken)
-		} else if err != nil {
-			// Вообще метод Request.Cookie() может вернуть только одну ошибку, но на всякий случай проверим
-			l.Error("Unknown error: ", err)
 		}
+
+		userID := a.GenerateUUID()
+		jwtToken, err := a.GenerateToken(userID)
+		if err != nil {
+			l.Error("Failed to generate token", "error", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Couldn't generate token"})
+			return
+		}
+		http.SetCookie(c.Writer, a.NewCookie(jwtToken))
+		c.Header(auth.TokenHeader, jwtToken)
+		c.Set("jwt_token", jwtToken)
+		c.Set("user_id", userID)
 		c.Next()
 	}
 }
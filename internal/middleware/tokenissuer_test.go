@@ -0,0 +1,98 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/maxzhirnov/urlshort/internal/auth"
+)
+
+func TestTokenIssuerMiddleware(t *testing.T) {
+	a := auth.NewAuth().WithCookieConfig(auth.CookieConfig{
+		Name:     "session",
+		Domain:   "short.example.com",
+		Path:     "/",
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+		MaxAge:   time.Hour,
+	})
+	validToken, err := a.GenerateToken("user")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name        string
+		header      string
+		value       string
+		cookie      string
+		expectIssue bool
+	}{
+		{
+			name:        "no token",
+			expectIssue: true,
+		},
+		{
+			name:   "valid cookie",
+			cookie: validToken,
+		},
+		{
+			name:   "valid bearer token",
+			header: "Authorization",
+			value:  "Bearer " + validToken,
+		},
+		{
+			name:        "invalid bearer token",
+			header:      "Authorization",
+			value:       "Bearer broken",
+			expectIssue: true,
+		},
+		{
+			name:   "api key",
+			header: "X-API-Key",
+			value:  "us_key",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := gin.New()
+			r.Use(TokenIssuerMiddleware(a, &mockLogger{}))
+			r.GET("/test", func(c *gin.Context) {
+				c.String(http.StatusOK, "ok")
+			})
+
+			req, _ := http.NewRequest(http.MethodGet, "/test", nil)
+			if tt.header != "" {
+				req.Header.Set(tt.header, tt.value)
+			}
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: "session", Value: tt.cookie})
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			cookies := w.Result().Cookies()
+			if !tt.expectIssue {
+				assert.Empty(t, cookies)
+				assert.Empty(t, w.Header().Get(auth.TokenHeader))
+				return
+			}
+
+			require.Len(t, cookies, 1)
+			cookie := cookies[0]
+			assert.Equal(t, "session", cookie.Name)
+			assert.Equal(t, "short.example.com", cookie.Domain)
+			assert.True(t, cookie.Secure)
+			assert.True(t, cookie.HttpOnly)
+			assert.Equal(t, http.SameSiteStrictMode, cookie.SameSite)
+			assert.Equal(t, 3600, cookie.MaxAge)
+			assert.Equal(t, cookie.Value, w.Header().Get(auth.TokenHeader))
+			assert.NotEmpty(t, a.ValidateToken(cookie.Value))
+		})
+	}
+}
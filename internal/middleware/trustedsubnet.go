@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const realIPHeader = "X-Real-IP"
+
+// TrustedSubnetMiddleware allows only clients whose IP from X-Real-IP header or from
+// the connection is inside one of the subnets, with no subnets everyone is denied
+func TrustedSubnetMiddleware(subnets []*net.IPNet) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ip := clientIP(c.Request)
+		if ip == nil || !inSubnets(ip, subnets) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "access denied"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// clientIP doesn't use gin's ClientIP, it trusts X-Forwarded-For from any proxy
+func clientIP(r *http.Request) net.IP {
+	if v := strings.TrimSpace(r.Header.Get(realIPHeader)); v != "" {
+		return net.ParseIP(v)
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+func inSubnets(ip net.IP, subnets []*net.IPNet) bool {
+	for _, subnet := range subnets {
+		if subnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
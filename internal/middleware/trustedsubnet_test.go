@@ -0,0 +1,80 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrustedSubnetMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	_, subnet, err := net.ParseCIDR("10.0.0.0/24")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		subnets    []*net.IPNet
+		remoteAddr string
+		realIP     string
+		wantStatus int
+	}{
+		{
+			name:       "real ip inside subnet",
+			subnets:    []*net.IPNet{subnet},
+			remoteAddr: "192.168.1.1:1234",
+			realIP:     "10.0.0.15",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "real ip outside subnet",
+			subnets:    []*net.IPNet{subnet},
+			remoteAddr: "10.0.0.2:1234",
+			realIP:     "10.0.1.15",
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "connection ip inside subnet",
+			subnets:    []*net.IPNet{subnet},
+			remoteAddr: "10.0.0.2:1234",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "invalid real ip",
+			subnets:    []*net.IPNet{subnet},
+			remoteAddr: "10.0.0.2:1234",
+			realIP:     "localhost",
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "no subnet configured",
+			remoteAddr: "10.0.0.2:1234",
+			realIP:     "10.0.0.15",
+			wantStatus: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := gin.New()
+			r.GET("/api/internal/stats", TrustedSubnetMiddleware(tt.subnets), func(c *gin.Context) {
+				c.Status(http.StatusOK)
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/api/internal/stats", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.realIP != "" {
+				req.Header.Set(realIPHeader, tt.realIP)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.wantStatus, w.Code)
+		})
+	}
+}
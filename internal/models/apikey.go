@@ -0,0 +1,17 @@
+package models
+
+import (
+	"time"
+)
+
+// APIKey is a long-lived credential of the user for programmatic clients,
+// only sha256 hash of the key is stored
+type APIKey struct {
+	ID        string    `json:"id"`
+	UUID      string    `json:"uuid"`
+	Name      string    `json:"name"`
+	Prefix    string    `json:"prefix"`
+	Hash      string    `json:"hash"`
+	CreatedAt time.Time `json:"created_at"`
+	Revoked   bool      `json:"revoked"`
+}
@@ -0,0 +1,44 @@
+package models
+
+import (
+	"time"
+)
+
+type OrgRole string
+
+const (
+	RoleViewer OrgRole = "viewer"
+	RoleEditor OrgRole = "editor"
+	RoleOwner  OrgRole = "owner"
+)
+
+var roleRanks = map[OrgRole]int{
+	RoleViewer: 1,
+	RoleEditor: 2,
+	RoleOwner:  3,
+}
+
+func (r OrgRole) Valid() bool {
+	_, ok := roleRanks[r]
+	return ok
+}
+
+// Allows reports whether the role grants everything the required role does
+func (r OrgRole) Allows(required OrgRole) bool {
+	return roleRanks[r] >= roleRanks[required] && r.Valid()
+}
+
+// Organization owns links shared by its members
+type Organization struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Membership is a role of the user in the organization, empty role means
+// the user was removed from the organization
+type Membership struct {
+	OrgID string  `json:"org_id"`
+	UUID  string  `json:"uuid"`
+	Role  OrgRole `json:"role"`
+}
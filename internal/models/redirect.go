@@ -0,0 +1,119 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// ReferrerPolicies lists values of Referrer-Policy header
+var ReferrerPolicies = []string{
+	"no-referrer",
+	"no-referrer-when-downgrade",
+	"origin",
+	"origin-when-cross-origin",
+	"same-origin",
+	"strict-origin",
+	"strict-origin-when-cross-origin",
+	"unsafe-url",
+}
+
+const maxCacheControlLen = 256
+
+// PassthroughMode tells how query or trailing path of the short link request is merged
+// into the original url
+type PassthroughMode string
+
+const (
+	// PassthroughAppend adds incoming query parameters after the original ones and
+	// appends trailing path to the original path
+	PassthroughAppend PassthroughMode = "append"
+	// PassthroughOverride replaces original query parameters with incoming ones of the same name
+	// and replaces the original path with trailing path
+	PassthroughOverride PassthroughMode = "override"
+	// PassthroughIgnore drops incoming query and trailing path
+	PassthroughIgnore PassthroughMode = "ignore"
+)
+
+// Valid reports whether m is one of known modes
+func (m PassthroughMode) Valid() bool {
+	switch m {
+	case PassthroughAppend, PassthroughOverride, PassthroughIgnore:
+		return true
+	}
+	return false
+}
+
+// RedirectOptions control response of the short link, zero fields mean server defaults
+type RedirectOptions struct {
+	// RedirectCode is 301, 302, 307 or 308
+	RedirectCode   int    `json:"redirect_code,omitempty"`
+	CacheControl   string `json:"cache_control,omitempty"`
+	ReferrerPolicy string `json:"referrer_policy,omitempty"`
+	// QueryMode and PathMode control passthrough of query and trailing path
+	QueryMode PassthroughMode `json:"query_mode,omitempty"`
+	PathMode  PassthroughMode `json:"path_mode,omitempty"`
+}
+
+// ValidRedirectCode reports whether code is a redirect status allowed for links
+func ValidRedirectCode(code int) bool {
+	switch code {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
+
+func (o RedirectOptions) Validate() error {
+	if o.RedirectCode != 0 && !ValidRedirectCode(o.RedirectCode) {
+		return fmt.Errorf("redirect code should be 301, 302, 307 or 308, got %d", o.RedirectCode)
+	}
+	if len(o.CacheControl) > maxCacheControlLen {
+		return fmt.Errorf("cache control shouldn't be longer than %d bytes", maxCacheControlLen)
+	}
+	for _, r := range o.CacheControl {
+		// Переводы строк позволили бы дописать в ответ свои заголовки
+		if r < ' ' || r > '~' {
+			return errors.New("cache control should contain only printable ASCII characters")
+		}
+	}
+	if o.ReferrerPolicy != "" && !validReferrerPolicy(o.ReferrerPolicy) {
+		return fmt.Errorf("unknown referrer policy %q", o.ReferrerPolicy)
+	}
+	if o.QueryMode != "" && !o.QueryMode.Valid() {
+		return fmt.Errorf("query mode should be append, override or ignore, got %q", o.QueryMode)
+	}
+	if o.PathMode != "" && !o.PathMode.Valid() {
+		return fmt.Errorf("path mode should be append, override or ignore, got %q", o.PathMode)
+	}
+	return nil
+}
+
+// WithDefaults fills zero fields from defaults
+func (o RedirectOptions) WithDefaults(defaults RedirectOptions) RedirectOptions {
+	if o.RedirectCode == 0 {
+		o.RedirectCode = defaults.RedirectCode
+	}
+	if o.CacheControl == "" {
+		o.CacheControl = defaults.CacheControl
+	}
+	if o.ReferrerPolicy == "" {
+		o.ReferrerPolicy = defaults.ReferrerPolicy
+	}
+	if o.QueryMode == "" {
+		o.QueryMode = defaults.QueryMode
+	}
+	if o.PathMode == "" {
+		o.PathMode = defaults.PathMode
+	}
+	return o
+}
+
+func validReferrerPolicy(policy string) bool {
+	for _, p := range ReferrerPolicies {
+		if p == policy {
+			return true
+		}
+	}
+	return false
+}
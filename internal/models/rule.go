@@ -0,0 +1,100 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Operating systems and browsers which redirect rules can match on
+const (
+	OSiOS     = "ios"
+	OSAndroid = "android"
+	OSDesktop = "desktop"
+
+	BrowserChrome  = "chrome"
+	BrowserFirefox = "firefox"
+	BrowserSafari  = "safari"
+	BrowserEdge    = "edge"
+	BrowserOpera   = "opera"
+)
+
+const (
+	// MaxRedirectRules limits rules of a link, they are checked on every redirect
+	MaxRedirectRules   = 20
+	maxRuleURLLen      = 2048
+	maxRuleLanguageLen = 35
+)
+
+// RedirectRule sends clients matching all set conditions to URL instead of the original url,
+// empty conditions match any client
+type RedirectRule struct {
+	// OS is ios, android or desktop
+	OS string `json:"os,omitempty"`
+	// Browser is chrome, firefox, safari, edge or opera
+	Browser string `json:"browser,omitempty"`
+	// Language is a language tag like "ru" or "pt-BR", "pt" matches any region
+	Language string `json:"language,omitempty"`
+	URL      string `json:"url"`
+}
+
+func (r RedirectRule) Validate() error {
+	if r.URL == "" || len(r.URL) > maxRuleURLLen {
+		return fmt.Errorf("url should be non-empty and at most %d bytes", maxRuleURLLen)
+	}
+	if u, err := url.Parse(r.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("url should be absolute http or https url, got %q", r.URL)
+	}
+	if r.OS == "" && r.Browser == "" && r.Language == "" {
+		return errors.New("rule should set at least one of os, browser, language")
+	}
+	switch r.OS {
+	case "", OSiOS, OSAndroid, OSDesktop:
+	default:
+		return fmt.Errorf("os should be ios, android or desktop, got %q", r.OS)
+	}
+	switch r.Browser {
+	case "", BrowserChrome, BrowserFirefox, BrowserSafari, BrowserEdge, BrowserOpera:
+	default:
+		return fmt.Errorf("browser should be chrome, firefox, safari, edge or opera, got %q", r.Browser)
+	}
+	if !validLanguageTag(r.Language) {
+		return fmt.Errorf("invalid language tag %q", r.Language)
+	}
+	return nil
+}
+
+// ValidateRedirectRules checks every rule and the number of rules
+func ValidateRedirectRules(rules []RedirectRule) error {
+	if len(rules) > MaxRedirectRules {
+		return fmt.Errorf("link can have at most %d rules", MaxRedirectRules)
+	}
+	for i, r := range rules {
+		if err := r.Validate(); err != nil {
+			return fmt.Errorf("rule %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// validLanguageTag accepts empty tag and tags of letters and digits separated by hyphens
+func validLanguageTag(tag string) bool {
+	if tag == "" {
+		return true
+	}
+	if len(tag) > maxRuleLanguageLen {
+		return false
+	}
+	for _, part := range strings.Split(tag, "-") {
+		if part == "" {
+			return false
+		}
+		for _, r := range part {
+			if !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9') {
+				return false
+			}
+		}
+	}
+	return true
+}
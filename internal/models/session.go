@@ -0,0 +1,15 @@
+package models
+
+import (
+	"time"
+)
+
+// Session is a token issued to an account, ID is the jti claim of the token
+type Session struct {
+	ID        string    `json:"id"`
+	UUID      string    `json:"uuid"`
+	UserAgent string    `json:"user_agent"`
+	IssuedAt  time.Time `json:"issued_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Revoked   bool      `json:"revoked"`
+}
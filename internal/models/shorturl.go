@@ -9,6 +9,22 @@ type ShortURL struct {
 	ID          string `json:"id"`
 	UUID        string `json:"uuid"`
 	DeletedFlag bool   `json:"deleted_flag"`
+	// Disabled links are blocked by admin and can be enabled back
+	Disabled bool `json:"disabled"`
+	// OrgID is set for links shared by organization, UUID is still the creator
+	OrgID string `json:"org_id,omitempty"`
+	RedirectOptions
+	// Rules are checked in order on redirect, the first matching rule replaces OriginalURL
+	Rules []RedirectRule `json:"rules,omitempty"`
+}
+
+// URLFilter selects links for admin search, empty fields match everything
+type URLFilter struct {
+	// Query matches link id exactly or part of original url
+	Query  string
+	UUID   string
+	Limit  int
+	Offset int
 }
 
 func (u ShortURL) String() string {
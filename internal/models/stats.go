@@ -0,0 +1,11 @@
+package models
+
+// Stats is a summary of everything stored in the storage
+type Stats struct {
+	URLs            int `json:"urls"`
+	DeletedURLs     int `json:"deleted_urls"`
+	DisabledURLs    int `json:"disabled_urls"`
+	Users           int `json:"users"`
+	RegisteredUsers int `json:"registered_users"`
+	APIKeys         int `json:"api_keys"`
+}
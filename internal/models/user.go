@@ -0,0 +1,15 @@
+package models
+
+import (
+	"time"
+)
+
+// User is a registered account, its ID is used as uuid of the links it owns.
+// Accounts created through OpenID Connect have ExternalID and no password
+type User struct {
+	ID           string    `json:"id"`
+	Login        string    `json:"login"`
+	PasswordHash string    `json:"password_hash"`
+	ExternalID   string    `json:"external_id,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+}
@@ -0,0 +1,193 @@
+// Package ratelimit implements in-memory token bucket limits keyed by arbitrary strings,
+// e.g. user id or client ip
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxBuckets bounds memory used by limiter, when exceeded full buckets are evicted right away
+const maxBuckets = 100_000
+
+// Limit allows Burst requests at once, the bucket is refilled with Burst tokens every Per.
+// Zero limit means no limit
+type Limit struct {
+	Burst int
+	Per   time.Duration
+}
+
+// ParseLimit parses limit of the form "60/m", "10/s", "1000/h" or "100/10s",
+// empty string and "0" disable the limit
+func ParseLimit(s string) (Limit, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || s == "0" {
+		return Limit{}, nil
+	}
+
+	count, period, ok := strings.Cut(s, "/")
+	if !ok {
+		return Limit{}, fmt.Errorf("invalid rate limit %q, expected <count>/<period>", s)
+	}
+	burst, err := strconv.Atoi(count)
+	if err != nil || burst < 0 {
+		return Limit{}, fmt.Errorf("invalid rate limit count %q", count)
+	}
+	if period != "" && (period[0] < '0' || period[0] > '9') {
+		period = "1" + period
+	}
+	per, err := time.ParseDuration(period)
+	if err != nil || per <= 0 {
+		return Limit{}, fmt.Errorf("invalid rate limit period %q", period)
+	}
+
+	return Limit{Burst: burst, Per: per}, nil
+}
+
+func (l Limit) Enabled() bool {
+	return l.Burst > 0 && l.Per > 0
+}
+
+func (l Limit) String() string {
+	if !l.Enabled() {
+		return "0"
+	}
+	return fmt.Sprintf("%d/%s", l.Burst, l.Per)
+}
+
+// interval is time needed to refill a single token
+func (l Limit) interval() time.Duration {
+	return l.Per / time.Duration(l.Burst)
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Limiter keeps a token bucket per key, buckets refilled to full are evicted by Cleanup
+type Limiter struct {
+	limit Limit
+	now   func() time.Time
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+func NewLimiter(limit Limit) *Limiter {
+	return &Limiter{
+		limit:   limit,
+		now:     time.Now,
+		buckets: make(map[string]*bucket),
+	}
+}
+
+func (l *Limiter) Limit() Limit {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.limit
+}
+
+// SetLimit changes limit of every key, tokens left in existing buckets are capped by the new burst
+func (l *Limiter) SetLimit(limit Limit) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if limit == l.limit {
+		return
+	}
+	l.limit = limit
+	if !limit.Enabled() {
+		l.buckets = make(map[string]*bucket)
+		return
+	}
+	for _, b := range l.buckets {
+		b.tokens = math.Min(b.tokens, float64(limit.Burst))
+	}
+}
+
+// Allow takes a token from the key's bucket, if the bucket is empty it returns false
+// and the time after which the next token is available
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	now := l.now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.limit.Enabled() {
+		return true, 0
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= maxBuckets {
+			l.cleanupLocked(now)
+		}
+		b = &bucket{tokens: float64(l.limit.Burst), last: now}
+		l.buckets[key] = b
+	}
+	l.refill(b, now)
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	missing := 1 - b.tokens
+	retryAfter := time.Duration(math.Ceil(missing * float64(l.limit.interval())))
+	return false, retryAfter
+}
+
+func (l *Limiter) refill(b *bucket, now time.Time) {
+	elapsed := now.Sub(b.last)
+	if elapsed <= 0 {
+		return
+	}
+	b.tokens = math.Min(float64(l.limit.Burst), b.tokens+float64(elapsed)/float64(l.limit.interval()))
+	b.last = now
+}
+
+// Len returns number of tracked keys
+func (l *Limiter) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.buckets)
+}
+
+// Cleanup evicts buckets which are full again, they behave the same as missing ones.
+// Returns number of evicted buckets
+func (l *Limiter) Cleanup() int {
+	now := l.now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.cleanupLocked(now)
+}
+
+func (l *Limiter) cleanupLocked(now time.Time) int {
+	evicted := 0
+	for key, b := range l.buckets {
+		l.refill(b, now)
+		if b.tokens >= float64(l.limit.Burst) {
+			delete(l.buckets, key)
+			evicted++
+		}
+	}
+	return evicted
+}
+
+// RunCleanup evicts idle buckets every interval until ctx is done
+func (l *Limiter) RunCleanup(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			l.Cleanup()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
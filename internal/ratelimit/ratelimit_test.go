@@ -0,0 +1,115 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLimit(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    Limit
+		wantErr bool
+	}{
+		{input: "", want: Limit{}},
+		{input: "0", want: Limit{}},
+		{input: "60/m", want: Limit{Burst: 60, Per: time.Minute}},
+		{input: "10/s", want: Limit{Burst: 10, Per: time.Second}},
+		{input: "100/10s", want: Limit{Burst: 100, Per: 10 * time.Second}},
+		{input: "60", wantErr: true},
+		{input: "x/m", wantErr: true},
+		{input: "10/week", wantErr: true},
+		{input: "10/0s", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseLimit(tt.input)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	now := time.Now()
+	l := NewLimiter(Limit{Burst: 2, Per: 2 * time.Second})
+	l.now = func() time.Time { return now }
+
+	ok, _ := l.Allow("a")
+	assert.True(t, ok)
+	ok, _ = l.Allow("a")
+	assert.True(t, ok)
+	ok, retryAfter := l.Allow("a")
+	assert.False(t, ok)
+	assert.Equal(t, time.Second, retryAfter)
+
+	// У другого ключа своя корзина
+	ok, _ = l.Allow("b")
+	assert.True(t, ok)
+
+	now = now.Add(500 * time.Millisecond)
+	ok, retryAfter = l.Allow("a")
+	assert.False(t, ok)
+	assert.Equal(t, 500*time.Millisecond, retryAfter)
+
+	now = now.Add(500 * time.Millisecond)
+	ok, _ = l.Allow("a")
+	assert.True(t, ok)
+
+	// Полностью восстановившиеся корзины удаляются
+	now = now.Add(time.Second)
+	assert.Equal(t, 1, l.Cleanup())
+	assert.Equal(t, 1, l.Len())
+	now = now.Add(time.Second)
+	assert.Equal(t, 1, l.Cleanup())
+	assert.Equal(t, 0, l.Len())
+}
+
+func TestLimiterDisabled(t *testing.T) {
+	l := NewLimiter(Limit{})
+	for i := 0; i < 100; i++ {
+		ok, _ := l.Allow("a")
+		assert.True(t, ok)
+	}
+	assert.Equal(t, 0, l.Len())
+}
+
+func TestLimiterSetLimit(t *testing.T) {
+	now := time.Now()
+	l := NewLimiter(Limit{Burst: 5, Per: 5 * time.Second})
+	l.now = func() time.Time { return now }
+
+	ok, _ := l.Allow("a")
+	assert.True(t, ok)
+
+	// Оставшиеся 4 токена урезаются до нового burst
+	l.SetLimit(Limit{Burst: 2, Per: 2 * time.Second})
+	assert.Equal(t, Limit{Burst: 2, Per: 2 * time.Second}, l.Limit())
+	for i := 0; i < 2; i++ {
+		ok, _ = l.Allow("a")
+		assert.True(t, ok)
+	}
+	ok, _ = l.Allow("a")
+	assert.False(t, ok)
+
+	// Отключение лимита сбрасывает корзины, повторное включение начинает с полных
+	l.SetLimit(Limit{})
+	assert.Equal(t, 0, l.Len())
+	assert.Equal(t, 0, l.Cleanup())
+	ok, _ = l.Allow("a")
+	assert.True(t, ok)
+
+	l.SetLimit(Limit{Burst: 1, Per: time.Second})
+	ok, _ = l.Allow("a")
+	assert.True(t, ok)
+	ok, _ = l.Allow("a")
+	assert.False(t, ok)
+}
@@ -6,11 +6,19 @@ import (
 	"fmt"
 	"time"
 
+	"go.opentelemetry.io/otel"
+
+	"github.com/maxzhirnov/urlshort/internal/logging"
 	"github.com/maxzhirnov/urlshort/internal/models"
 	"github.com/maxzhirnov/urlshort/internal/storages"
 )
 
-var ErrEntityAlreadyExist = errors.New("entity already exist")
+var tracer = otel.Tracer("github.com/maxzhirnov/urlshort/internal/repositories")
+
+var (
+	ErrEntityAlreadyExist = errors.New("entity already exist")
+	ErrNotFound           = errors.New("entity not found")
+)
 
 type logger interface {
 	Info(string, ...interface{})
@@ -28,6 +36,33 @@ type Storage interface {
 	GetURLByOriginalURL(ctx context.Context, url string) (models.ShortURL, bool)
 	GetURLsByUUID(ctx context.Context, uuid string) ([]models.ShortURL, error)
 	TagURLsDeleted(context.Context, []models.Deletion) error
+	InsertAPIKey(context.Context, models.APIKey) error
+	GetAPIKeyByHash(ctx context.Context, hash string) (models.APIKey, bool, error)
+	GetAPIKeysByUUID(ctx context.Context, uuid string) ([]models.APIKey, error)
+	RevokeAPIKey(ctx context.Context, id, uuid string) error
+	InsertUser(context.Context, models.User) error
+	GetUserByID(ctx context.Context, id string) (models.User, bool)
+	GetUserByLogin(ctx context.Context, login string) (models.User, bool)
+	GetUserByExternalID(ctx context.Context, externalID string) (models.User, bool)
+	ReassignURLs(ctx context.Context, fromUUID, toUUID string) (int, error)
+	TagURLsDeletedByID(ctx context.Context, ids []string) error
+	SetURLsDisabled(ctx context.Context, ids []string, disabled bool) error
+	SetURLRules(ctx context.Context, id string, rules []models.RedirectRule) error
+	SearchURLs(context.Context, models.URLFilter) ([]models.ShortURL, error)
+	ListUsers(ctx context.Context, query string, limit, offset int) ([]models.User, error)
+	Stats(context.Context) (models.Stats, error)
+	InsertSession(context.Context, models.Session) error
+	GetSessionsByUUID(ctx context.Context, uuid string) ([]models.Session, error)
+	RevokeSession(context.Context, models.Session) error
+	GetRevokedSessions(ctx context.Context, expiresAfter time.Time) ([]models.Session, error)
+	DeleteExpiredSessions(ctx context.Context, expiredBefore time.Time) (int, error)
+	InsertOrganization(context.Context, models.Organization) error
+	GetOrganization(ctx context.Context, id string) (models.Organization, bool)
+	SetMembership(context.Context, models.Membership) error
+	GetMembershipsByUUID(ctx context.Context, uuid string) ([]models.Membership, error)
+	GetOrgMembers(ctx context.Context, orgID string) ([]models.Membership, error)
+	GetURLsByOrg(ctx context.Context, orgID string) ([]models.ShortURL, error)
+	SetURLsOrg(ctx context.Context, ids []string, uuid, orgID string) (int, error)
 	Bootstrap() error
 	Close() error
 	Ping() error
@@ -38,30 +73,63 @@ type Storage interface {
 type Repository struct {
 	logger  logger
 	storage Storage
+
+	metrics storageMetrics
+	backend string
 }
 
+type storageMetrics interface {
+	ObserveStorage(backend, operation string, d time.Duration)
+}
+
+type noopStorageMetrics struct{}
+
+func (noopStorageMetrics) ObserveStorage(string, string, time.Duration) {}
+
 func NewRepository(logger logger, storage Storage) *Repository {
 	return &Repository{
 		logger:  logger,
 		storage: storage,
+		metrics: noopStorageMetrics{},
+	}
+}
+
+// WithMetrics enables recording of storage operations latency labeled with backend name
+func (r *Repository) WithMetrics(m storageMetrics, backend string) *Repository {
+	r.metrics = m
+	r.backend = backend
+	return r
+}
+
+// observe starts span and measuring of an operation, returned function ends the span and records the latency
+func (r *Repository) observe(ctx context.Context, operation string) (context.Context, func()) {
+	start := time.Now()
+	ctx, span := tracer.Start(ctx, "repository."+operation)
+	return ctx, func() {
+		span.End()
+		r.metrics.ObserveStorage(r.backend, operation, time.Since(start))
 	}
 }
 
 func (r *Repository) Insert(ctx context.Context, url models.ShortURL) (models.ShortURL, error) {
+	ctx, end := r.observe(ctx, "insert")
+	defer end()
 	insertedURL, err := r.storage.InsertURL(ctx, url)
 	if err != nil {
 		if errors.Is(err, storages.ErrEntityAlreadyExist) {
 			return insertedURL, ErrEntityAlreadyExist
 		}
-		r.logger.Error("error: ", err)
+		r.logger.Error("storage error", "error", err, "request_id", logging.RequestID(ctx))
 		return models.ShortURL{}, err
 	}
-	r.logger.Debug("Inserted successfully: ", url)
+	r.logger.Debug("Inserted successfully", "url", url, "request_id", logging.RequestID(ctx))
 	return insertedURL, nil
 }
 
 // InsertMany inserts urls if not exists, if exists returns existing url object
 func (r *Repository) InsertMany(ctx context.Context, urlsToInsert []models.ShortURL) ([]models.ShortURL, error) {
+	ctx, end := r.observe(ctx, "insert_many")
+	defer end()
 	if err := r.storage.InsertURLMany(ctx, urlsToInsert); err != nil {
 		return nil, err
 	}
@@ -76,6 +144,8 @@ func (r *Repository) InsertMany(ctx context.Context, urlsToInsert []models.Short
 }
 
 func (r *Repository) GetURLByID(ctx context.Context, id string) (models.ShortURL, error) {
+	ctx, end := r.observe(ctx, "get_url_by_id")
+	defer end()
 	url, ok := r.storage.GetURLByID(ctx, id)
 	if !ok {
 		return models.ShortURL{}, fmt.Errorf("id not found")
@@ -84,16 +154,266 @@ func (r *Repository) GetURLByID(ctx context.Context, id string) (models.ShortURL
 }
 
 func (r *Repository) GetURLsByUUID(ctx context.Context, uuid string) ([]models.ShortURL, error) {
+	ctx, end := r.observe(ctx, "get_urls_by_uuid")
+	defer end()
 	return r.storage.GetURLsByUUID(ctx, uuid)
 }
 
 func (r *Repository) TagURLsDeleted(urlsToDelete []models.Deletion) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
+	ctx, end := r.observe(ctx, "tag_urls_deleted")
+	defer end()
 	return r.storage.TagURLsDeleted(ctx, urlsToDelete)
 }
 
+func (r *Repository) InsertAPIKey(ctx context.Context, key models.APIKey) error {
+	ctx, end := r.observe(ctx, "insert_api_key")
+	defer end()
+	if err := r.storage.InsertAPIKey(ctx, key); err != nil {
+		r.logger.Error("storage error", "error", err, "request_id", logging.RequestID(ctx))
+		return err
+	}
+	return nil
+}
+
+func (r *Repository) GetAPIKeyByHash(ctx context.Context, hash string) (models.APIKey, error) {
+	ctx, end := r.observe(ctx, "get_api_key_by_hash")
+	defer end()
+	key, ok, err := r.storage.GetAPIKeyByHash(ctx, hash)
+	if err != nil {
+		r.logger.Error("storage error", "error", err, "request_id", logging.RequestID(ctx))
+		return models.APIKey{}, err
+	}
+	if !ok {
+		return models.APIKey{}, ErrNotFound
+	}
+	return key, nil
+}
+
+func (r *Repository) GetAPIKeysByUUID(ctx context.Context, uuid string) ([]models.APIKey, error) {
+	ctx, end := r.observe(ctx, "get_api_keys_by_uuid")
+	defer end()
+	return r.storage.GetAPIKeysByUUID(ctx, uuid)
+}
+
+func (r *Repository) RevokeAPIKey(ctx context.Context, id, uuid string) error {
+	ctx, end := r.observe(ctx, "revoke_api_key")
+	defer end()
+	err := r.storage.RevokeAPIKey(ctx, id, uuid)
+	if errors.Is(err, storages.ErrNotFound) {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (r *Repository) InsertUser(ctx context.Context, user models.User) error {
+	ctx, end := r.observe(ctx, "insert_user")
+	defer end()
+	err := r.storage.InsertUser(ctx, user)
+	if errors.Is(err, storages.ErrEntityAlreadyExist) {
+		return ErrEntityAlreadyExist
+	}
+	if err != nil {
+		r.logger.Error("storage error", "error", err, "request_id", logging.RequestID(ctx))
+		return err
+	}
+	return nil
+}
+
+func (r *Repository) GetUserByID(ctx context.Context, id string) (models.User, error) {
+	ctx, end := r.observe(ctx, "get_user_by_id")
+	defer end()
+	user, ok := r.storage.GetUserByID(ctx, id)
+	if !ok {
+		return models.User{}, ErrNotFound
+	}
+	return user, nil
+}
+
+func (r *Repository) GetUserByLogin(ctx context.Context, login string) (models.User, error) {
+	ctx, end := r.observe(ctx, "get_user_by_login")
+	defer end()
+	user, ok := r.storage.GetUserByLogin(ctx, login)
+	if !ok {
+		return models.User{}, ErrNotFound
+	}
+	return user, nil
+}
+
+func (r *Repository) GetUserByExternalID(ctx context.Context, externalID string) (models.User, error) {
+	ctx, end := r.observe(ctx, "get_user_by_external_id")
+	defer end()
+	user, ok := r.storage.GetUserByExternalID(ctx, externalID)
+	if !ok {
+		return models.User{}, ErrNotFound
+	}
+	return user, nil
+}
+
+// ReassignURLs moves all links of one uuid to another and returns number of moved links
+func (r *Repository) ReassignURLs(ctx context.Context, fromUUID, toUUID string) (int, error) {
+	ctx, end := r.observe(ctx, "reassign_urls")
+	defer end()
+	n, err := r.storage.ReassignURLs(ctx, fromUUID, toUUID)
+	if err != nil {
+		r.logger.Error("storage error", "error", err, "request_id", logging.RequestID(ctx))
+		return 0, err
+	}
+	r.logger.Debug("Reassigned urls", "from", fromUUID, "to", toUUID, "count", n, "request_id", logging.RequestID(ctx))
+	return n, nil
+}
+
+// TagURLsDeletedByID deletes links regardless of owner
+func (r *Repository) TagURLsDeletedByID(ctx context.Context, ids []string) error {
+	ctx, end := r.observe(ctx, "tag_urls_deleted_by_id")
+	defer end()
+	if err := r.storage.TagURLsDeletedByID(ctx, ids); err != nil {
+		r.logger.Error("storage error", "error", err, "request_id", logging.RequestID(ctx))
+		return err
+	}
+	return nil
+}
+
+func (r *Repository) SetURLsDisabled(ctx context.Context, ids []string, disabled bool) error {
+	ctx, end := r.observe(ctx, "set_urls_disabled")
+	defer end()
+	if err := r.storage.SetURLsDisabled(ctx, ids, disabled); err != nil {
+		r.logger.Error("storage error", "error", err, "request_id", logging.RequestID(ctx))
+		return err
+	}
+	return nil
+}
+
+func (r *Repository) SetURLRules(ctx context.Context, id string, rules []models.RedirectRule) error {
+	ctx, end := r.observe(ctx, "set_url_rules")
+	defer end()
+	if err := r.storage.SetURLRules(ctx, id, rules); err != nil {
+		r.logger.Error("storage error", "error", err, "request_id", logging.RequestID(ctx))
+		return err
+	}
+	return nil
+}
+
+func (r *Repository) SearchURLs(ctx context.Context, filter models.URLFilter) ([]models.ShortURL, error) {
+	ctx, end := r.observe(ctx, "search_urls")
+	defer end()
+	return r.storage.SearchURLs(ctx, filter)
+}
+
+func (r *Repository) ListUsers(ctx context.Context, query string, limit, offset int) ([]models.User, error) {
+	ctx, end := r.observe(ctx, "list_users")
+	defer end()
+	return r.storage.ListUsers(ctx, query, limit, offset)
+}
+
+func (r *Repository) Stats(ctx context.Context) (models.Stats, error) {
+	ctx, end := r.observe(ctx, "stats")
+	defer end()
+	return r.storage.Stats(ctx)
+}
+
+func (r *Repository) InsertSession(ctx context.Context, session models.Session) error {
+	ctx, end := r.observe(ctx, "insert_session")
+	defer end()
+	if err := r.storage.InsertSession(ctx, session); err != nil {
+		r.logger.Error("storage error", "error", err, "request_id", logging.RequestID(ctx))
+		return err
+	}
+	return nil
+}
+
+func (r *Repository) GetSessionsByUUID(ctx context.Context, uuid string) ([]models.Session, error) {
+	ctx, end := r.observe(ctx, "get_sessions_by_uuid")
+	defer end()
+	return r.storage.GetSessionsByUUID(ctx, uuid)
+}
+
+func (r *Repository) RevokeSession(ctx context.Context, session models.Session) error {
+	ctx, end := r.observe(ctx, "revoke_session")
+	defer end()
+	if err := r.storage.RevokeSession(ctx, session); err != nil {
+		r.logger.Error("storage error", "error", err, "request_id", logging.RequestID(ctx))
+		return err
+	}
+	return nil
+}
+
+func (r *Repository) GetRevokedSessions(ctx context.Context, expiresAfter time.Time) ([]models.Session, error) {
+	ctx, end := r.observe(ctx, "get_revoked_sessions")
+	defer end()
+	return r.storage.GetRevokedSessions(ctx, expiresAfter)
+}
+
+func (r *Repository) DeleteExpiredSessions(ctx context.Context, expiredBefore time.Time) (int, error) {
+	ctx, end := r.observe(ctx, "delete_expired_sessions")
+	defer end()
+	return r.storage.DeleteExpiredSessions(ctx, expiredBefore)
+}
+
+func (r *Repository) InsertOrganization(ctx context.Context, org models.Organization) error {
+	ctx, end := r.observe(ctx, "insert_organization")
+	defer end()
+	if err := r.storage.InsertOrganization(ctx, org); err != nil {
+		r.logger.Error("storage error", "error", err, "request_id", logging.RequestID(ctx))
+		return err
+	}
+	return nil
+}
+
+func (r *Repository) GetOrganization(ctx context.Context, id string) (models.Organization, error) {
+	ctx, end := r.observe(ctx, "get_organization")
+	defer end()
+	org, ok := r.storage.GetOrganization(ctx, id)
+	if !ok {
+		return models.Organization{}, ErrNotFound
+	}
+	return org, nil
+}
+
+func (r *Repository) SetMembership(ctx context.Context, m models.Membership) error {
+	ctx, end := r.observe(ctx, "set_membership")
+	defer end()
+	if err := r.storage.SetMembership(ctx, m); err != nil {
+		r.logger.Error("storage error", "error", err, "request_id", logging.RequestID(ctx))
+		return err
+	}
+	return nil
+}
+
+func (r *Repository) GetMembershipsByUUID(ctx context.Context, uuid string) ([]models.Membership, error) {
+	ctx, end := r.observe(ctx, "get_memberships_by_uuid")
+	defer end()
+	return r.storage.GetMembershipsByUUID(ctx, uuid)
+}
+
+func (r *Repository) GetOrgMembers(ctx context.Context, orgID string) ([]models.Membership, error) {
+	ctx, end := r.observe(ctx, "get_org_members")
+	defer end()
+	return r.storage.GetOrgMembers(ctx, orgID)
+}
+
+func (r *Repository) GetURLsByOrg(ctx context.Context, orgID string) ([]models.ShortURL, error) {
+	ctx, end := r.observe(ctx, "get_urls_by_org")
+	defer end()
+	return r.storage.GetURLsByOrg(ctx, orgID)
+}
+
+// SetURLsOrg moves links created by the user to organization and returns number of moved links
+func (r *Repository) SetURLsOrg(ctx context.Context, ids []string, uuid, orgID string) (int, error) {
+	ctx, end := r.observe(ctx, "set_urls_org")
+	defer end()
+	n, err := r.storage.SetURLsOrg(ctx, ids, uuid, orgID)
+	if err != nil {
+		r.logger.Error("storage error", "error", err, "request_id", logging.RequestID(ctx))
+		return 0, err
+	}
+	return n, nil
+}
+
 func (r *Repository) Ping() error {
+	_, end := r.observe(context.Background(), "ping")
+	defer end()
 	err := r.storage.Ping()
 	if err != nil {
 		r.logger.Error(err.Error())
@@ -7,10 +7,10 @@ import (
 
 // NewStorage is a factory function which creating a instance of a storage object of
 // significant type and returns it as an Storage interface
-func NewStorage(config configs.Config) (Storage, error) {
+func NewStorage(config configs.Config, logger logger) (Storage, error) {
 	switch {
 	case config.ShouldUsePostgres():
-		postgres, err := storages.NewPostgresql(config.PostgresConn())
+		postgres, err := storages.NewPostgresql(config.PostgresConn(), logger)
 		if err != nil {
 			return nil, err
 		}
@@ -27,3 +27,15 @@ func NewStorage(config configs.Config) (Storage, error) {
 		return storages.NewMemoryStorage(), nil
 	}
 }
+
+// StorageBackend returns name of the storage NewStorage creates for the config
+func StorageBackend(config configs.Config) string {
+	switch {
+	case config.ShouldUsePostgres():
+		return "postgres"
+	case config.ShouldSaveToFile():
+		return "file"
+	default:
+		return "memory"
+	}
+}
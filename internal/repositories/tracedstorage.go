@@ -0,0 +1,242 @@
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/maxzhirnov/urlshort/internal/models"
+)
+
+var storageTracer = otel.Tracer("github.com/maxzhirnov/urlshort/internal/storages")
+
+// tracedStorage wraps every operation of a storage in a span named like "postgres.GetURLByID"
+type tracedStorage struct {
+	storage Storage
+	backend string
+}
+
+// NewTracedStorage returns storage which traces operations of the given one, backend names the storage in spans
+func NewTracedStorage(storage Storage, backend string) Storage {
+	return tracedStorage{storage: storage, backend: backend}
+}
+
+func (s tracedStorage) startSpan(ctx context.Context, operation string) (context.Context, trace.Span) {
+	return storageTracer.Start(ctx, s.backend+"."+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("storage.backend", s.backend)),
+	)
+}
+
+func (s tracedStorage) InsertURL(ctx context.Context, url models.ShortURL) (models.ShortURL, error) {
+	ctx, span := s.startSpan(ctx, "InsertURL")
+	defer span.End()
+	return s.storage.InsertURL(ctx, url)
+}
+
+func (s tracedStorage) InsertURLMany(ctx context.Context, urls []models.ShortURL) error {
+	ctx, span := s.startSpan(ctx, "InsertURLMany")
+	defer span.End()
+	return s.storage.InsertURLMany(ctx, urls)
+}
+
+func (s tracedStorage) GetURLByID(ctx context.Context, id string) (models.ShortURL, bool) {
+	ctx, span := s.startSpan(ctx, "GetURLByID")
+	defer span.End()
+	return s.storage.GetURLByID(ctx, id)
+}
+
+func (s tracedStorage) GetURLByOriginalURL(ctx context.Context, url string) (models.ShortURL, bool) {
+	ctx, span := s.startSpan(ctx, "GetURLByOriginalURL")
+	defer span.End()
+	return s.storage.GetURLByOriginalURL(ctx, url)
+}
+
+func (s tracedStorage) GetURLsByUUID(ctx context.Context, uuid string) ([]models.ShortURL, error) {
+	ctx, span := s.startSpan(ctx, "GetURLsByUUID")
+	defer span.End()
+	return s.storage.GetURLsByUUID(ctx, uuid)
+}
+
+func (s tracedStorage) TagURLsDeleted(ctx context.Context, deletions []models.Deletion) error {
+	ctx, span := s.startSpan(ctx, "TagURLsDeleted")
+	defer span.End()
+	return s.storage.TagURLsDeleted(ctx, deletions)
+}
+
+func (s tracedStorage) InsertAPIKey(ctx context.Context, key models.APIKey) error {
+	ctx, span := s.startSpan(ctx, "InsertAPIKey")
+	defer span.End()
+	return s.storage.InsertAPIKey(ctx, key)
+}
+
+func (s tracedStorage) GetAPIKeyByHash(ctx context.Context, hash string) (models.APIKey, bool, error) {
+	ctx, span := s.startSpan(ctx, "GetAPIKeyByHash")
+	defer span.End()
+	return s.storage.GetAPIKeyByHash(ctx, hash)
+}
+
+func (s tracedStorage) GetAPIKeysByUUID(ctx context.Context, uuid string) ([]models.APIKey, error) {
+	ctx, span := s.startSpan(ctx, "GetAPIKeysByUUID")
+	defer span.End()
+	return s.storage.GetAPIKeysByUUID(ctx, uuid)
+}
+
+func (s tracedStorage) RevokeAPIKey(ctx context.Context, id, uuid string) error {
+	ctx, span := s.startSpan(ctx, "RevokeAPIKey")
+	defer span.End()
+	return s.storage.RevokeAPIKey(ctx, id, uuid)
+}
+
+func (s tracedStorage) InsertUser(ctx context.Context, user models.User) error {
+	ctx, span := s.startSpan(ctx, "InsertUser")
+	defer span.End()
+	return s.storage.InsertUser(ctx, user)
+}
+
+func (s tracedStorage) GetUserByID(ctx context.Context, id string) (models.User, bool) {
+	ctx, span := s.startSpan(ctx, "GetUserByID")
+	defer span.End()
+	return s.storage.GetUserByID(ctx, id)
+}
+
+func (s tracedStorage) GetUserByLogin(ctx context.Context, login string) (models.User, bool) {
+	ctx, span := s.startSpan(ctx, "GetUserByLogin")
+	defer span.End()
+	return s.storage.GetUserByLogin(ctx, login)
+}
+
+func (s tracedStorage) GetUserByExternalID(ctx context.Context, externalID string) (models.User, bool) {
+	ctx, span := s.startSpan(ctx, "GetUserByExternalID")
+	defer span.End()
+	return s.storage.GetUserByExternalID(ctx, externalID)
+}
+
+func (s tracedStorage) ReassignURLs(ctx context.Context, fromUUID, toUUID string) (int, error) {
+	ctx, span := s.startSpan(ctx, "ReassignURLs")
+	defer span.End()
+	return s.storage.ReassignURLs(ctx, fromUUID, toUUID)
+}
+
+func (s tracedStorage) TagURLsDeletedByID(ctx context.Context, ids []string) error {
+	ctx, span := s.startSpan(ctx, "TagURLsDeletedByID")
+	defer span.End()
+	return s.storage.TagURLsDeletedByID(ctx, ids)
+}
+
+func (s tracedStorage) SetURLsDisabled(ctx context.Context, ids []string, disabled bool) error {
+	ctx, span := s.startSpan(ctx, "SetURLsDisabled")
+	defer span.End()
+	return s.storage.SetURLsDisabled(ctx, ids, disabled)
+}
+
+func (s tracedStorage) SetURLRules(ctx context.Context, id string, rules []models.RedirectRule) error {
+	ctx, span := s.startSpan(ctx, "SetURLRules")
+	defer span.End()
+	return s.storage.SetURLRules(ctx, id, rules)
+}
+
+func (s tracedStorage) SearchURLs(ctx context.Context, filter models.URLFilter) ([]models.ShortURL, error) {
+	ctx, span := s.startSpan(ctx, "SearchURLs")
+	defer span.End()
+	return s.storage.SearchURLs(ctx, filter)
+}
+
+func (s tracedStorage) ListUsers(ctx context.Context, query string, limit, offset int) ([]models.User, error) {
+	ctx, span := s.startSpan(ctx, "ListUsers")
+	defer span.End()
+	return s.storage.ListUsers(ctx, query, limit, offset)
+}
+
+func (s tracedStorage) Stats(ctx context.Context) (models.Stats, error) {
+	ctx, span := s.startSpan(ctx, "Stats")
+	defer span.End()
+	return s.storage.Stats(ctx)
+}
+
+func (s tracedStorage) InsertSession(ctx context.Context, session models.Session) error {
+	ctx, span := s.startSpan(ctx, "InsertSession")
+	defer span.End()
+	return s.storage.InsertSession(ctx, session)
+}
+
+func (s tracedStorage) GetSessionsByUUID(ctx context.Context, uuid string) ([]models.Session, error) {
+	ctx, span := s.startSpan(ctx, "GetSessionsByUUID")
+	defer span.End()
+	return s.storage.GetSessionsByUUID(ctx, uuid)
+}
+
+func (s tracedStorage) RevokeSession(ctx context.Context, session models.Session) error {
+	ctx, span := s.startSpan(ctx, "RevokeSession")
+	defer span.End()
+	return s.storage.RevokeSession(ctx, session)
+}
+
+func (s tracedStorage) GetRevokedSessions(ctx context.Context, expiresAfter time.Time) ([]models.Session, error) {
+	ctx, span := s.startSpan(ctx, "GetRevokedSessions")
+	defer span.End()
+	return s.storage.GetRevokedSessions(ctx, expiresAfter)
+}
+
+func (s tracedStorage) DeleteExpiredSessions(ctx context.Context, expiredBefore time.Time) (int, error) {
+	ctx, span := s.startSpan(ctx, "DeleteExpiredSessions")
+	defer span.End()
+	return s.storage.DeleteExpiredSessions(ctx, expiredBefore)
+}
+
+func (s tracedStorage) InsertOrganization(ctx context.Context, org models.Organization) error {
+	ctx, span := s.startSpan(ctx, "InsertOrganization")
+	defer span.End()
+	return s.storage.InsertOrganization(ctx, org)
+}
+
+func (s tracedStorage) GetOrganization(ctx context.Context, id string) (models.Organization, bool) {
+	ctx, span := s.startSpan(ctx, "GetOrganization")
+	defer span.End()
+	return s.storage.GetOrganization(ctx, id)
+}
+
+func (s tracedStorage) SetMembership(ctx context.Context, membership models.Membership) error {
+	ctx, span := s.startSpan(ctx, "SetMembership")
+	defer span.End()
+	return s.storage.SetMembership(ctx, membership)
+}
+
+func (s tracedStorage) GetMembershipsByUUID(ctx context.Context, uuid string) ([]models.Membership, error) {
+	ctx, span := s.startSpan(ctx, "GetMembershipsByUUID")
+	defer span.End()
+	return s.storage.GetMembershipsByUUID(ctx, uuid)
+}
+
+func (s tracedStorage) GetOrgMembers(ctx context.Context, orgID string) ([]models.Membership, error) {
+	ctx, span := s.startSpan(ctx, "GetOrgMembers")
+	defer span.End()
+	return s.storage.GetOrgMembers(ctx, orgID)
+}
+
+func (s tracedStorage) GetURLsByOrg(ctx context.Context, orgID string) ([]models.ShortURL, error) {
+	ctx, span := s.startSpan(ctx, "GetURLsByOrg")
+	defer span.End()
+	return s.storage.GetURLsByOrg(ctx, orgID)
+}
+
+func (s tracedStorage) SetURLsOrg(ctx context.Context, ids []string, uuid, orgID string) (int, error) {
+	ctx, span := s.startSpan(ctx, "SetURLsOrg")
+	defer span.End()
+	return s.storage.SetURLsOrg(ctx, ids, uuid, orgID)
+}
+
+func (s tracedStorage) Bootstrap() error {
+	return s.storage.Bootstrap()
+}
+
+func (s tracedStorage) Close() error {
+	return s.storage.Close()
+}
+
+func (s tracedStorage) Ping() error {
+	return s.storage.Ping()
+}
@@ -0,0 +1,160 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/maxzhirnov/urlshort/internal/models"
+	"github.com/maxzhirnov/urlshort/internal/repositories"
+)
+
+const (
+	minPasswordLen = 8
+	maxPasswordLen = 72 // bcrypt ignores everything after 72 bytes
+	maxLoginLen    = 100
+	// externalLoginSeparator is used in logins of external accounts,
+	// local logins can't contain it so they never take login of an external account
+	externalLoginSeparator = "|"
+	externalLoginPrefix    = "oidc"
+
+	dummyPasswordHash = "$2a$10$Eg/G2zomblNRoqhKn04oEudmUbtWedsZXpdygh8ilvY8rXrXLmDwC"
+)
+
+var (
+	ErrInvalidCredentials = errors.New("invalid login or password")
+	ErrLoginTaken         = errors.New("login is already taken")
+	ErrInvalidLogin       = errors.New("login should be non-empty, at most 100 characters and shouldn't contain |")
+	ErrInvalidPassword    = errors.New("password should be from 8 to 72 characters")
+)
+
+// SignUp registers new account, links of anonymous user currentUUID are moved to
+// the account if mergeFrom is not empty
+func (us *URLShortener) SignUp(ctx context.Context, login, password, mergeFrom string) (models.User, int, error) {
+	ctx, span := tracer.Start(ctx, "URLShortener.SignUp")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	login = strings.TrimSpace(login)
+	if login == "" || len(login) > maxLoginLen || strings.Contains(login, externalLoginSeparator) {
+		return models.User{}, 0, ErrInvalidLogin
+	}
+	if len(password) < minPasswordLen || len(password) > maxPasswordLen {
+		return models.User{}, 0, ErrInvalidPassword
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return models.User{}, 0, err
+	}
+
+	user := models.User{
+		ID:           uuid.New().String(),
+		Login:        login,
+		PasswordHash: string(hash),
+		CreatedAt:    time.Now().UTC(),
+	}
+	err = us.Repo.InsertUser(ctx, user)
+	if errors.Is(err, repositories.ErrEntityAlreadyExist) {
+		return models.User{}, 0, ErrLoginTaken
+	}
+	if err != nil {
+		return models.User{}, 0, err
+	}
+
+	merged, err := us.mergeAnonymousURLs(ctx, mergeFrom, user.ID)
+	if err != nil {
+		return user, 0, err
+	}
+	return user, merged, nil
+}
+
+// Login checks credentials and moves links of anonymous user mergeFrom to the account if it is not empty
+func (us *URLShortener) Login(ctx context.Context, login, password, mergeFrom string) (models.User, int, error) {
+	ctx, span := tracer.Start(ctx, "URLShortener.Login")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	user, err := us.Repo.GetUserByLogin(ctx, strings.TrimSpace(login))
+	if errors.Is(err, repositories.ErrNotFound) {
+		// Сравниваем с фиктивным хешем, чтобы время ответа не выдавало существование логина
+		_ = bcrypt.CompareHashAndPassword([]byte(dummyPasswordHash), []byte(password))
+		return models.User{}, 0, ErrInvalidCredentials
+	}
+	if err != nil {
+		return models.User{}, 0, err
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
+		return models.User{}, 0, ErrInvalidCredentials
+	}
+
+	merged, err := us.mergeAnonymousURLs(ctx, mergeFrom, user.ID)
+	if err != nil {
+		return user, 0, err
+	}
+	return user, merged, nil
+}
+
+// LoginExternal finds account authenticated by external identity provider or creates
+// a new one, login of the new account is the external id which contains | so it can't clash with local logins
+func (us *URLShortener) LoginExternal(ctx context.Context, externalID, mergeFrom string) (models.User, int, error) {
+	ctx, span := tracer.Start(ctx, "URLShortener.LoginExternal")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if externalID == "" {
+		return models.User{}, 0, errors.New("externalID shouldn't be empty string")
+	}
+
+	user, err := us.Repo.GetUserByExternalID(ctx, externalID)
+	if errors.Is(err, repositories.ErrNotFound) {
+		user = models.User{
+			ID:         uuid.New().String(),
+			Login:      externalLogin(externalID),
+			ExternalID: externalID,
+			CreatedAt:  time.Now().UTC(),
+		}
+		err = us.Repo.InsertUser(ctx, user)
+	}
+	if err != nil {
+		return models.User{}, 0, err
+	}
+
+	merged, err := us.mergeAnonymousURLs(ctx, mergeFrom, user.ID)
+	if err != nil {
+		return user, 0, err
+	}
+	return user, merged, nil
+}
+
+// externalLogin derives login of an external account from its id, the id made of issuer and subject
+// may be longer than logins are allowed to be so it is kept in ExternalID only
+func externalLogin(externalID string) string {
+	sum := sha256.Sum256([]byte(externalID))
+	return externalLoginPrefix + externalLoginSeparator + hex.EncodeToString(sum[:])
+}
+
+// mergeAnonymousURLs moves links only from anonymous uuids, links of another
+// registered account are never taken over
+func (us *URLShortener) mergeAnonymousURLs(ctx context.Context, fromUUID, toUUID string) (int, error) {
+	if fromUUID == "" || fromUUID == toUUID {
+		return 0, nil
+	}
+	_, err := us.Repo.GetUserByID(ctx, fromUUID)
+	if err == nil {
+		return 0, nil
+	}
+	if !errors.Is(err, repositories.ErrNotFound) {
+		return 0, err
+	}
+	return us.Repo.ReassignURLs(ctx, fromUUID, toUUID)
+}
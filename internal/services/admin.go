@@ -0,0 +1,109 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/maxzhirnov/urlshort/internal/models"
+	"github.com/maxzhirnov/urlshort/internal/repositories"
+)
+
+const maxSearchLimit = 1000
+
+// UserInfo is everything admin can see about a user, anonymous users have no account
+type UserInfo struct {
+	ID         string
+	Registered bool
+	Account    models.User
+	URLs       []models.ShortURL
+	APIKeys    []models.APIKey
+}
+
+func (us *URLShortener) SearchURLs(ctx context.Context, filter models.URLFilter) ([]models.ShortURL, error) {
+	ctx, span := tracer.Start(ctx, "URLShortener.SearchURLs")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if filter.Limit <= 0 || filter.Limit > maxSearchLimit {
+		filter.Limit = maxSearchLimit
+	}
+	if filter.Offset < 0 {
+		filter.Offset = 0
+	}
+	return us.Repo.SearchURLs(ctx, filter)
+}
+
+// AdminDeleteURLs tags links deleted right away regardless of owner
+func (us *URLShortener) AdminDeleteURLs(ctx context.Context, ids []string) error {
+	ctx, span := tracer.Start(ctx, "URLShortener.AdminDeleteURLs")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if len(ids) == 0 {
+		return nil
+	}
+	return us.Repo.TagURLsDeletedByID(ctx, ids)
+}
+
+func (us *URLShortener) SetURLsDisabled(ctx context.Context, ids []string, disabled bool) error {
+	ctx, span := tracer.Start(ctx, "URLShortener.SetURLsDisabled")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if len(ids) == 0 {
+		return nil
+	}
+	return us.Repo.SetURLsDisabled(ctx, ids, disabled)
+}
+
+func (us *URLShortener) ListUsers(ctx context.Context, query string, limit, offset int) ([]models.User, error) {
+	ctx, span := tracer.Start(ctx, "URLShortener.ListUsers")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if limit <= 0 || limit > maxSearchLimit {
+		limit = maxSearchLimit
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	return us.Repo.ListUsers(ctx, query, limit, offset)
+}
+
+func (us *URLShortener) InspectUser(ctx context.Context, id string) (UserInfo, error) {
+	ctx, span := tracer.Start(ctx, "URLShortener.InspectUser")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	info := UserInfo{ID: id}
+	account, err := us.Repo.GetUserByID(ctx, id)
+	switch {
+	case err == nil:
+		info.Registered = true
+		info.Account = account
+	case !errors.Is(err, repositories.ErrNotFound):
+		return UserInfo{}, err
+	}
+
+	if info.URLs, err = us.Repo.GetURLsByUUID(ctx, id); err != nil {
+		return UserInfo{}, err
+	}
+	if info.APIKeys, err = us.Repo.GetAPIKeysByUUID(ctx, id); err != nil {
+		return UserInfo{}, err
+	}
+
+	if !info.Registered && len(info.URLs) == 0 && len(info.APIKeys) == 0 {
+		return UserInfo{}, ErrNotFound
+	}
+	return info, nil
+}
+
+func (us *URLShortener) Stats(ctx context.Context) (models.Stats, error) {
+	ctx, span := tracer.Start(ctx, "URLShortener.Stats")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	return us.Repo.Stats(ctx)
+}
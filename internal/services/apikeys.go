@@ -0,0 +1,85 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/maxzhirnov/urlshort/internal/auth"
+	"github.com/maxzhirnov/urlshort/internal/models"
+	"github.com/maxzhirnov/urlshort/internal/repositories"
+)
+
+var (
+	ErrNotFound      = errors.New("entity not found")
+	ErrInvalidAPIKey = errors.New("invalid api key")
+	// ErrAPIKeyUnavailable means the key couldn't be checked because storage failed
+	ErrAPIKeyUnavailable = errors.New("couldn't check api key, try again later")
+)
+
+// CreateAPIKey issues new api key for the user, the plain key is returned only once
+func (us *URLShortener) CreateAPIKey(ctx context.Context, uuid, name string) (models.APIKey, string, error) {
+	ctx, span := tracer.Start(ctx, "URLShortener.CreateAPIKey")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	if uuid == "" {
+		return models.APIKey{}, "", errors.New("uuid shouldn't be empty string")
+	}
+
+	plainKey, id, err := auth.GenerateAPIKey()
+	if err != nil {
+		return models.APIKey{}, "", err
+	}
+
+	key := models.APIKey{
+		ID:        id,
+		UUID:      uuid,
+		Name:      name,
+		Prefix:    auth.APIKeyPrefix + id,
+		Hash:      auth.HashAPIKey(plainKey),
+		CreatedAt: time.Now().UTC(),
+	}
+	if err := us.Repo.InsertAPIKey(ctx, key); err != nil {
+		return models.APIKey{}, "", err
+	}
+
+	return key, plainKey, nil
+}
+
+func (us *URLShortener) GetAPIKeys(ctx context.Context, uuid string) ([]models.APIKey, error) {
+	ctx, span := tracer.Start(ctx, "URLShortener.GetAPIKeys")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	return us.Repo.GetAPIKeysByUUID(ctx, uuid)
+}
+
+func (us *URLShortener) RevokeAPIKey(ctx context.Context, id, uuid string) error {
+	ctx, span := tracer.Start(ctx, "URLShortener.RevokeAPIKey")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	err := us.Repo.RevokeAPIKey(ctx, id, uuid)
+	if errors.Is(err, repositories.ErrNotFound) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// ResolveAPIKey returns uuid of the owner of not revoked api key
+func (us *URLShortener) ResolveAPIKey(ctx context.Context, plainKey string) (string, error) {
+	ctx, span := tracer.Start(ctx, "URLShortener.ResolveAPIKey")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	key, err := us.Repo.GetAPIKeyByHash(ctx, auth.HashAPIKey(plainKey))
+	if errors.Is(err, repositories.ErrNotFound) || (err == nil && key.Revoked) {
+		return "", ErrInvalidAPIKey
+	}
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrAPIKeyUnavailable, err)
+	}
+	return key.UUID, nil
+}
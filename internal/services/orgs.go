@@ -0,0 +1,262 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/maxzhirnov/urlshort/internal/models"
+	"github.com/maxzhirnov/urlshort/internal/repositories"
+)
+
+const maxOrgNameLen = 100
+
+var (
+	ErrForbidden      = errors.New("not enough permissions")
+	ErrInvalidOrgName = errors.New("organization name should be non-empty and at most 100 characters")
+	ErrInvalidRole    = errors.New("role should be one of viewer, editor, owner")
+	ErrInvalidUUID    = errors.New("invalid user id")
+	ErrLastOwner      = errors.New("organization should have at least one owner")
+)
+
+// UserOrg is an organization together with the role of the user in it
+type UserOrg struct {
+	models.Organization
+	Role models.OrgRole
+}
+
+// CreateOrganization creates organization and makes the user its owner
+func (us *URLShortener) CreateOrganization(ctx context.Context, name, ownerUUID string) (models.Organization, error) {
+	ctx, span := tracer.Start(ctx, "URLShortener.CreateOrganization")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > maxOrgNameLen {
+		return models.Organization{}, ErrInvalidOrgName
+	}
+
+	org := models.Organization{
+		ID:        uuid.New().String(),
+		Name:      name,
+		CreatedAt: time.Now().UTC(),
+	}
+	if err := us.Repo.InsertOrganization(ctx, org); err != nil {
+		return models.Organization{}, err
+	}
+	owner := models.Membership{OrgID: org.ID, UUID: ownerUUID, Role: models.RoleOwner}
+	if err := us.Repo.SetMembership(ctx, owner); err != nil {
+		return models.Organization{}, err
+	}
+	return org, nil
+}
+
+func (us *URLShortener) GetOrganizations(ctx context.Context, uuid string) ([]UserOrg, error) {
+	ctx, span := tracer.Start(ctx, "URLShortener.GetOrganizations")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	memberships, err := us.Repo.GetMembershipsByUUID(ctx, uuid)
+	if err != nil {
+		return nil, err
+	}
+
+	orgs := make([]UserOrg, 0, len(memberships))
+	for _, m := range memberships {
+		org, err := us.Repo.GetOrganization(ctx, m.OrgID)
+		if errors.Is(err, repositories.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		orgs = append(orgs, UserOrg{Organization: org, Role: m.Role})
+	}
+	return orgs, nil
+}
+
+func (us *URLShortener) GetOrgMembers(ctx context.Context, orgID, uuid string) ([]models.Membership, error) {
+	ctx, span := tracer.Start(ctx, "URLShortener.GetOrgMembers")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if err := us.requireOrgRole(ctx, orgID, uuid, models.RoleViewer); err != nil {
+		return nil, err
+	}
+	return us.Repo.GetOrgMembers(ctx, orgID)
+}
+
+// SetMemberRole adds member to organization or changes the role, only owners can manage members
+func (us *URLShortener) SetMemberRole(ctx context.Context, orgID, actorUUID, memberUUID string, role models.OrgRole) error {
+	ctx, span := tracer.Start(ctx, "URLShortener.SetMemberRole")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if !role.Valid() {
+		return ErrInvalidRole
+	}
+	if _, err := uuid.Parse(memberUUID); err != nil {
+		return ErrInvalidUUID
+	}
+	if err := us.requireOrgRole(ctx, orgID, actorUUID, models.RoleOwner); err != nil {
+		return err
+	}
+	if role != models.RoleOwner {
+		if err := us.checkNotLastOwner(ctx, orgID, memberUUID); err != nil {
+			return err
+		}
+	}
+	return us.Repo.SetMembership(ctx, models.Membership{OrgID: orgID, UUID: memberUUID, Role: role})
+}
+
+// RemoveMember removes member from organization, owners can remove anybody
+// and every member can leave the organization
+func (us *URLShortener) RemoveMember(ctx context.Context, orgID, actorUUID, memberUUID string) error {
+	ctx, span := tracer.Start(ctx, "URLShortener.RemoveMember")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	required := models.RoleOwner
+	if actorUUID == memberUUID {
+		required = models.RoleViewer
+	}
+	if err := us.requireOrgRole(ctx, orgID, actorUUID, required); err != nil {
+		return err
+	}
+	if err := us.checkNotLastOwner(ctx, orgID, memberUUID); err != nil {
+		return err
+	}
+	return us.Repo.SetMembership(ctx, models.Membership{OrgID: orgID, UUID: memberUUID})
+}
+
+func (us *URLShortener) GetOrgURLs(ctx context.Context, orgID, uuid string) ([]models.ShortURL, error) {
+	ctx, span := tracer.Start(ctx, "URLShortener.GetOrgURLs")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if err := us.requireOrgRole(ctx, orgID, uuid, models.RoleViewer); err != nil {
+		return nil, err
+	}
+	return us.Repo.GetURLsByOrg(ctx, orgID)
+}
+
+// CreateOrgURL shortens url on behalf of organization, the user stays recorded as creator
+func (us *URLShortener) CreateOrgURL(ctx context.Context, orgID, uuid, originalURL string, redirect models.RedirectOptions) (models.ShortURL, error) {
+	ctx, span := tracer.Start(ctx, "URLShortener.CreateOrgURL")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if originalURL == "" {
+		return models.ShortURL{}, errors.New("originalURL shouldn't be empty string")
+	}
+	if err := redirect.Validate(); err != nil {
+		return models.ShortURL{}, fmt.Errorf("%w: %v", ErrInvalidRedirect, err)
+	}
+	if err := us.requireOrgRole(ctx, orgID, uuid, models.RoleEditor); err != nil {
+		return models.ShortURL{}, err
+	}
+
+	insertedURL, err := us.Repo.Insert(ctx, models.ShortURL{
+		OriginalURL:     originalURL,
+		ID:              us.generateID(ctx),
+		UUID:            uuid,
+		OrgID:           orgID,
+		RedirectOptions: redirect,
+	})
+	if errors.Is(err, repositories.ErrEntityAlreadyExist) {
+		us.metrics.LinkConflict()
+		return insertedURL, ErrEntityAlreadyExist
+	}
+	if err != nil {
+		return models.ShortURL{}, err
+	}
+	us.metrics.LinksCreated(1)
+	return insertedURL, nil
+}
+
+// TransferURLs moves personal links created by the user to organization and returns number of moved links,
+// links already owned by an organization stay there
+func (us *URLShortener) TransferURLs(ctx context.Context, orgID, uuid string, ids []string) (int, error) {
+	ctx, span := tracer.Start(ctx, "URLShortener.TransferURLs")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if err := us.requireOrgRole(ctx, orgID, uuid, models.RoleEditor); err != nil {
+		return 0, err
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	return us.Repo.SetURLsOrg(ctx, ids, uuid, orgID)
+}
+
+// DeleteOrgURLs queues deletion of organization links, ids of links outside the organization
+// are skipped. Ownership is checked again on deletion
+func (us *URLShortener) DeleteOrgURLs(ctx context.Context, orgID, uuid string, ids []string) error {
+	ctx, span := tracer.Start(ctx, "URLShortener.DeleteOrgURLs")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if err := us.requireOrgRole(ctx, orgID, uuid, models.RoleEditor); err != nil {
+		return err
+	}
+	urls, err := us.Repo.GetURLsByOrg(ctx, orgID)
+	if err != nil {
+		return err
+	}
+	requested := make(map[string]struct{}, len(ids))
+	for _, id := range ids {
+		requested[id] = struct{}{}
+	}
+	orgIDs := make([]string, 0, len(ids))
+	for _, u := range urls {
+		if _, ok := requested[u.ID]; ok {
+			orgIDs = append(orgIDs, u.ID)
+		}
+	}
+	us.Delete(orgIDs, uuid)
+	return nil
+}
+
+// requireOrgRole returns ErrNotFound if the user is not a member so that
+// organizations of other users can't be discovered
+func (us *URLShortener) requireOrgRole(ctx context.Context, orgID, uuid string, required models.OrgRole) error {
+	memberships, err := us.Repo.GetMembershipsByUUID(ctx, uuid)
+	if err != nil {
+		return err
+	}
+	for _, m := range memberships {
+		if m.OrgID != orgID {
+			continue
+		}
+		if !m.Role.Allows(required) {
+			return ErrForbidden
+		}
+		return nil
+	}
+	return ErrNotFound
+}
+
+func (us *URLShortener) checkNotLastOwner(ctx context.Context, orgID, memberUUID string) error {
+	members, err := us.Repo.GetOrgMembers(ctx, orgID)
+	if err != nil {
+		return err
+	}
+	owners := 0
+	isOwner := false
+	for _, m := range members {
+		if m.Role == models.RoleOwner {
+			owners++
+			isOwner = isOwner || m.UUID == memberUUID
+		}
+	}
+	if isOwner && owners == 1 {
+		return ErrLastOwner
+	}
+	return nil
+}
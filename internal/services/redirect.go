@@ -0,0 +1,92 @@
+package services
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/maxzhirnov/urlshort/internal/models"
+)
+
+// RedirectTarget builds url the short link redirects to. Trailing path and raw query of the
+// short link request are merged into the original url according to path and query modes,
+// empty modes mean ignore. Trailing path with . or .. segments isn't passed, otherwise it could
+// leave the path of the original url. Original url which can't be parsed is returned as is
+func RedirectTarget(originalURL string, opts models.RedirectOptions, rest, rawQuery string) string {
+	target := EnsureURLScheme(originalURL)
+	trailingSlash := strings.HasSuffix(rest, "/")
+	rest = strings.Trim(rest, "/")
+	passPath := rest != "" && !hasDotSegment(rest) &&
+		(opts.PathMode == models.PassthroughAppend || opts.PathMode == models.PassthroughOverride)
+	passQuery := rawQuery != "" && (opts.QueryMode == models.PassthroughAppend || opts.QueryMode == models.PassthroughOverride)
+	if !passPath && !passQuery {
+		return target
+	}
+
+	u, err := url.Parse(target)
+	if err != nil {
+		return target
+	}
+
+	if passPath {
+		// Сегменты экранируются, иначе %2F или ? из пути изменили бы структуру url
+		segments := strings.Split(rest, "/")
+		for i, s := range segments {
+			segments[i] = url.PathEscape(s)
+		}
+		if trailingSlash {
+			segments[len(segments)-1] += "/"
+		}
+		if opts.PathMode == models.PassthroughOverride {
+			u.Path, u.RawPath = "/", ""
+		}
+		u = u.JoinPath(segments...)
+	}
+
+	if passQuery {
+		query := u.RawQuery
+		if opts.QueryMode == models.PassthroughOverride {
+			incoming, _ := url.ParseQuery(rawQuery)
+			query = withoutParams(query, incoming)
+		}
+		u.RawQuery = joinQuery(query, rawQuery)
+	}
+	return u.String()
+}
+
+func hasDotSegment(path string) bool {
+	for _, s := range strings.Split(path, "/") {
+		if s == "." || s == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+// withoutParams removes parameters present in params from raw query keeping order of the rest
+func withoutParams(rawQuery string, params url.Values) string {
+	if rawQuery == "" {
+		return ""
+	}
+	kept := make([]string, 0)
+	for _, pair := range strings.Split(rawQuery, "&") {
+		key, _, _ := strings.Cut(pair, "=")
+		if unescaped, err := url.QueryUnescape(key); err == nil {
+			key = unescaped
+		}
+		if _, ok := params[key]; !ok {
+			kept = append(kept, pair)
+		}
+	}
+	return strings.Join(kept, "&")
+}
+
+func joinQuery(a, b string) string {
+	switch {
+	case a == "":
+		return b
+	case b == "":
+		return a
+	default:
+		return a + "&" + b
+	}
+}
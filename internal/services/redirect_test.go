@@ -0,0 +1,111 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/maxzhirnov/urlshort/internal/models"
+)
+
+func TestRedirectTarget(t *testing.T) {
+	tests := []struct {
+		name        string
+		originalURL string
+		queryMode   models.PassthroughMode
+		pathMode    models.PassthroughMode
+		rest        string
+		rawQuery    string
+		want        string
+	}{
+		{
+			name:        "no passthrough by default",
+			originalURL: "example.com/landing?ref=short",
+			rest:        "/deep/path",
+			rawQuery:    "utm_source=x",
+			want:        "http://example.com/landing?ref=short",
+		},
+		{
+			name:        "ignore",
+			originalURL: "https://example.com/landing",
+			queryMode:   models.PassthroughIgnore,
+			pathMode:    models.PassthroughIgnore,
+			rest:        "/deep",
+			rawQuery:    "utm_source=x",
+			want:        "https://example.com/landing",
+		},
+		{
+			name:        "append query",
+			originalURL: "https://example.com/landing?utm_source=short&b=1",
+			queryMode:   models.PassthroughAppend,
+			rawQuery:    "utm_source=mail&utm_campaign=spring",
+			want:        "https://example.com/landing?utm_source=short&b=1&utm_source=mail&utm_campaign=spring",
+		},
+		{
+			name:        "override query",
+			originalURL: "https://example.com/landing?utm_source=short&b=1&utm%5Fcampaign=old",
+			queryMode:   models.PassthroughOverride,
+			rawQuery:    "utm_source=mail&utm_campaign=spring",
+			want:        "https://example.com/landing?b=1&utm_source=mail&utm_campaign=spring",
+		},
+		{
+			name:        "query without original query",
+			originalURL: "https://example.com",
+			queryMode:   models.PassthroughOverride,
+			rawQuery:    "q=go+lang",
+			want:        "https://example.com?q=go+lang",
+		},
+		{
+			name:        "append path",
+			originalURL: "https://example.com/docs/?v=2#top",
+			pathMode:    models.PassthroughAppend,
+			rest:        "/guide/intro/",
+			want:        "https://example.com/docs/guide/intro/?v=2#top",
+		},
+		{
+			name:        "override path",
+			originalURL: "https://example.com/docs/index.html",
+			pathMode:    models.PassthroughOverride,
+			rest:        "/blog/post-1",
+			want:        "https://example.com/blog/post-1",
+		},
+		{
+			name:        "append path to host only url",
+			originalURL: "example.com",
+			pathMode:    models.PassthroughAppend,
+			queryMode:   models.PassthroughAppend,
+			rest:        "/a",
+			rawQuery:    "x=1",
+			want:        "http://example.com/a?x=1",
+		},
+		{
+			name:        "path with dot dot segments isn't passed",
+			originalURL: "https://example.com/docs",
+			pathMode:    models.PassthroughAppend,
+			queryMode:   models.PassthroughAppend,
+			rest:        "/abc/../../admin",
+			rawQuery:    "x=1",
+			want:        "https://example.com/docs?x=1",
+		},
+		{
+			name:        "path with dot segments isn't passed",
+			originalURL: "https://example.com/docs/index.html",
+			pathMode:    models.PassthroughOverride,
+			rest:        "/./admin",
+			want:        "https://example.com/docs/index.html",
+		},
+		{
+			name:        "special characters of path are escaped",
+			originalURL: "https://example.com",
+			pathMode:    models.PassthroughAppend,
+			rest:        "/a?b=c/100%",
+			want:        "https://example.com/a%3Fb=c/100%25",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := models.RedirectOptions{QueryMode: tt.queryMode, PathMode: tt.pathMode}
+			assert.Equal(t, tt.want, RedirectTarget(tt.originalURL, opts, tt.rest, tt.rawQuery))
+		})
+	}
+}
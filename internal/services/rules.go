@@ -0,0 +1,187 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/maxzhirnov/urlshort/internal/models"
+)
+
+// Client describes the device which follows a short link
+type Client struct {
+	// OS and Browser are empty when User-Agent is not recognized
+	OS      string
+	Browser string
+	// Language is the most preferred language of Accept-Language
+	Language string
+}
+
+// Порядок важен: Edge и Opera содержат в User-Agent Chrome и Safari, Chrome содержит Safari
+var browserMarkers = []struct {
+	browser string
+	markers []string
+}{
+	{models.BrowserEdge, []string{"Edg/", "EdgA/", "EdgiOS/", "Edge/"}},
+	{models.BrowserOpera, []string{"OPR/", "OPiOS/", "Opera"}},
+	// Браузеры на Chromium, которые не хотим считать за Chrome
+	{"", []string{"SamsungBrowser/", "YaBrowser/", "UCBrowser/"}},
+	{models.BrowserFirefox, []string{"Firefox/", "FxiOS/"}},
+	{models.BrowserChrome, []string{"Chrome/", "CriOS/"}},
+	{models.BrowserSafari, []string{"Safari/"}},
+}
+
+// ParseClient recognizes OS and browser from User-Agent and the preferred language from Accept-Language
+func ParseClient(userAgent, acceptLanguage string) Client {
+	return Client{
+		OS:       parseOS(userAgent),
+		Browser:  parseBrowser(userAgent),
+		Language: preferredLanguage(acceptLanguage),
+	}
+}
+
+func parseOS(ua string) string {
+	switch {
+	case strings.Contains(ua, "iPhone"), strings.Contains(ua, "iPad"), strings.Contains(ua, "iPod"):
+		return models.OSiOS
+	case strings.Contains(ua, "Android"):
+		return models.OSAndroid
+	case strings.Contains(ua, "Windows NT"), strings.Contains(ua, "Macintosh"),
+		strings.Contains(ua, "X11"), strings.Contains(ua, "CrOS"), strings.Contains(ua, "Linux"):
+		return models.OSDesktop
+	}
+	return ""
+}
+
+func parseBrowser(ua string) string {
+	// Ботов и http клиентов вроде curl не относим ни к одному браузеру
+	if !strings.HasPrefix(ua, "Mozilla/") && !strings.HasPrefix(ua, "Opera") {
+		return ""
+	}
+	for _, b := range browserMarkers {
+		for _, m := range b.markers {
+			if strings.Contains(ua, m) {
+				return b.browser
+			}
+		}
+	}
+	return ""
+}
+
+// preferredLanguage returns the language with the highest weight, the first one wins on equal weights
+func preferredLanguage(header string) string {
+	type weighted struct {
+		tag string
+		q   float64
+	}
+	langs := make([]weighted, 0)
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		tag = strings.TrimSpace(tag)
+		if tag == "" || tag == "*" {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		if q > 0 {
+			langs = append(langs, weighted{tag: tag, q: q})
+		}
+	}
+	if len(langs) == 0 {
+		return ""
+	}
+	sort.SliceStable(langs, func(i, j int) bool { return langs[i].q > langs[j].q })
+	return langs[0].tag
+}
+
+// ResolveDestination returns url of the first rule matching the client or OriginalURL when none matches
+func ResolveDestination(url models.ShortURL, client Client) string {
+	for _, r := range url.Rules {
+		if ruleMatches(r, client) {
+			return r.URL
+		}
+	}
+	return url.OriginalURL
+}
+
+func ruleMatches(r models.RedirectRule, client Client) bool {
+	if r.OS != "" && r.OS != client.OS {
+		return false
+	}
+	if r.Browser != "" && r.Browser != client.Browser {
+		return false
+	}
+	return r.Language == "" || languageMatches(r.Language, client.Language)
+}
+
+// languageMatches compares tags case-insensitively, rule without region matches every region
+func languageMatches(rule, lang string) bool {
+	if strings.EqualFold(rule, lang) {
+		return true
+	}
+	primary, _, found := strings.Cut(lang, "-")
+	return found && !strings.Contains(rule, "-") && strings.EqualFold(rule, primary)
+}
+
+// GetRedirectRules returns rules of the link, they are available to the creator and members of its organization
+func (us *URLShortener) GetRedirectRules(ctx context.Context, id, uuid string) ([]models.RedirectRule, error) {
+	ctx, span := tracer.Start(ctx, "URLShortener.GetRedirectRules")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	url, err := us.requireLinkAccess(ctx, id, uuid, models.RoleViewer)
+	if err != nil {
+		return nil, err
+	}
+	if url.Rules == nil {
+		return []models.RedirectRule{}, nil
+	}
+	return url.Rules, nil
+}
+
+// SetRedirectRules replaces rules of the link, empty rules make the link redirect to the original url only
+func (us *URLShortener) SetRedirectRules(ctx context.Context, id, uuid string, rules []models.RedirectRule) error {
+	ctx, span := tracer.Start(ctx, "URLShortener.SetRedirectRules")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if err := models.ValidateRedirectRules(rules); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidRedirect, err)
+	}
+	if _, err := us.requireLinkAccess(ctx, id, uuid, models.RoleEditor); err != nil {
+		return err
+	}
+	if len(rules) == 0 {
+		rules = nil
+	}
+	return us.Repo.SetURLRules(ctx, id, rules)
+}
+
+// requireLinkAccess allows the creator of a personal link and members of the link's organization with
+// the required role, creator of an organization link has only their role. Other users get ErrNotFound
+// as for deleted links
+func (us *URLShortener) requireLinkAccess(ctx context.Context, id, uuid string, required models.OrgRole) (models.ShortURL, error) {
+	url, err := us.Repo.GetURLByID(ctx, id)
+	if err != nil || url.DeletedFlag {
+		return models.ShortURL{}, ErrNotFound
+	}
+	if url.OrgID == "" {
+		if url.UUID != uuid {
+			return models.ShortURL{}, ErrNotFound
+		}
+		return url, nil
+	}
+	if err := us.requireOrgRole(ctx, url.OrgID, uuid, required); err != nil {
+		return models.ShortURL{}, err
+	}
+	return url, nil
+}
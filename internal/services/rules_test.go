@@ -0,0 +1,230 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/maxzhirnov/urlshort/internal/models"
+)
+
+func TestParseClient_UserAgents(t *testing.T) {
+	tests := []struct {
+		name      string
+		userAgent string
+		os        string
+		browser   string
+	}{
+		{
+			name:      "safari on iphone",
+			userAgent: "Mozilla/5.0 (iPhone; CPU iPhone OS 17_1 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1 Mobile/15E148 Safari/604.1",
+			os:        models.OSiOS,
+			browser:   models.BrowserSafari,
+		},
+		{
+			name:      "chrome on iphone",
+			userAgent: "Mozilla/5.0 (iPhone; CPU iPhone OS 17_1 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) CriOS/120.0.6099.119 Mobile/15E148 Safari/604.1",
+			os:        models.OSiOS,
+			browser:   models.BrowserChrome,
+		},
+		{
+			name:      "firefox on ipad",
+			userAgent: "Mozilla/5.0 (iPad; CPU OS 17_1 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) FxiOS/121.0 Mobile/15E148 Safari/605.1.15",
+			os:        models.OSiOS,
+			browser:   models.BrowserFirefox,
+		},
+		{
+			name:      "edge on iphone",
+			userAgent: "Mozilla/5.0 (iPhone; CPU iPhone OS 17_1 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.0 EdgiOS/120.0.2210.126 Mobile/15E148 Safari/605.1.15",
+			os:        models.OSiOS,
+			browser:   models.BrowserEdge,
+		},
+		{
+			name:      "in-app webview on iphone",
+			userAgent: "Mozilla/5.0 (iPhone; CPU iPhone OS 17_1 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Mobile/15E148",
+			os:        models.OSiOS,
+		},
+		{
+			name:      "chrome on android",
+			userAgent: "Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.6099.144 Mobile Safari/537.36",
+			os:        models.OSAndroid,
+			browser:   models.BrowserChrome,
+		},
+		{
+			name:      "firefox on android",
+			userAgent: "Mozilla/5.0 (Android 14; Mobile; rv:121.0) Gecko/121.0 Firefox/121.0",
+			os:        models.OSAndroid,
+			browser:   models.BrowserFirefox,
+		},
+		{
+			name:      "opera on android",
+			userAgent: "Mozilla/5.0 (Linux; Android 10; VOG-L29) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/119.0.0.0 Mobile Safari/537.36 OPR/79.0.4195.76497",
+			os:        models.OSAndroid,
+			browser:   models.BrowserOpera,
+		},
+		{
+			name:      "samsung internet",
+			userAgent: "Mozilla/5.0 (Linux; Android 13; SM-S918B) AppleWebKit/537.36 (KHTML, like Gecko) SamsungBrowser/23.0 Chrome/115.0.0.0 Mobile Safari/537.36",
+			os:        models.OSAndroid,
+		},
+		{
+			name:      "chrome on windows",
+			userAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
+			os:        models.OSDesktop,
+			browser:   models.BrowserChrome,
+		},
+		{
+			name:      "edge on windows",
+			userAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36 Edg/120.0.2210.91",
+			os:        models.OSDesktop,
+			browser:   models.BrowserEdge,
+		},
+		{
+			name:      "safari on mac",
+			userAgent: "Mozilla/5.0 (Macintosh; Intel Mac OS X 14_1) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1 Safari/605.1.15",
+			os:        models.OSDesktop,
+			browser:   models.BrowserSafari,
+		},
+		{
+			name:      "firefox on linux",
+			userAgent: "Mozilla/5.0 (X11; Linux x86_64; rv:121.0) Gecko/20100101 Firefox/121.0",
+			os:        models.OSDesktop,
+			browser:   models.BrowserFirefox,
+		},
+		{
+			name:      "opera on chromebook",
+			userAgent: "Mozilla/5.0 (X11; CrOS x86_64 14541.0.0) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36 OPR/106.0.0.0",
+			os:        models.OSDesktop,
+			browser:   models.BrowserOpera,
+		},
+		{
+			name:      "curl",
+			userAgent: "curl/8.4.0",
+		},
+		{
+			name:      "bot",
+			userAgent: "Googlebot/2.1 (+http://www.google.com/bot.html)",
+		},
+		{
+			name: "empty",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := ParseClient(tt.userAgent, "")
+			assert.Equal(t, tt.os, client.OS)
+			assert.Equal(t, tt.browser, client.Browser)
+		})
+	}
+}
+
+func TestParseClient_Language(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{header: "", want: ""},
+		{header: "ru-RU,ru;q=0.9,en-US;q=0.8,en;q=0.7", want: "ru-RU"},
+		{header: "en;q=0.5, de-CH", want: "de-CH"},
+		{header: "fr;q=0.8, pt-BR;q=0.8", want: "fr"},
+		{header: "*, es;q=0.5", want: "es"},
+		{header: "it;q=0, nl;q=0.1", want: "nl"},
+		{header: "ja;q=bad, ko;q=0.2", want: "ko"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			assert.Equal(t, tt.want, ParseClient("", tt.header).Language)
+		})
+	}
+}
+
+func TestResolveDestination(t *testing.T) {
+	url := models.ShortURL{
+		OriginalURL: "https://example.com",
+		Rules: []models.RedirectRule{
+			{OS: models.OSiOS, Language: "pt-BR", URL: "https://apps.apple.com/br/app/id1"},
+			{OS: models.OSiOS, URL: "https://apps.apple.com/app/id1"},
+			{OS: models.OSDesktop, Browser: models.BrowserFirefox, URL: "https://addons.mozilla.org/addon"},
+			{Language: "pt", URL: "https://example.com/pt"},
+		},
+	}
+	tests := []struct {
+		name   string
+		client Client
+		want   string
+	}{
+		{name: "first matching rule wins", client: Client{OS: models.OSiOS, Language: "pt-br"}, want: "https://apps.apple.com/br/app/id1"},
+		{name: "region must match", client: Client{OS: models.OSiOS, Language: "pt-PT"}, want: "https://apps.apple.com/app/id1"},
+		{name: "os and browser", client: Client{OS: models.OSDesktop, Browser: models.BrowserFirefox}, want: "https://addons.mozilla.org/addon"},
+		{name: "language without region", client: Client{OS: models.OSDesktop, Browser: models.BrowserChrome, Language: "pt-PT"}, want: "https://example.com/pt"},
+		{name: "fallback", client: Client{OS: models.OSAndroid, Language: "en"}, want: "https://example.com"},
+		{name: "unknown client", want: "https://example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, ResolveDestination(url, tt.client))
+		})
+	}
+}
+
+func Test_RedirectRules(t *testing.T) {
+	owner := "6b1a1c59-0c2b-4f5e-9d1a-7b8e2f1c3d4e"
+	member := "0f8e6a2d-3c4b-4a1e-8f7d-2b9c1e5a6d3f"
+	links := map[string]models.ShortURL{
+		"own":     {ID: "own", OriginalURL: "https://example.com", UUID: owner},
+		"shared":  {ID: "shared", OriginalURL: "https://example.com", UUID: owner, OrgID: "org"},
+		"created": {ID: "created", OriginalURL: "https://example.com", UUID: member, OrgID: "org"},
+		"deleted": {ID: "deleted", OriginalURL: "https://example.com", UUID: owner, DeletedFlag: true},
+	}
+	storage := &mockStorage{
+		GetFunc: func(id string) (models.ShortURL, error) {
+			if u, ok := links[id]; ok {
+				return u, nil
+			}
+			return models.ShortURL{}, errors.New("id not found")
+		},
+		members: []models.Membership{{OrgID: "org", UUID: member, Role: models.RoleViewer}},
+	}
+	app := NewURLShortener(storage, NewRandIDGenerator(8), nil)
+	ctx := context.Background()
+	rules := []models.RedirectRule{{OS: models.OSAndroid, URL: "https://play.google.com/store/apps/details?id=app"}}
+
+	require.NoError(t, app.SetRedirectRules(ctx, "own", owner, rules))
+	assert.Equal(t, rules, storage.rules)
+
+	got, err := app.GetRedirectRules(ctx, "own", owner)
+	require.NoError(t, err)
+	assert.Equal(t, []models.RedirectRule{}, got)
+
+	// Ссылки других пользователей не отличаются от несуществующих
+	_, err = app.GetRedirectRules(ctx, "own", member)
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.ErrorIs(t, app.SetRedirectRules(ctx, "deleted", owner, rules), ErrNotFound)
+	assert.ErrorIs(t, app.SetRedirectRules(ctx, "missing", owner, rules), ErrNotFound)
+
+	_, err = app.GetRedirectRules(ctx, "shared", member)
+	assert.NoError(t, err)
+	assert.ErrorIs(t, app.SetRedirectRules(ctx, "shared", member, rules), ErrForbidden)
+	// Создатель ссылки организации ограничен своей ролью, а бывший участник ее не видит
+	assert.ErrorIs(t, app.SetRedirectRules(ctx, "created", member, nil), ErrForbidden)
+	_, err = app.GetRedirectRules(ctx, "shared", owner)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	invalid := []models.RedirectRule{{Browser: "netscape", URL: "https://example.com"}}
+	assert.ErrorIs(t, app.SetRedirectRules(ctx, "own", owner, invalid), ErrInvalidRedirect)
+	for _, u := range []string{"javascript:alert(1)", "/relative", "ftp://example.com/file", "https://"} {
+		badURL := []models.RedirectRule{{OS: models.OSiOS, URL: u}}
+		assert.ErrorIs(t, app.SetRedirectRules(ctx, "own", owner, badURL), ErrInvalidRedirect, u)
+	}
+	tooMany := make([]models.RedirectRule, models.MaxRedirectRules+1)
+	for i := range tooMany {
+		tooMany[i] = models.RedirectRule{Language: "en", URL: "https://example.com"}
+	}
+	assert.ErrorIs(t, app.SetRedirectRules(ctx, "own", owner, tooMany), ErrInvalidRedirect)
+
+	require.NoError(t, app.SetRedirectRules(ctx, "own", owner, []models.RedirectRule{}))
+	assert.Nil(t, storage.rules)
+}
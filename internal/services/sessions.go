@@ -0,0 +1,136 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"github.com/maxzhirnov/urlshort/internal/models"
+)
+
+const sessionCleanupInterval = time.Hour
+
+// revocationSyncInterval bounds how long a token revoked on another instance stays valid on this one
+const revocationSyncInterval = 15 * time.Second
+
+func (us *URLShortener) RecordSession(ctx context.Context, session models.Session) error {
+	ctx, span := tracer.Start(ctx, "URLShortener.RecordSession")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	return us.Repo.InsertSession(ctx, session)
+}
+
+// GetActiveSessions returns not revoked and not expired sessions of the user
+func (us *URLShortener) GetActiveSessions(ctx context.Context, uuid string) ([]models.Session, error) {
+	ctx, span := tracer.Start(ctx, "URLShortener.GetActiveSessions")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	sessions, err := us.Repo.GetSessionsByUUID(ctx, uuid)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	active := make([]models.Session, 0, len(sessions))
+	for _, s := range sessions {
+		if !s.Revoked && s.ExpiresAt.After(now) {
+			active = append(active, s)
+		}
+	}
+	return active, nil
+}
+
+// RevokeSession revokes one of the user's sessions, sessions of other users are reported as not found
+func (us *URLShortener) RevokeSession(ctx context.Context, id, uuid string) (models.Session, error) {
+	ctx, span := tracer.Start(ctx, "URLShortener.RevokeSession")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	sessions, err := us.Repo.GetSessionsByUUID(ctx, uuid)
+	if err != nil {
+		return models.Session{}, err
+	}
+
+	for _, s := range sessions {
+		if s.ID == id {
+			if err := us.Repo.RevokeSession(ctx, s); err != nil {
+				return models.Session{}, err
+			}
+			s.Revoked = true
+			return s, nil
+		}
+	}
+	return models.Session{}, ErrNotFound
+}
+
+// RevokeToken revokes token used for the current request, the token may have no session
+// recorded if it was issued to an anonymous user
+func (us *URLShortener) RevokeToken(ctx context.Context, session models.Session) error {
+	ctx, span := tracer.Start(ctx, "URLShortener.RevokeToken")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	return us.Repo.RevokeSession(ctx, session)
+}
+
+// GetRevokedSessions returns revoked sessions of not yet expired tokens
+func (us *URLShortener) GetRevokedSessions(ctx context.Context) ([]models.Session, error) {
+	ctx, span := tracer.Start(ctx, "URLShortener.GetRevokedSessions")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	return us.Repo.GetRevokedSessions(ctx, time.Now())
+}
+
+// SyncRevokedSessions passes revoked sessions of not yet expired tokens to revoke, so tokens
+// revoked by other instances sharing the storage are rejected by this one too
+func (us *URLShortener) SyncRevokedSessions(ctx context.Context, revoke func(jti string, expiresAt time.Time)) error {
+	sessions, err := us.GetRevokedSessions(ctx)
+	if err != nil {
+		return err
+	}
+	for _, s := range sessions {
+		revoke(s.ID, s.ExpiresAt)
+	}
+	return nil
+}
+
+// ProcessRevocationSync periodically calls SyncRevokedSessions until ctx is done
+func (us *URLShortener) ProcessRevocationSync(ctx context.Context, revoke func(jti string, expiresAt time.Time)) {
+	ticker := time.NewTicker(revocationSyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := us.SyncRevokedSessions(ctx, revoke); err != nil {
+				us.logger.Error("revoked sessions sync failed", "error", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// ProcessSessionCleanup periodically deletes sessions of expired tokens
+func (us *URLShortener) ProcessSessionCleanup(ctx context.Context) {
+	ticker := time.NewTicker(sessionCleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			cleanupCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+			removed, err := us.Repo.DeleteExpiredSessions(cleanupCtx, now)
+			cancel()
+			if err != nil {
+				us.logger.Error(err.Error())
+				continue
+			}
+			us.logger.Debug("expired sessions removed", "count", removed)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -3,22 +3,41 @@ package services
 import (
 	"context"
 	"errors"
-	"os"
+	"fmt"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"go.opentelemetry.io/otel"
+
+	"github.com/maxzhirnov/urlshort/internal/logging"
 	"github.com/maxzhirnov/urlshort/internal/models"
 	"github.com/maxzhirnov/urlshort/internal/repositories"
 )
 
 const (
-	deletionInterval = 10 * time.Second
-	deleteChanCap    = 512
+	defaultDeletionInterval = 10 * time.Second
+	deleteChanCap           = 512
+	// Воркер удаления считается зависшим, если не просыпался дольше staleWorkerIntervals интервалов записи
+	staleWorkerIntervals = 3
+	// maxDeletionBacklog - сколько удалений может ждать записи в хранилище, пока сервис считается готовым
+	maxDeletionBacklog = 8 * deleteChanCap
 )
 
+var tracer = otel.Tracer("github.com/maxzhirnov/urlshort/internal/services")
+
 var (
 	ErrEntityAlreadyExist = errors.New("entity already exist")
+	ErrWorkerNotRunning   = errors.New("deletion worker isn't running")
+	ErrInvalidRedirect    = errors.New("invalid redirect options")
 )
 
+// BatchURL is a link of the batch with its redirect options
+type BatchURL struct {
+	OriginalURL string
+	Redirect    models.RedirectOptions
+}
+
 type logger interface {
 	Info(string, ...interface{})
 	Error(string, ...interface{})
@@ -33,9 +52,46 @@ type repository interface {
 	GetURLByID(ctx context.Context, id string) (models.ShortURL, error)
 	GetURLsByUUID(ctx context.Context, uuid string) ([]models.ShortURL, error)
 	TagURLsDeleted([]models.Deletion) error
+	InsertAPIKey(context.Context, models.APIKey) error
+	GetAPIKeyByHash(ctx context.Context, hash string) (models.APIKey, error)
+	GetAPIKeysByUUID(ctx context.Context, uuid string) ([]models.APIKey, error)
+	RevokeAPIKey(ctx context.Context, id, uuid string) error
+	InsertUser(context.Context, models.User) error
+	GetUserByID(ctx context.Context, id string) (models.User, error)
+	GetUserByLogin(ctx context.Context, login string) (models.User, error)
+	GetUserByExternalID(ctx context.Context, externalID string) (models.User, error)
+	ReassignURLs(ctx context.Context, fromUUID, toUUID string) (int, error)
+	TagURLsDeletedByID(ctx context.Context, ids []string) error
+	SetURLsDisabled(ctx context.Context, ids []string, disabled bool) error
+	SetURLRules(ctx context.Context, id string, rules []models.RedirectRule) error
+	SearchURLs(context.Context, models.URLFilter) ([]models.ShortURL, error)
+	ListUsers(ctx context.Context, query string, limit, offset int) ([]models.User, error)
+	Stats(context.Context) (models.Stats, error)
+	InsertSession(context.Context, models.Session) error
+	GetSessionsByUUID(ctx context.Context, uuid string) ([]models.Session, error)
+	RevokeSession(context.Context, models.Session) error
+	GetRevokedSessions(ctx context.Context, expiresAfter time.Time) ([]models.Session, error)
+	DeleteExpiredSessions(ctx context.Context, expiredBefore time.Time) (int, error)
+	InsertOrganization(context.Context, models.Organization) error
+	GetOrganization(ctx context.Context, id string) (models.Organization, error)
+	SetMembership(context.Context, models.Membership) error
+	GetMembershipsByUUID(ctx context.Context, uuid string) ([]models.Membership, error)
+	GetOrgMembers(ctx context.Context, orgID string) ([]models.Membership, error)
+	GetURLsByOrg(ctx context.Context, orgID string) ([]models.ShortURL, error)
+	SetURLsOrg(ctx context.Context, ids []string, uuid, orgID string) (int, error)
 	Ping() error
 }
 
+type serviceMetrics interface {
+	LinksCreated(n int)
+	LinkConflict()
+}
+
+type noopMetrics struct{}
+
+func (noopMetrics) LinksCreated(int) {}
+func (noopMetrics) LinkConflict()    {}
+
 type idGenerator interface {
 	Generate() string
 }
@@ -44,36 +100,86 @@ type URLShortener struct {
 	Repo        repository
 	IDGenerator idGenerator
 	logger      logger
+	metrics     serviceMetrics
 
 	// Канал для удаления URL-ов
 	deleteChan     chan models.Deletion
 	deletionsStack []models.Deletion
+	// senders считает горутины Delete, которые еще пишут в deleteChan
+	senders sync.WaitGroup
+	// Длина deletionsStack, читается из других горутин при сборе метрик
+	stackLen atomic.Int64
+	// Время последней итерации ProcessLinkDeletion в unix nano, 0 если воркер не запущен
+	deletionHeartbeat atomic.Int64
+	deletionInterval  atomic.Int64
+	// intervalChanged будит ProcessLinkDeletion, чтобы новый интервал применился сразу
+	intervalChanged chan struct{}
 }
 
 func NewURLShortener(repo repository, idGenerator idGenerator, logger logger) *URLShortener {
-	return &URLShortener{
-		Repo:           repo,
-		IDGenerator:    idGenerator,
-		logger:         logger,
-		deleteChan:     make(chan models.Deletion, deleteChanCap),
-		deletionsStack: make([]models.Deletion, 0, deleteChanCap),
+	us := &URLShortener{
+		Repo:            repo,
+		IDGenerator:     idGenerator,
+		logger:          logger,
+		metrics:         noopMetrics{},
+		deleteChan:      make(chan models.Deletion, deleteChanCap),
+		deletionsStack:  make([]models.Deletion, 0, deleteChanCap),
+		intervalChanged: make(chan struct{}, 1),
 	}
+	us.deletionInterval.Store(int64(defaultDeletionInterval))
+	return us
 }
 
-func (us *URLShortener) Create(originalURL, uuid string) (models.ShortURL, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-	urlShorten := models.ShortURL{
-		OriginalURL: originalURL,
-		ID:          us.IDGenerator.Generate(),
-		UUID:        uuid,
+// WithMetrics enables counting of created links and conflicts
+func (us *URLShortener) WithMetrics(m serviceMetrics) *URLShortener {
+	us.metrics = m
+	return us
+}
+
+// SetDeletionInterval changes how often queued deletions are written to storage,
+// it can be called while ProcessLinkDeletion is running
+func (us *URLShortener) SetDeletionInterval(d time.Duration) {
+	if d <= 0 || time.Duration(us.deletionInterval.Swap(int64(d))) == d {
+		return
 	}
+	select {
+	case us.intervalChanged <- struct{}{}:
+	default:
+	}
+}
+
+func (us *URLShortener) getDeletionInterval() time.Duration {
+	return time.Duration(us.deletionInterval.Load())
+}
+
+// DeletionQueueDepth returns number of deletions which are not written to storage yet
+func (us *URLShortener) DeletionQueueDepth() int {
+	return len(us.deleteChan) + int(us.stackLen.Load())
+}
+
+// Create shortens url, when url is already shortened existing link is returned together with
+// ErrEntityAlreadyExist and redirect options of the existing link are kept
+func (us *URLShortener) Create(ctx context.Context, originalURL, uuid string, redirect models.RedirectOptions) (models.ShortURL, error) {
+	ctx, span := tracer.Start(ctx, "URLShortener.Create")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
 	if originalURL == "" {
 		return models.ShortURL{}, errors.New("originalURL shouldn't be empty string")
 	}
+	if err := redirect.Validate(); err != nil {
+		return models.ShortURL{}, fmt.Errorf("%w: %v", ErrInvalidRedirect, err)
+	}
+	urlShorten := models.ShortURL{
+		OriginalURL:     originalURL,
+		ID:              us.generateID(ctx),
+		UUID:            uuid,
+		RedirectOptions: redirect,
+	}
 	insertedURL, err := us.Repo.Insert(ctx, urlShorten)
 
 	if errors.Is(err, repositories.ErrEntityAlreadyExist) {
+		us.metrics.LinkConflict()
 		return insertedURL, ErrEntityAlreadyExist
 	}
 
@@ -81,11 +187,21 @@ func (us *URLShortener) Create(originalURL, uuid string) (models.ShortURL, error
 		return models.ShortURL{}, err
 	}
 
+	us.metrics.LinksCreated(1)
 	return insertedURL, nil
 }
 
-func (us *URLShortener) Get(id string) (models.ShortURL, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+// generateID is traced separately to tell time spent in the generator from storage time
+func (us *URLShortener) generateID(ctx context.Context) string {
+	_, span := tracer.Start(ctx, "IDGenerator.Generate")
+	defer span.End()
+	return us.IDGenerator.Generate()
+}
+
+func (us *URLShortener) Get(ctx context.Context, id string) (models.ShortURL, error) {
+	ctx, span := tracer.Start(ctx, "URLShortener.Get")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 	if id == "" {
 		return models.ShortURL{}, errors.New("id shouldn't be empty string")
@@ -93,8 +209,10 @@ func (us *URLShortener) Get(id string) (models.ShortURL, error) {
 	return us.Repo.GetURLByID(ctx, id)
 }
 
-func (us *URLShortener) CreateBatch(urls []string, uuid string) ([]string, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (us *URLShortener) CreateBatch(ctx context.Context, urls []BatchURL, uuid string) ([]string, error) {
+	ctx, span := tracer.Start(ctx, "URLShortener.CreateBatch")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 	if len(urls) == 0 {
 		return []string{}, nil
@@ -103,34 +221,50 @@ func (us *URLShortener) CreateBatch(urls []string, uuid string) ([]string, error
 	urlsToInsert := make([]models.ShortURL, len(urls))
 
 	for i, url := range urls {
+		if err := url.Redirect.Validate(); err != nil {
+			return nil, fmt.Errorf("%w: url %d: %v", ErrInvalidRedirect, i, err)
+		}
 		urlsToInsert[i] = models.ShortURL{
-			OriginalURL: url,
-			ID:          us.IDGenerator.Generate(),
-			UUID:        uuid,
+			OriginalURL:     url.OriginalURL,
+			ID:              us.generateID(ctx),
+			UUID:            uuid,
+			RedirectOptions: url.Redirect,
 		}
 	}
 
 	shortenURLs, err := us.Repo.InsertMany(ctx, urlsToInsert)
 	if err != nil {
-		us.logger.Error(err.Error())
+		us.logger.Error("error inserting batch", "error", err, "request_id", logging.RequestID(ctx))
 		return nil, err
 	}
 
 	ids := make([]string, len(urls))
+	created := 0
 	for i, u := range shortenURLs {
 		ids[i] = u.ID
+		// Для уже сокращенных url возвращается существующий id
+		if u.ID == urlsToInsert[i].ID {
+			created++
+		} else {
+			us.metrics.LinkConflict()
+		}
 	}
+	us.metrics.LinksCreated(created)
 	return ids, nil
 }
 
-func (us *URLShortener) GetAllUsersURLs(uuid string) ([]models.ShortURL, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (us *URLShortener) GetAllUsersURLs(ctx context.Context, uuid string) ([]models.ShortURL, error) {
+	ctx, span := tracer.Start(ctx, "URLShortener.GetAllUsersURLs")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 	return us.Repo.GetURLsByUUID(ctx, uuid)
 }
 
 func (us *URLShortener) Delete(ids []string, userID string) {
+	us.senders.Add(1)
 	go func() {
+		defer us.senders.Done()
 		for _, id := range ids {
 			deletion := models.Deletion{
 				UserID: userID,
@@ -141,47 +275,87 @@ func (us *URLShortener) Delete(ids []string, userID string) {
 	}()
 }
 
+// ProcessLinkDeletion writes queued deletions to storage every deletion interval,
+// after ctx is canceled pending deletions are flushed and the method returns.
+// Cancel ctx only after HTTP server is stopped so no new deletions arrive.
 func (us *URLShortener) ProcessLinkDeletion(ctx context.Context) {
-	ticker := time.NewTicker(deletionInterval)
+	ticker := time.NewTicker(us.getDeletionInterval())
 	defer ticker.Stop()
+	us.deletionHeartbeat.Store(time.Now().UnixNano())
+	defer us.deletionHeartbeat.Store(0)
 
 	for {
 		select {
 		case d := <-us.deleteChan:
 			us.logger.Debug("adding deletion to delete chan")
-			us.deletionsStack = append(us.deletionsStack, d)
+			us.pushDeletion(d)
 		case <-ticker.C:
-			if len(us.deletionsStack) == 0 {
-				continue
-			}
-			err := us.Repo.TagURLsDeleted(us.deletionsStack)
-			if err != nil {
-				us.logger.Error(err.Error())
-				continue
-			}
-			us.deletionsStack = us.deletionsStack[0:]
+			us.flushDeletions()
+			us.deletionHeartbeat.Store(time.Now().UnixNano())
+		case <-us.intervalChanged:
+			ticker.Reset(us.getDeletionInterval())
+			us.deletionHeartbeat.Store(time.Now().UnixNano())
 		case <-ctx.Done():
-			us.Stop()
+			us.drainDeletions()
+			us.flushDeletions()
+			return
 		}
 	}
 }
 
-func (us *URLShortener) Stop() {
-	us.logger.Debug("Shutting down...")
-	// Удаляем все оставшиеся в канале deletions
-	for len(us.deleteChan) > 0 {
-		d := <-us.deleteChan
-		us.deletionsStack = append(us.deletionsStack, d)
+// CheckDeletionWorker reports whether ProcessLinkDeletion is running, isn't stuck
+// on storage and keeps up with incoming deletions
+func (us *URLShortener) CheckDeletionWorker(ctx context.Context) error {
+	heartbeat := us.deletionHeartbeat.Load()
+	if heartbeat == 0 {
+		return ErrWorkerNotRunning
 	}
-	if len(us.deletionsStack) > 0 {
-		err := us.Repo.TagURLsDeleted(us.deletionsStack)
-		if err != nil {
-			us.logger.Error(err.Error())
+	if idle := time.Since(time.Unix(0, heartbeat)); idle > staleWorkerIntervals*us.getDeletionInterval() {
+		return fmt.Errorf("deletion worker is stuck, last flush %s ago", idle.Round(time.Second))
+	}
+	if backlog := us.DeletionQueueDepth(); backlog > maxDeletionBacklog {
+		return fmt.Errorf("deletion backlog %d exceeds %d", backlog, maxDeletionBacklog)
+	}
+	return nil
+}
+
+func (us *URLShortener) pushDeletion(d models.Deletion) {
+	us.deletionsStack = append(us.deletionsStack, d)
+	us.stackLen.Store(int64(len(us.deletionsStack)))
+}
+
+// drainDeletions moves into stack everything sent by Delete calls which are still in progress
+func (us *URLShortener) drainDeletions() {
+	sendersDone := make(chan struct{})
+	go func() {
+		us.senders.Wait()
+		close(sendersDone)
+	}()
+
+	for {
+		select {
+		case d := <-us.deleteChan:
+			us.pushDeletion(d)
+		case <-sendersDone:
+			for len(us.deleteChan) > 0 {
+				us.pushDeletion(<-us.deleteChan)
+			}
+			return
 		}
 	}
-	// и закрываем канал
-	close(us.deleteChan)
-	os.Exit(0)
+}
+
+func (us *URLShortener) flushDeletions() {
+	if len(us.deletionsStack) == 0 {
+		return
+	}
+	// При ошибке deletions остаются в стеке до следующей попытки
+	if err := us.Repo.TagURLsDeleted(us.deletionsStack); err != nil {
+		us.logger.Error(err.Error())
+		return
+	}
+	us.deletionsStack = us.deletionsStack[:0]
+	us.stackLen.Store(0)
 }
 
 func (us *URLShortener) Ping() error {
@@ -3,16 +3,239 @@ package services
 import (
 	"context"
 	"errors"
+	"net/http"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
+	"github.com/maxzhirnov/urlshort/internal/auth"
 	"github.com/maxzhirnov/urlshort/internal/models"
+	"github.com/maxzhirnov/urlshort/internal/repositories"
 )
 
 type mockStorage struct {
 	SaveFunc func(url models.ShortURL) (models.ShortURL, error)
 	GetFunc  func(id string) (models.ShortURL, error)
+	apiKeys  []models.APIKey
+	users    []models.User
+	owners   map[string]string
+	sessions []models.Session
+	orgs     []models.Organization
+	members  []models.Membership
+	deleted  []models.Deletion
+	rules    []models.RedirectRule
+	urls     []models.ShortURL
+	// apiKeysErr is returned by GetAPIKeyByHash when set
+	apiKeysErr error
+}
+
+func (ms *mockStorage) InsertOrganization(ctx context.Context, org models.Organization) error {
+	ms.orgs = append(ms.orgs, org)
+	return nil
+}
+
+func (ms *mockStorage) GetOrganization(ctx context.Context, id string) (models.Organization, error) {
+	for _, o := range ms.orgs {
+		if o.ID == id {
+			return o, nil
+		}
+	}
+	return models.Organization{}, repositories.ErrNotFound
+}
+
+func (ms *mockStorage) SetMembership(ctx context.Context, m models.Membership) error {
+	for i, existing := range ms.members {
+		if existing.OrgID == m.OrgID && existing.UUID == m.UUID {
+			ms.members = append(ms.members[:i], ms.members[i+1:]...)
+			break
+		}
+	}
+	if m.Role != "" {
+		ms.members = append(ms.members, m)
+	}
+	return nil
+}
+
+func (ms *mockStorage) GetMembershipsByUUID(ctx context.Context, uuid string) ([]models.Membership, error) {
+	memberships := make([]models.Membership, 0)
+	for _, m := range ms.members {
+		if m.UUID == uuid {
+			memberships = append(memberships, m)
+		}
+	}
+	return memberships, nil
+}
+
+func (ms *mockStorage) GetOrgMembers(ctx context.Context, orgID string) ([]models.Membership, error) {
+	memberships := make([]models.Membership, 0)
+	for _, m := range ms.members {
+		if m.OrgID == orgID {
+			memberships = append(memberships, m)
+		}
+	}
+	return memberships, nil
+}
+
+func (ms *mockStorage) GetURLsByOrg(ctx context.Context, orgID string) ([]models.ShortURL, error) {
+	var urls []models.ShortURL
+	for _, u := range ms.urls {
+		if u.OrgID == orgID {
+			urls = append(urls, u)
+		}
+	}
+	return urls, nil
+}
+
+func (ms *mockStorage) SetURLsOrg(ctx context.Context, ids []string, uuid, orgID string) (int, error) {
+	return len(ids), nil
+}
+
+func (ms *mockStorage) InsertUser(ctx context.Context, user models.User) error {
+	for _, u := range ms.users {
+		if u.Login == user.Login {
+			return repositories.ErrEntityAlreadyExist
+		}
+	}
+	ms.users = append(ms.users, user)
+	return nil
+}
+
+func (ms *mockStorage) GetUserByID(ctx context.Context, id string) (models.User, error) {
+	for _, u := range ms.users {
+		if u.ID == id {
+			return u, nil
+		}
+	}
+	return models.User{}, repositories.ErrNotFound
+}
+
+func (ms *mockStorage) GetUserByLogin(ctx context.Context, login string) (models.User, error) {
+	for _, u := range ms.users {
+		if u.Login == login {
+			return u, nil
+		}
+	}
+	return models.User{}, repositories.ErrNotFound
+}
+
+func (ms *mockStorage) GetUserByExternalID(ctx context.Context, externalID string) (models.User, error) {
+	for _, u := range ms.users {
+		if u.ExternalID == externalID {
+			return u, nil
+		}
+	}
+	return models.User{}, repositories.ErrNotFound
+}
+
+func (ms *mockStorage) TagURLsDeletedByID(ctx context.Context, ids []string) error {
+	return nil
+}
+
+func (ms *mockStorage) SetURLsDisabled(ctx context.Context, ids []string, disabled bool) error {
+	return nil
+}
+
+func (ms *mockStorage) SetURLRules(ctx context.Context, id string, rules []models.RedirectRule) error {
+	ms.rules = rules
+	return nil
+}
+
+func (ms *mockStorage) SearchURLs(ctx context.Context, filter models.URLFilter) ([]models.ShortURL, error) {
+	return nil, nil
+}
+
+func (ms *mockStorage) ListUsers(ctx context.Context, query string, limit, offset int) ([]models.User, error) {
+	return ms.users, nil
+}
+
+func (ms *mockStorage) Stats(ctx context.Context) (models.Stats, error) {
+	return models.Stats{}, nil
+}
+
+func (ms *mockStorage) InsertSession(ctx context.Context, session models.Session) error {
+	ms.sessions = append(ms.sessions, session)
+	return nil
+}
+
+func (ms *mockStorage) GetSessionsByUUID(ctx context.Context, uuid string) ([]models.Session, error) {
+	sessions := make([]models.Session, 0)
+	for _, s := range ms.sessions {
+		if s.UUID == uuid {
+			sessions = append(sessions, s)
+		}
+	}
+	return sessions, nil
+}
+
+func (ms *mockStorage) RevokeSession(ctx context.Context, session models.Session) error {
+	for i, s := range ms.sessions {
+		if s.ID == session.ID {
+			ms.sessions[i].Revoked = true
+			return nil
+		}
+	}
+	session.Revoked = true
+	ms.sessions = append(ms.sessions, session)
+	return nil
+}
+
+func (ms *mockStorage) GetRevokedSessions(ctx context.Context, expiresAfter time.Time) ([]models.Session, error) {
+	sessions := make([]models.Session, 0)
+	for _, s := range ms.sessions {
+		if s.Revoked && s.ExpiresAt.After(expiresAfter) {
+			sessions = append(sessions, s)
+		}
+	}
+	return sessions, nil
+}
+
+func (ms *mockStorage) DeleteExpiredSessions(ctx context.Context, expiredBefore time.Time) (int, error) {
+	return 0, nil
+}
+
+func (ms *mockStorage) ReassignURLs(ctx context.Context, fromUUID, toUUID string) (int, error) {
+	n := 0
+	for id, owner := range ms.owners {
+		if owner == fromUUID {
+			ms.owners[id] = toUUID
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (ms *mockStorage) InsertAPIKey(ctx context.Context, key models.APIKey) error {
+	ms.apiKeys = append(ms.apiKeys, key)
+	return nil
+}
+
+func (ms *mockStorage) GetAPIKeyByHash(ctx context.Context, hash string) (models.APIKey, error) {
+	if ms.apiKeysErr != nil {
+		return models.APIKey{}, ms.apiKeysErr
+	}
+	for _, k := range ms.apiKeys {
+		if k.Hash == hash {
+			return k, nil
+		}
+	}
+	return models.APIKey{}, repositories.ErrNotFound
+}
+
+func (ms *mockStorage) GetAPIKeysByUUID(ctx context.Context, uuid string) ([]models.APIKey, error) {
+	return ms.apiKeys, nil
+}
+
+func (ms *mockStorage) RevokeAPIKey(ctx context.Context, id, uuid string) error {
+	for i, k := range ms.apiKeys {
+		if k.ID == id && k.UUID == uuid {
+			ms.apiKeys[i].Revoked = true
+			return nil
+		}
+	}
+	return repositories.ErrNotFound
 }
 
 func (ms *mockStorage) InsertMany(ctx context.Context, urls []models.ShortURL) ([]models.ShortURL, error) {
@@ -21,6 +244,9 @@ func (ms *mockStorage) InsertMany(ctx context.Context, urls []models.ShortURL) (
 }
 
 func (ms *mockStorage) GetURLByID(ctx context.Context, id string) (models.ShortURL, error) {
+	if ms.GetFunc != nil {
+		return ms.GetFunc(id)
+	}
 	return models.ShortURL{
 		ID:          id,
 		OriginalURL: "example.com",
@@ -28,6 +254,7 @@ func (ms *mockStorage) GetURLByID(ctx context.Context, id string) (models.ShortU
 }
 
 func (ms *mockStorage) TagURLsDeleted(urls []models.Deletion) error {
+	ms.deleted = append(ms.deleted, urls...)
 	return nil
 }
 
@@ -63,10 +290,11 @@ func Test_Create(t *testing.T) {
 	}
 
 	tests := []struct {
-		name string
-		url  string
-		uuid string
-		want want
+		name     string
+		url      string
+		uuid     string
+		redirect models.RedirectOptions
+		want     want
 	}{
 		{
 			name: "happy path",
@@ -77,6 +305,43 @@ func Test_Create(t *testing.T) {
 				err: nil,
 			},
 		},
+		{
+			name:     "permanent redirect",
+			url:      "google.com",
+			uuid:     "123456",
+			redirect: models.RedirectOptions{RedirectCode: 308, CacheControl: "max-age=86400", ReferrerPolicy: "origin"},
+			want: want{
+				id:  "12345678",
+				err: nil,
+			},
+		},
+		{
+			name:     "see other isn't allowed",
+			url:      "google.com",
+			uuid:     "123456",
+			redirect: models.RedirectOptions{RedirectCode: 303},
+			want: want{
+				err: ErrInvalidRedirect,
+			},
+		},
+		{
+			name:     "header injection",
+			url:      "google.com",
+			uuid:     "123456",
+			redirect: models.RedirectOptions{CacheControl: "no-store\r\nSet-Cookie: a=b"},
+			want: want{
+				err: ErrInvalidRedirect,
+			},
+		},
+		{
+			name:     "unknown referrer policy",
+			url:      "google.com",
+			uuid:     "123456",
+			redirect: models.RedirectOptions{ReferrerPolicy: "never"},
+			want: want{
+				err: ErrInvalidRedirect,
+			},
+		},
 		{
 			name: "empty url",
 			url:  "",
@@ -96,13 +361,135 @@ func Test_Create(t *testing.T) {
 				},
 			}
 			app := NewURLShortener(storage, NewRandIDGenerator(8), nil)
-			actualURL, actualErr := app.Create(tt.url, tt.uuid)
+			actualURL, actualErr := app.Create(context.Background(), tt.url, tt.uuid, tt.redirect)
 			assert.Equal(t, len(tt.want.id), len(actualURL.ID))
+			if errors.Is(tt.want.err, ErrInvalidRedirect) {
+				assert.ErrorIs(t, actualErr, ErrInvalidRedirect)
+				return
+			}
 			assert.Equal(t, tt.want.err, actualErr)
+			assert.Equal(t, tt.redirect, actualURL.RedirectOptions)
 		})
 	}
 }
 
+type countingMetrics struct {
+	created   int
+	conflicts int
+}
+
+func (m *countingMetrics) LinksCreated(n int) { m.created += n }
+func (m *countingMetrics) LinkConflict()      { m.conflicts++ }
+
+func Test_CreateMetrics(t *testing.T) {
+	existing := models.ShortURL{ID: "existing", OriginalURL: "google.com"}
+	storage := &mockStorage{
+		SaveFunc: func(url models.ShortURL) (models.ShortURL, error) {
+			if url.OriginalURL == existing.OriginalURL {
+				return existing, repositories.ErrEntityAlreadyExist
+			}
+			return url, nil
+		},
+	}
+	m := &countingMetrics{}
+	app := NewURLShortener(storage, NewRandIDGenerator(8), nil).WithMetrics(m)
+
+	_, err := app.Create(context.Background(), "ya.ru", "123456", models.RedirectOptions{})
+	assert.NoError(t, err)
+	_, err = app.Create(context.Background(), "google.com", "123456", models.RedirectOptions{})
+	assert.ErrorIs(t, err, ErrEntityAlreadyExist)
+
+	assert.Equal(t, 1, m.created)
+	assert.Equal(t, 1, m.conflicts)
+	assert.Equal(t, 0, app.DeletionQueueDepth())
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Info(string, ...interface{})  {}
+func (nopLogger) Error(string, ...interface{}) {}
+func (nopLogger) Fatal(string, ...interface{}) {}
+func (nopLogger) Warn(string, ...interface{})  {}
+func (nopLogger) Debug(string, ...interface{}) {}
+
+func Test_ProcessLinkDeletion_FlushOnStop(t *testing.T) {
+	storage := &mockStorage{}
+	app := NewURLShortener(storage, NewRandIDGenerator(8), nopLogger{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		app.ProcessLinkDeletion(ctx)
+		close(done)
+	}()
+
+	// Удаления отправлены до остановки, но интервал записи еще не прошел
+	app.Delete([]string{"a", "b", "c"}, "123456")
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("ProcessLinkDeletion didn't return after cancel")
+	}
+	assert.Len(t, storage.deleted, 3)
+	assert.Equal(t, 0, app.DeletionQueueDepth())
+}
+
+func Test_SetDeletionInterval(t *testing.T) {
+	storage := &mockStorage{}
+	app := NewURLShortener(storage, NewRandIDGenerator(8), nopLogger{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		app.ProcessLinkDeletion(ctx)
+		close(done)
+	}()
+
+	app.Delete([]string{"a", "b", "c"}, "123456")
+	assert.Eventually(t, func() bool {
+		return app.stackLen.Load() == 3
+	}, time.Second, time.Millisecond)
+
+	// Интервал меняется у запущенного воркера, с интервалом по умолчанию запись ждала бы 10 секунд
+	app.SetDeletionInterval(20 * time.Millisecond)
+	assert.Eventually(t, func() bool {
+		return app.stackLen.Load() == 0
+	}, time.Second, 5*time.Millisecond)
+
+	cancel()
+	<-done
+	assert.Len(t, storage.deleted, 3)
+}
+
+func Test_CheckDeletionWorker(t *testing.T) {
+	app := NewURLShortener(&mockStorage{}, NewRandIDGenerator(8), nopLogger{})
+	assert.ErrorIs(t, app.CheckDeletionWorker(context.Background()), ErrWorkerNotRunning)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		app.ProcessLinkDeletion(ctx)
+		close(done)
+	}()
+	assert.Eventually(t, func() bool {
+		return app.CheckDeletionWorker(context.Background()) == nil
+	}, time.Second, 10*time.Millisecond)
+
+	app.deletionHeartbeat.Store(time.Now().Add(-2 * staleWorkerIntervals * defaultDeletionInterval).UnixNano())
+	assert.ErrorContains(t, app.CheckDeletionWorker(context.Background()), "stuck")
+
+	app.deletionHeartbeat.Store(time.Now().UnixNano())
+	app.stackLen.Store(maxDeletionBacklog + 1)
+	assert.ErrorContains(t, app.CheckDeletionWorker(context.Background()), "backlog")
+	app.stackLen.Store(0)
+
+	cancel()
+	<-done
+	assert.ErrorIs(t, app.CheckDeletionWorker(context.Background()), ErrWorkerNotRunning)
+}
+
 func Test_Get(t *testing.T) {
 	type want struct {
 		url models.ShortURL
@@ -146,7 +533,7 @@ func Test_Get(t *testing.T) {
 				},
 			}
 			app := NewURLShortener(storage, NewRandIDGenerator(8), nil)
-			actualURL, actualErr := app.Get(tt.input)
+			actualURL, actualErr := app.Get(context.Background(), tt.input)
 			assert.Equal(t, tt.want.url.OriginalURL, actualURL.OriginalURL)
 			assert.Equal(t, tt.want.url.ID, actualURL.ID)
 			assert.Equal(t, tt.want.err, actualErr)
@@ -154,3 +541,253 @@ func Test_Get(t *testing.T) {
 		})
 	}
 }
+
+func Test_APIKeyLifecycle(t *testing.T) {
+	app := NewURLShortener(&mockStorage{}, NewRandIDGenerator(8), nil)
+
+	key, plainKey, err := app.CreateAPIKey(context.Background(), "user", "ci")
+	assert.NoError(t, err)
+	assert.NotEqual(t, plainKey, key.Hash)
+
+	userID, err := app.ResolveAPIKey(context.Background(), plainKey)
+	assert.NoError(t, err)
+	assert.Equal(t, "user", userID)
+
+	_, err = app.ResolveAPIKey(context.Background(), plainKey+"x")
+	assert.ErrorIs(t, err, ErrInvalidAPIKey)
+
+	assert.ErrorIs(t, app.RevokeAPIKey(context.Background(), key.ID, "someone else"), ErrNotFound)
+	assert.NoError(t, app.RevokeAPIKey(context.Background(), key.ID, "user"))
+
+	_, err = app.ResolveAPIKey(context.Background(), plainKey)
+	assert.ErrorIs(t, err, ErrInvalidAPIKey)
+
+	// Сбой хранилища не выдается за неверный ключ
+	failing := NewURLShortener(&mockStorage{apiKeysErr: errors.New("connection refused")}, NewRandIDGenerator(8), nil)
+	_, err = failing.ResolveAPIKey(context.Background(), plainKey)
+	assert.ErrorIs(t, err, ErrAPIKeyUnavailable)
+	assert.NotErrorIs(t, err, ErrInvalidAPIKey)
+}
+
+func Test_SignUpAndLogin(t *testing.T) {
+	storage := &mockStorage{owners: map[string]string{"a": "anon1", "b": "anon2", "c": "anon2"}}
+	app := NewURLShortener(storage, NewRandIDGenerator(8), nil)
+
+	_, _, err := app.SignUp(context.Background(), "user", "short", "")
+	assert.ErrorIs(t, err, ErrInvalidPassword)
+
+	user, merged, err := app.SignUp(context.Background(), "user", "long-enough", "anon1")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, merged)
+	assert.Equal(t, user.ID, storage.owners["a"])
+
+	_, _, err = app.SignUp(context.Background(), "user", "long-enough", "")
+	assert.ErrorIs(t, err, ErrLoginTaken)
+
+	_, _, err = app.Login(context.Background(), "user", "wrong-password", "anon2")
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+	assert.Equal(t, "anon2", storage.owners["b"])
+
+	loggedIn, merged, err := app.Login(context.Background(), "user", "long-enough", "anon2")
+	assert.NoError(t, err)
+	assert.Equal(t, user.ID, loggedIn.ID)
+	assert.Equal(t, 2, merged)
+
+	// Ссылки другого зарегистрированного аккаунта не переносятся
+	other, _, err := app.SignUp(context.Background(), "other", "long-enough", "")
+	assert.NoError(t, err)
+	storage.owners["d"] = other.ID
+	_, merged, err = app.Login(context.Background(), "user", "long-enough", other.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, merged)
+	assert.Equal(t, other.ID, storage.owners["d"])
+}
+
+func Test_LoginUnknownUser(t *testing.T) {
+	app := NewURLShortener(&mockStorage{}, NewRandIDGenerator(8), nil)
+	_, _, err := app.Login(context.Background(), "nobody", "password", "")
+	assert.True(t, errors.Is(err, ErrInvalidCredentials))
+}
+
+func Test_LoginExternal(t *testing.T) {
+	storage := &mockStorage{owners: map[string]string{"a": "anon"}}
+	app := NewURLShortener(storage, NewRandIDGenerator(8), nil)
+
+	first, merged, err := app.LoginExternal(context.Background(), "https://idp|42", "anon")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, merged)
+
+	second, _, err := app.LoginExternal(context.Background(), "https://idp|42", "")
+	assert.NoError(t, err)
+	assert.Equal(t, first.ID, second.ID)
+
+	// Вход по паролю для внешнего аккаунта невозможен
+	_, _, err = app.Login(context.Background(), "https://idp|42", "", "")
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+}
+
+func Test_LoginExternalLoginTakenBeforehand(t *testing.T) {
+	app := NewURLShortener(&mockStorage{}, NewRandIDGenerator(8), nil)
+
+	// Логин будущего внешнего аккаунта нельзя занять заранее
+	_, _, err := app.SignUp(context.Background(), "https://idp|42", "long-enough", "")
+	assert.ErrorIs(t, err, ErrInvalidLogin)
+
+	user, _, err := app.LoginExternal(context.Background(), "https://idp|42", "")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://idp|42", user.ExternalID)
+}
+
+func Test_LoginExternalLongID(t *testing.T) {
+	storage := &mockStorage{}
+	app := NewURLShortener(storage, NewRandIDGenerator(8), nil)
+	externalID := "https://login.example.com/" + strings.Repeat("tenant/", 20) + "|" + strings.Repeat("s", 200)
+
+	user, _, err := app.LoginExternal(context.Background(), externalID, "")
+	require.NoError(t, err)
+	assert.LessOrEqual(t, len(user.Login), maxLoginLen)
+	assert.Equal(t, externalID, user.ExternalID)
+
+	// Логин выводится из id детерминированно, повторный вход находит тот же аккаунт
+	again, _, err := app.LoginExternal(context.Background(), externalID, "")
+	require.NoError(t, err)
+	assert.Equal(t, user.ID, again.ID)
+	assert.Len(t, storage.users, 1)
+}
+
+func Test_Sessions(t *testing.T) {
+	storage := &mockStorage{}
+	app := NewURLShortener(storage, NewRandIDGenerator(8), nil)
+	now := time.Now()
+
+	assert.NoError(t, app.RecordSession(context.Background(), models.Session{ID: "s1", UUID: "user", IssuedAt: now, ExpiresAt: now.Add(time.Hour)}))
+	assert.NoError(t, app.RecordSession(context.Background(), models.Session{ID: "s2", UUID: "user", IssuedAt: now, ExpiresAt: now.Add(time.Hour)}))
+	assert.NoError(t, app.RecordSession(context.Background(), models.Session{ID: "old", UUID: "user", IssuedAt: now.Add(-2 * time.Hour), ExpiresAt: now.Add(-time.Hour)}))
+
+	active, err := app.GetActiveSessions(context.Background(), "user")
+	assert.NoError(t, err)
+	assert.Len(t, active, 2)
+
+	_, err = app.RevokeSession(context.Background(), "s1", "someone else")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	revoked, err := app.RevokeSession(context.Background(), "s1", "user")
+	assert.NoError(t, err)
+	assert.True(t, revoked.Revoked)
+
+	active, err = app.GetActiveSessions(context.Background(), "user")
+	assert.NoError(t, err)
+	assert.Equal(t, "s2", active[0].ID)
+	assert.Len(t, active, 1)
+}
+
+func Test_SyncRevokedSessions(t *testing.T) {
+	storage := &mockStorage{}
+	first := NewURLShortener(storage, NewRandIDGenerator(8), nil)
+	second := NewURLShortener(storage, NewRandIDGenerator(8), nil)
+	kr, err := auth.NewKeyring("k1", auth.Key{ID: "k1", Secret: "secret"})
+	require.NoError(t, err)
+	firstAuth := auth.NewAuthWithKeyring(kr)
+
+	token, err := firstAuth.IssueToken("user")
+	require.NoError(t, err)
+	require.NoError(t, first.RecordSession(context.Background(), models.Session{
+		ID: token.ID, UUID: "user", IssuedAt: token.IssuedAt, ExpiresAt: token.ExpiresAt,
+	}))
+
+	// Сессию отзывает другой инстанс, первый узнает об этом только из хранилища
+	_, err = second.RevokeSession(context.Background(), token.ID, "user")
+	require.NoError(t, err)
+	_, err = firstAuth.ParseToken(token.Value)
+	require.NoError(t, err)
+
+	require.NoError(t, first.SyncRevokedSessions(context.Background(), firstAuth.Revoke))
+	_, err = firstAuth.ParseToken(token.Value)
+	assert.ErrorIs(t, err, auth.ErrTokenRevoked)
+}
+
+func Test_Organizations(t *testing.T) {
+	storage := &mockStorage{}
+	app := NewURLShortener(storage, NewRandIDGenerator(8), nil)
+	owner := "6b1a1c59-0c2b-4f5e-9d1a-7b8e2f1c3d4e"
+	member := "0f8e6a2d-3c4b-4a1e-8f7d-2b9c1e5a6d3f"
+
+	_, err := app.CreateOrganization(context.Background(), "  ", owner)
+	assert.ErrorIs(t, err, ErrInvalidOrgName)
+
+	org, err := app.CreateOrganization(context.Background(), "Team", owner)
+	assert.NoError(t, err)
+
+	orgs, err := app.GetOrganizations(context.Background(), owner)
+	assert.NoError(t, err)
+	assert.Len(t, orgs, 1)
+	assert.Equal(t, models.RoleOwner, orgs[0].Role)
+
+	// Не участник не должен узнать о существовании организации
+	_, err = app.GetOrgURLs(context.Background(), org.ID, member)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	assert.ErrorIs(t, app.SetMemberRole(context.Background(), org.ID, owner, member, "admin"), ErrInvalidRole)
+	assert.ErrorIs(t, app.SetMemberRole(context.Background(), org.ID, owner, "not-a-uuid", models.RoleViewer), ErrInvalidUUID)
+	assert.NoError(t, app.SetMemberRole(context.Background(), org.ID, owner, member, models.RoleViewer))
+
+	_, err = app.GetOrgURLs(context.Background(), org.ID, member)
+	assert.NoError(t, err)
+	_, err = app.CreateOrgURL(context.Background(), org.ID, member, "https://example.com", models.RedirectOptions{})
+	assert.ErrorIs(t, err, ErrForbidden)
+	_, err = app.TransferURLs(context.Background(), org.ID, member, []string{"a"})
+	assert.ErrorIs(t, err, ErrForbidden)
+	assert.ErrorIs(t, app.SetMemberRole(context.Background(), org.ID, member, member, models.RoleOwner), ErrForbidden)
+
+	assert.ErrorIs(t, app.SetMemberRole(context.Background(), org.ID, owner, owner, models.RoleEditor), ErrLastOwner)
+	assert.ErrorIs(t, app.RemoveMember(context.Background(), org.ID, owner, owner), ErrLastOwner)
+
+	assert.NoError(t, app.SetMemberRole(context.Background(), org.ID, owner, member, models.RoleEditor))
+	n, err := app.TransferURLs(context.Background(), org.ID, member, []string{"a", "b"})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	// Участник может сам выйти из организации
+	assert.NoError(t, app.RemoveMember(context.Background(), org.ID, member, member))
+	orgs, err = app.GetOrganizations(context.Background(), member)
+	assert.NoError(t, err)
+	assert.Empty(t, orgs)
+}
+
+func Test_OrgURLsScoping(t *testing.T) {
+	var saved models.ShortURL
+	storage := &mockStorage{
+		SaveFunc: func(url models.ShortURL) (models.ShortURL, error) {
+			saved = url
+			return url, nil
+		},
+	}
+	app := NewURLShortener(storage, NewRandIDGenerator(8), nopLogger{})
+	owner := "6b1a1c59-0c2b-4f5e-9d1a-7b8e2f1c3d4e"
+	org, err := app.CreateOrganization(context.Background(), "Team", owner)
+	require.NoError(t, err)
+
+	redirect := models.RedirectOptions{RedirectCode: http.StatusMovedPermanently}
+	_, err = app.CreateOrgURL(context.Background(), org.ID, owner, "https://example.com", redirect)
+	require.NoError(t, err)
+	assert.Equal(t, redirect, saved.RedirectOptions)
+	_, err = app.CreateOrgURL(context.Background(), org.ID, owner, "https://example.com", models.RedirectOptions{RedirectCode: 200})
+	assert.ErrorIs(t, err, ErrInvalidRedirect)
+
+	// Через организацию удаляются только ее ссылки, даже если у пользователя есть права на другие
+	storage.urls = []models.ShortURL{
+		{ID: "a", OrgID: org.ID},
+		{ID: "b", OrgID: "other-org"},
+		{ID: "c", UUID: owner},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		app.ProcessLinkDeletion(ctx)
+		close(done)
+	}()
+	require.NoError(t, app.DeleteOrgURLs(context.Background(), org.ID, owner, []string{"a", "b", "c"}))
+	cancel()
+	<-done
+	assert.Equal(t, []models.Deletion{{UserID: owner, URLID: "a"}}, storage.deleted)
+}
@@ -2,6 +2,7 @@ package storages
 
 import (
 	"context"
+	"time"
 
 	"github.com/maxzhirnov/urlshort/internal/models"
 )
@@ -19,11 +20,11 @@ func NewCombinedStorage(safeMap *MemoryStorage, safeFile *FileStorage) *Combined
 }
 
 func (s *CombinedStorage) InsertURL(ctx context.Context, url models.ShortURL) (models.ShortURL, error) {
-	if _, err := s.safeMap.InsertURL(context.Background(), url); err != nil {
+	if _, err := s.safeMap.InsertURL(ctx, url); err != nil {
 		return models.ShortURL{}, err
 	}
 
-	if _, err := s.safeFile.InsertURL(context.Background(), url); err != nil {
+	if _, err := s.safeFile.InsertURL(ctx, url); err != nil {
 		return models.ShortURL{}, err
 	}
 
@@ -43,11 +44,11 @@ func (s *CombinedStorage) InsertURLMany(ctx context.Context, urls []models.Short
 }
 
 func (s *CombinedStorage) GetURLByID(ctx context.Context, id string) (models.ShortURL, bool) {
-	return s.safeMap.GetURLByID(context.Background(), id)
+	return s.safeMap.GetURLByID(ctx, id)
 }
 
 func (s *CombinedStorage) GetURLByOriginalURL(ctx context.Context, url string) (models.ShortURL, bool) {
-	return s.safeMap.GetURLByOriginalURL(context.Background(), url)
+	return s.safeMap.GetURLByOriginalURL(ctx, url)
 }
 
 func (s *CombinedStorage) GetURLsByUUID(ctx context.Context, uuid string) ([]models.ShortURL, error) {
@@ -58,14 +59,159 @@ func (s *CombinedStorage) Bootstrap() error {
 	return s.safeFile.initializeData(s.safeMap)
 }
 
+// TagURLsDeleted updates links in memory and appends updated links to the file,
+// the last record of a link wins when the file is loaded
 func (s *CombinedStorage) TagURLsDeleted(ctx context.Context, urlsToDelete []models.Deletion) error {
-	return nil
+	return s.safeFile.InsertURLMany(ctx, s.safeMap.tagURLsDeleted(urlsToDelete))
+}
+
+func (s *CombinedStorage) TagURLsDeletedByID(ctx context.Context, ids []string) error {
+	return s.safeFile.InsertURLMany(ctx, s.safeMap.tagURLsDeletedByID(ids))
+}
+
+func (s *CombinedStorage) SetURLsDisabled(ctx context.Context, ids []string, disabled bool) error {
+	return s.safeFile.InsertURLMany(ctx, s.safeMap.setURLsDisabled(ids, disabled))
+}
+
+func (s *CombinedStorage) SetURLRules(ctx context.Context, id string, rules []models.RedirectRule) error {
+	return s.safeFile.InsertURLMany(ctx, s.safeMap.setURLRules(id, rules))
+}
+
+func (s *CombinedStorage) SearchURLs(ctx context.Context, filter models.URLFilter) ([]models.ShortURL, error) {
+	return s.safeMap.SearchURLs(ctx, filter)
+}
+
+func (s *CombinedStorage) ListUsers(ctx context.Context, query string, limit, offset int) ([]models.User, error) {
+	return s.safeMap.ListUsers(ctx, query, limit, offset)
+}
+
+func (s *CombinedStorage) Stats(ctx context.Context) (models.Stats, error) {
+	return s.safeMap.Stats(ctx)
 }
 
 func (s *CombinedStorage) Ping() error {
 	return nil
 }
 
+func (s *CombinedStorage) CheckWritable() error {
+	return s.safeFile.CheckWritable()
+}
+
 func (s *CombinedStorage) Close() error {
 	return s.safeFile.Close()
 }
+
+func (s *CombinedStorage) InsertAPIKey(ctx context.Context, key models.APIKey) error {
+	if err := s.safeMap.InsertAPIKey(ctx, key); err != nil {
+		return err
+	}
+	return s.safeFile.InsertAPIKey(ctx, key)
+}
+
+func (s *CombinedStorage) GetAPIKeyByHash(ctx context.Context, hash string) (models.APIKey, bool, error) {
+	return s.safeMap.GetAPIKeyByHash(ctx, hash)
+}
+
+func (s *CombinedStorage) GetAPIKeysByUUID(ctx context.Context, uuid string) ([]models.APIKey, error) {
+	return s.safeMap.GetAPIKeysByUUID(ctx, uuid)
+}
+
+func (s *CombinedStorage) RevokeAPIKey(ctx context.Context, id, uuid string) error {
+	revoked, err := s.safeMap.revokeAPIKey(id, uuid)
+	if err != nil {
+		return err
+	}
+	return s.safeFile.InsertAPIKey(ctx, revoked)
+}
+
+func (s *CombinedStorage) InsertUser(ctx context.Context, user models.User) error {
+	if err := s.safeMap.InsertUser(ctx, user); err != nil {
+		return err
+	}
+	return s.safeFile.InsertUser(ctx, user)
+}
+
+func (s *CombinedStorage) GetUserByID(ctx context.Context, id string) (models.User, bool) {
+	return s.safeMap.GetUserByID(ctx, id)
+}
+
+func (s *CombinedStorage) GetUserByLogin(ctx context.Context, login string) (models.User, bool) {
+	return s.safeMap.GetUserByLogin(ctx, login)
+}
+
+func (s *CombinedStorage) GetUserByExternalID(ctx context.Context, externalID string) (models.User, bool) {
+	return s.safeMap.GetUserByExternalID(ctx, externalID)
+}
+
+// ReassignURLs changes owner in memory and appends updated links to the file,
+// the last record of a link wins when the file is loaded
+func (s *CombinedStorage) ReassignURLs(ctx context.Context, fromUUID, toUUID string) (int, error) {
+	reassigned := s.safeMap.reassignURLs(fromUUID, toUUID)
+	if err := s.safeFile.InsertURLMany(ctx, reassigned); err != nil {
+		return 0, err
+	}
+	return len(reassigned), nil
+}
+
+func (s *CombinedStorage) InsertSession(ctx context.Context, session models.Session) error {
+	if err := s.safeMap.InsertSession(ctx, session); err != nil {
+		return err
+	}
+	return s.safeFile.InsertSession(ctx, session)
+}
+
+func (s *CombinedStorage) GetSessionsByUUID(ctx context.Context, uuid string) ([]models.Session, error) {
+	return s.safeMap.GetSessionsByUUID(ctx, uuid)
+}
+
+func (s *CombinedStorage) RevokeSession(ctx context.Context, session models.Session) error {
+	return s.safeFile.InsertSession(ctx, s.safeMap.revokeSession(session))
+}
+
+func (s *CombinedStorage) GetRevokedSessions(ctx context.Context, expiresAfter time.Time) ([]models.Session, error) {
+	return s.safeMap.GetRevokedSessions(ctx, expiresAfter)
+}
+
+// DeleteExpiredSessions cleans memory only, the journal is append-only and expired
+// sessions are skipped when it is loaded
+func (s *CombinedStorage) DeleteExpiredSessions(ctx context.Context, expiredBefore time.Time) (int, error) {
+	return s.safeMap.DeleteExpiredSessions(ctx, expiredBefore)
+}
+
+func (s *CombinedStorage) InsertOrganization(ctx context.Context, org models.Organization) error {
+	if err := s.safeMap.InsertOrganization(ctx, org); err != nil {
+		return err
+	}
+	return s.safeFile.InsertOrganization(ctx, org)
+}
+
+func (s *CombinedStorage) GetOrganization(ctx context.Context, id string) (models.Organization, bool) {
+	return s.safeMap.GetOrganization(ctx, id)
+}
+
+func (s *CombinedStorage) SetMembership(ctx context.Context, m models.Membership) error {
+	if err := s.safeMap.SetMembership(ctx, m); err != nil {
+		return err
+	}
+	return s.safeFile.SetMembership(ctx, m)
+}
+
+func (s *CombinedStorage) GetMembershipsByUUID(ctx context.Context, uuid string) ([]models.Membership, error) {
+	return s.safeMap.GetMembershipsByUUID(ctx, uuid)
+}
+
+func (s *CombinedStorage) GetOrgMembers(ctx context.Context, orgID string) ([]models.Membership, error) {
+	return s.safeMap.GetOrgMembers(ctx, orgID)
+}
+
+func (s *CombinedStorage) GetURLsByOrg(ctx context.Context, orgID string) ([]models.ShortURL, error) {
+	return s.safeMap.GetURLsByOrg(ctx, orgID)
+}
+
+func (s *CombinedStorage) SetURLsOrg(ctx context.Context, ids []string, uuid, orgID string) (int, error) {
+	moved := s.safeMap.setURLsOrg(ids, uuid, orgID)
+	if err := s.safeFile.InsertURLMany(ctx, moved); err != nil {
+		return 0, err
+	}
+	return len(moved), nil
+}
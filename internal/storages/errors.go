@@ -6,4 +6,5 @@ import (
 
 var (
 	ErrEntityAlreadyExist = errors.New("entity already exist")
+	ErrNotFound           = errors.New("entity not found")
 )
@@ -6,7 +6,9 @@ import (
 	"encoding/json"
 	"io"
 	"os"
+	"path/filepath"
 	"sync"
+	"time"
 
 	"github.com/maxzhirnov/urlshort/internal/models"
 )
@@ -16,6 +18,12 @@ type FileStorage struct {
 	writer  *bufio.Writer
 	scanner *bufio.Scanner
 	mu      sync.RWMutex
+
+	apiKeys  *journal[models.APIKey]
+	users    *journal[models.User]
+	sessions *journal[models.Session]
+	orgs     *journal[models.Organization]
+	members  *journal[models.Membership]
 }
 
 func NewFileStorage(filePath string) (*FileStorage, error) {
@@ -27,10 +35,56 @@ func NewFileStorage(filePath string) (*FileStorage, error) {
 	if err != nil {
 		return nil, err
 	}
+
+	apiKeys, err := openJournal[models.APIKey](filePath + ".apikeys")
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	users, err := openJournal[models.User](filePath + ".users")
+	if err != nil {
+		apiKeys.Close()
+		file.Close()
+		return nil, err
+	}
+
+	sessions, err := openJournal[models.Session](filePath + ".sessions")
+	if err != nil {
+		users.Close()
+		apiKeys.Close()
+		file.Close()
+		return nil, err
+	}
+
+	orgs, err := openJournal[models.Organization](filePath + ".orgs")
+	if err != nil {
+		sessions.Close()
+		users.Close()
+		apiKeys.Close()
+		file.Close()
+		return nil, err
+	}
+
+	members, err := openJournal[models.Membership](filePath + ".members")
+	if err != nil {
+		orgs.Close()
+		sessions.Close()
+		users.Close()
+		apiKeys.Close()
+		file.Close()
+		return nil, err
+	}
+
 	return &FileStorage{
-		file:    file,
-		writer:  bufio.NewWriter(file),
-		scanner: bufio.NewScanner(file),
+		file:     file,
+		writer:   bufio.NewWriter(file),
+		scanner:  bufio.NewScanner(file),
+		apiKeys:  apiKeys,
+		users:    users,
+		sessions: sessions,
+		orgs:     orgs,
+		members:  members,
 	}, nil
 }
 
@@ -103,6 +157,30 @@ func (s *FileStorage) TagURLsDeleted(ctx context.Context, urlsToDelete []models.
 	return nil
 }
 
+func (s *FileStorage) TagURLsDeletedByID(ctx context.Context, ids []string) error {
+	return nil
+}
+
+func (s *FileStorage) SetURLsDisabled(ctx context.Context, ids []string, disabled bool) error {
+	return nil
+}
+
+func (s *FileStorage) SetURLRules(ctx context.Context, id string, rules []models.RedirectRule) error {
+	return nil
+}
+
+func (s *FileStorage) SearchURLs(ctx context.Context, filter models.URLFilter) ([]models.ShortURL, error) {
+	return nil, nil
+}
+
+func (s *FileStorage) ListUsers(ctx context.Context, query string, limit, offset int) ([]models.User, error) {
+	return nil, nil
+}
+
+func (s *FileStorage) Stats(ctx context.Context) (models.Stats, error) {
+	return models.Stats{}, nil
+}
+
 func (s *FileStorage) Bootstrap() error {
 	return nil
 }
@@ -111,7 +189,41 @@ func (s *FileStorage) Ping() error {
 	return nil
 }
 
+// CheckWritable makes sure the data file still exists and its directory accepts
+// new files, e.g. the disk isn't full or remounted read-only
+func (s *FileStorage) CheckWritable() error {
+	path := s.file.Name()
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+	probe, err := os.CreateTemp(filepath.Dir(path), ".healthcheck-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(probe.Name())
+	if _, err := probe.Write([]byte{'\n'}); err != nil {
+		probe.Close()
+		return err
+	}
+	return probe.Close()
+}
+
 func (s *FileStorage) Close() error {
+	if err := s.apiKeys.Close(); err != nil {
+		return err
+	}
+	if err := s.users.Close(); err != nil {
+		return err
+	}
+	if err := s.sessions.Close(); err != nil {
+		return err
+	}
+	if err := s.orgs.Close(); err != nil {
+		return err
+	}
+	if err := s.members.Close(); err != nil {
+		return err
+	}
 	return s.file.Close()
 }
 
@@ -126,7 +238,37 @@ func (s *FileStorage) initializeData(memoryStorage *MemoryStorage) error {
 			return err
 		}
 	}
-	return nil
+
+	if err := s.apiKeys.replay(func(k models.APIKey) error {
+		return memoryStorage.InsertAPIKey(context.Background(), k)
+	}); err != nil {
+		return err
+	}
+
+	if err := s.users.replay(func(u models.User) error {
+		return memoryStorage.InsertUser(context.Background(), u)
+	}); err != nil {
+		return err
+	}
+
+	if err := s.sessions.replay(func(session models.Session) error {
+		return memoryStorage.InsertSession(context.Background(), session)
+	}); err != nil {
+		return err
+	}
+	if _, err := memoryStorage.DeleteExpiredSessions(context.Background(), time.Now()); err != nil {
+		return err
+	}
+
+	if err := s.orgs.replay(func(org models.Organization) error {
+		return memoryStorage.InsertOrganization(context.Background(), org)
+	}); err != nil {
+		return err
+	}
+
+	return s.members.replay(func(m models.Membership) error {
+		return memoryStorage.SetMembership(context.Background(), m)
+	})
 }
 
 func (s *FileStorage) loadAll() ([]models.ShortURL, error) {
@@ -147,3 +289,90 @@ func (s *FileStorage) loadAll() ([]models.ShortURL, error) {
 
 	return shortURLs, nil
 }
+
+// InsertAPIKey appends api key record to the journal, the last record with the same id wins on load
+func (s *FileStorage) InsertAPIKey(ctx context.Context, key models.APIKey) error {
+	return s.apiKeys.append(key)
+}
+
+func (s *FileStorage) GetAPIKeyByHash(ctx context.Context, hash string) (models.APIKey, bool, error) {
+	return models.APIKey{}, false, nil
+}
+
+func (s *FileStorage) GetAPIKeysByUUID(ctx context.Context, uuid string) ([]models.APIKey, error) {
+	return nil, nil
+}
+
+func (s *FileStorage) RevokeAPIKey(ctx context.Context, id, uuid string) error {
+	return nil
+}
+
+func (s *FileStorage) InsertUser(ctx context.Context, user models.User) error {
+	return s.users.append(user)
+}
+
+func (s *FileStorage) GetUserByID(ctx context.Context, id string) (models.User, bool) {
+	return models.User{}, false
+}
+
+func (s *FileStorage) GetUserByLogin(ctx context.Context, login string) (models.User, bool) {
+	return models.User{}, false
+}
+
+func (s *FileStorage) GetUserByExternalID(ctx context.Context, externalID string) (models.User, bool) {
+	return models.User{}, false
+}
+
+func (s *FileStorage) ReassignURLs(ctx context.Context, fromUUID, toUUID string) (int, error) {
+	return 0, nil
+}
+
+// InsertSession appends session record to the journal, expired sessions are dropped on load
+func (s *FileStorage) InsertSession(ctx context.Context, session models.Session) error {
+	return s.sessions.append(session)
+}
+
+func (s *FileStorage) GetSessionsByUUID(ctx context.Context, uuid string) ([]models.Session, error) {
+	return nil, nil
+}
+
+func (s *FileStorage) RevokeSession(ctx context.Context, session models.Session) error {
+	return nil
+}
+
+func (s *FileStorage) GetRevokedSessions(ctx context.Context, expiresAfter time.Time) ([]models.Session, error) {
+	return nil, nil
+}
+
+func (s *FileStorage) DeleteExpiredSessions(ctx context.Context, expiredBefore time.Time) (int, error) {
+	return 0, nil
+}
+
+func (s *FileStorage) InsertOrganization(ctx context.Context, org models.Organization) error {
+	return s.orgs.append(org)
+}
+
+func (s *FileStorage) GetOrganization(ctx context.Context, id string) (models.Organization, bool) {
+	return models.Organization{}, false
+}
+
+// SetMembership appends membership record, record with empty role removes the member on load
+func (s *FileStorage) SetMembership(ctx context.Context, m models.Membership) error {
+	return s.members.append(m)
+}
+
+func (s *FileStorage) GetMembershipsByUUID(ctx context.Context, uuid string) ([]models.Membership, error) {
+	return nil, nil
+}
+
+func (s *FileStorage) GetOrgMembers(ctx context.Context, orgID string) ([]models.Membership, error) {
+	return nil, nil
+}
+
+func (s *FileStorage) GetURLsByOrg(ctx context.Context, orgID string) ([]models.ShortURL, error) {
+	return nil, nil
+}
+
+func (s *FileStorage) SetURLsOrg(ctx context.Context, ids []string, uuid, orgID string) (int, error) {
+	return 0, nil
+}
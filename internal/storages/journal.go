@@ -0,0 +1,75 @@
+package storages
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+)
+
+// journal is an append-only json lines file, every change of an entity is appended
+// as a full record and the last record of an entity wins when the file is replayed
+type journal[T any] struct {
+	mu     sync.Mutex
+	file   *os.File
+	writer *bufio.Writer
+}
+
+func openJournal[T any](path string) (*journal[T], error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return nil, err
+	}
+	return &journal[T]{
+		file:   file,
+		writer: bufio.NewWriter(file),
+	}, nil
+}
+
+func (j *journal[T]) append(records ...T) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for _, r := range records {
+		data, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		if _, err := j.writer.Write(data); err != nil {
+			return err
+		}
+		if err := j.writer.WriteByte('\n'); err != nil {
+			return err
+		}
+	}
+	return j.writer.Flush()
+}
+
+// replay calls fn for every record in the order they were appended
+func (j *journal[T]) replay(fn func(T) error) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if _, err := j.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	scanner := bufio.NewScanner(j.file)
+	for scanner.Scan() {
+		var record T
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return err
+		}
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func (j *journal[T]) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if err := j.writer.Flush(); err != nil {
+		return err
+	}
+	return j.file.Close()
+}
@@ -2,52 +2,63 @@ package storages
 
 import (
 	"context"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/maxzhirnov/urlshort/internal/models"
 )
 
 type MemoryStorage struct {
-	mu sync.RWMutex
-	m  map[string]string
+	mu      sync.RWMutex
+	m       map[string]models.ShortURL
+	apiKeys map[string]models.APIKey
+	// apiKeyIDs maps hash of api key to its id, keys are looked up by hash on every request
+	apiKeyIDs map[string]string
+	users     map[string]models.User
+	orgs      map[string]models.Organization
+	// members maps org id to roles of its members by uuid
+	members map[string]map[string]models.OrgRole
+	// sessions хранятся отдельным мьютексом, они меняются на каждый логин и logout
+	sessionsMu sync.RWMutex
+	sessions   map[string]models.Session
 }
 
 func NewMemoryStorage() *MemoryStorage {
 	return &MemoryStorage{
-		m: make(map[string]string),
+		m:         make(map[string]models.ShortURL),
+		apiKeys:   make(map[string]models.APIKey),
+		apiKeyIDs: make(map[string]string),
+		users:     make(map[string]models.User),
+		orgs:      make(map[string]models.Organization),
+		members:   make(map[string]map[string]models.OrgRole),
+		sessions:  make(map[string]models.Session),
 	}
 }
 
 func (s *MemoryStorage) GetURLByID(ctx context.Context, id string) (models.ShortURL, bool) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	url := models.ShortURL{}
-	var ok bool
-	url.OriginalURL, ok = s.m[id]
-	if ok {
-		url.ID = id
-	}
+	url, ok := s.m[id]
 	return url, ok
 }
 
 func (s *MemoryStorage) GetURLByOriginalURL(ctx context.Context, url string) (models.ShortURL, bool) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	var urlFound models.ShortURL
-	for k, v := range s.m {
-		if v == url {
-			urlFound.OriginalURL = v
-			urlFound.ID = k
-			return urlFound, true
+	for _, v := range s.m {
+		if v.OriginalURL == url {
+			return v, true
 		}
 	}
-	return urlFound, false
+	return models.ShortURL{}, false
 }
 
 func (s *MemoryStorage) InsertURL(ctx context.Context, url models.ShortURL) (models.ShortURL, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.m[url.ID] = url.OriginalURL
+	s.m[url.ID] = url
 	return url, nil
 }
 
@@ -61,11 +72,158 @@ func (s *MemoryStorage) InsertURLMany(ctx context.Context, urls []models.ShortUR
 }
 
 func (s *MemoryStorage) TagURLsDeleted(ctx context.Context, urlsToDelete []models.Deletion) error {
+	s.tagURLsDeleted(urlsToDelete)
+	return nil
+}
+
+// tagURLsDeleted sets deleted flag on personal links of the users requested deletion
+// and on links of organizations where they are editors, and returns updated links.
+// Creator of an organization link has no access to it beyond their role in the organization
+func (s *MemoryStorage) tagURLsDeleted(urlsToDelete []models.Deletion) []models.ShortURL {
+	// Одну ссылку в пачке могут удалять несколько пользователей, права проверяются у каждого
+	requesters := make(map[string][]string, len(urlsToDelete))
+	for _, d := range urlsToDelete {
+		requesters[d.URLID] = append(requesters[d.URLID], d.UserID)
+	}
+
+	s.mu.RLock()
+	editable := make(map[string]map[string]struct{})
+	for _, d := range urlsToDelete {
+		if _, ok := editable[d.UserID]; !ok {
+			editable[d.UserID] = s.orgsWithRoleLocked(d.UserID, models.RoleEditor)
+		}
+	}
+	s.mu.RUnlock()
+
+	return s.updateURLs(func(u *models.ShortURL) bool {
+		if u.DeletedFlag {
+			return false
+		}
+		for _, userID := range requesters[u.ID] {
+			if canAccess(u, userID, editable[userID]) {
+				u.DeletedFlag = true
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// canAccess reports whether user created the personal link or the link belongs to one of orgs
+func canAccess(u *models.ShortURL, uuid string, orgs map[string]struct{}) bool {
+	if u.OrgID == "" {
+		return u.UUID == uuid
+	}
+	_, ok := orgs[u.OrgID]
+	return ok
+}
+
+// orgsWithRoleLocked returns ids of organizations where user has at least the role
+func (s *MemoryStorage) orgsWithRoleLocked(uuid string, role models.OrgRole) map[string]struct{} {
+	orgs := make(map[string]struct{})
+	for orgID, members := range s.members {
+		if members[uuid].Allows(role) {
+			orgs[orgID] = struct{}{}
+		}
+	}
+	return orgs
+}
+
+func (s *MemoryStorage) TagURLsDeletedByID(ctx context.Context, ids []string) error {
+	s.tagURLsDeletedByID(ids)
+	return nil
+}
+
+func (s *MemoryStorage) tagURLsDeletedByID(ids []string) []models.ShortURL {
+	set := toSet(ids)
+	return s.updateURLs(func(u *models.ShortURL) bool {
+		if _, ok := set[u.ID]; !ok || u.DeletedFlag {
+			return false
+		}
+		u.DeletedFlag = true
+		return true
+	})
+}
+
+func (s *MemoryStorage) SetURLsDisabled(ctx context.Context, ids []string, disabled bool) error {
+	s.setURLsDisabled(ids, disabled)
+	return nil
+}
+
+func (s *MemoryStorage) setURLsDisabled(ids []string, disabled bool) []models.ShortURL {
+	set := toSet(ids)
+	return s.updateURLs(func(u *models.ShortURL) bool {
+		if _, ok := set[u.ID]; !ok || u.Disabled == disabled {
+			return false
+		}
+		u.Disabled = disabled
+		return true
+	})
+}
+
+func (s *MemoryStorage) SetURLRules(ctx context.Context, id string, rules []models.RedirectRule) error {
+	s.setURLRules(id, rules)
 	return nil
 }
 
+func (s *MemoryStorage) setURLRules(id string, rules []models.RedirectRule) []models.ShortURL {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.m[id]
+	if !ok {
+		return nil
+	}
+	u.Rules = rules
+	s.m[id] = u
+	return []models.ShortURL{u}
+}
+
+// updateURLs applies fn to every link and returns links changed by fn
+func (s *MemoryStorage) updateURLs(fn func(u *models.ShortURL) bool) []models.ShortURL {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	updated := make([]models.ShortURL, 0)
+	for id, u := range s.m {
+		if fn(&u) {
+			s.m[id] = u
+			updated = append(updated, u)
+		}
+	}
+	return updated
+}
+
+func (s *MemoryStorage) SearchURLs(ctx context.Context, filter models.URLFilter) ([]models.ShortURL, error) {
+	s.mu.RLock()
+	urls := make([]models.ShortURL, 0)
+	for _, u := range s.m {
+		if filter.UUID != "" && u.UUID != filter.UUID {
+			continue
+		}
+		if filter.Query != "" && u.ID != filter.Query && !strings.Contains(u.OriginalURL, filter.Query) {
+			continue
+		}
+		urls = append(urls, u)
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(urls, func(i, j int) bool {
+		return urls[i].ID < urls[j].ID
+	})
+	return paginate(urls, filter.Limit, filter.Offset), nil
+}
+
+// GetURLsByUUID returns links created by the user and links of organizations the user is member of
 func (s *MemoryStorage) GetURLsByUUID(ctx context.Context, uuid string) ([]models.ShortURL, error) {
-	return nil, nil
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	orgs := s.orgsWithRoleLocked(uuid, models.RoleViewer)
+	urls := make([]models.ShortURL, 0)
+	for _, u := range s.m {
+		if canAccess(&u, uuid, orgs) {
+			urls = append(urls, u)
+		}
+	}
+	return urls, nil
 }
 
 func (s *MemoryStorage) Bootstrap() error {
@@ -79,3 +237,331 @@ func (s *MemoryStorage) Ping() error {
 func (s *MemoryStorage) Close() error {
 	return nil
 }
+
+// InsertAPIKey saves api key, key with the same id is replaced
+func (s *MemoryStorage) InsertAPIKey(ctx context.Context, key models.APIKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if old, ok := s.apiKeys[key.ID]; ok {
+		delete(s.apiKeyIDs, old.Hash)
+	}
+	s.apiKeys[key.ID] = key
+	s.apiKeyIDs[key.Hash] = key.ID
+	return nil
+}
+
+func (s *MemoryStorage) GetAPIKeyByHash(ctx context.Context, hash string) (models.APIKey, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	id, ok := s.apiKeyIDs[hash]
+	if !ok {
+		return models.APIKey{}, false, nil
+	}
+	return s.apiKeys[id], true, nil
+}
+
+func (s *MemoryStorage) GetAPIKeysByUUID(ctx context.Context, uuid string) ([]models.APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	keys := make([]models.APIKey, 0)
+	for _, k := range s.apiKeys {
+		if k.UUID == uuid {
+			keys = append(keys, k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+	})
+	return keys, nil
+}
+
+func (s *MemoryStorage) RevokeAPIKey(ctx context.Context, id, uuid string) error {
+	_, err := s.revokeAPIKey(id, uuid)
+	return err
+}
+
+func (s *MemoryStorage) revokeAPIKey(id, uuid string) (models.APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k, ok := s.apiKeys[id]
+	if !ok || k.UUID != uuid {
+		return models.APIKey{}, ErrNotFound
+	}
+	k.Revoked = true
+	s.apiKeys[id] = k
+	return k, nil
+}
+
+func (s *MemoryStorage) InsertUser(ctx context.Context, user models.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, u := range s.users {
+		if u.Login == user.Login && u.ID != user.ID {
+			return ErrEntityAlreadyExist
+		}
+	}
+	s.users[user.ID] = user
+	return nil
+}
+
+func (s *MemoryStorage) GetUserByID(ctx context.Context, id string) (models.User, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	u, ok := s.users[id]
+	return u, ok
+}
+
+func (s *MemoryStorage) GetUserByLogin(ctx context.Context, login string) (models.User, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, u := range s.users {
+		if u.Login == login {
+			return u, true
+		}
+	}
+	return models.User{}, false
+}
+
+func (s *MemoryStorage) GetUserByExternalID(ctx context.Context, externalID string) (models.User, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, u := range s.users {
+		if u.ExternalID != "" && u.ExternalID == externalID {
+			return u, true
+		}
+	}
+	return models.User{}, false
+}
+
+func (s *MemoryStorage) ReassignURLs(ctx context.Context, fromUUID, toUUID string) (int, error) {
+	reassigned := s.reassignURLs(fromUUID, toUUID)
+	return len(reassigned), nil
+}
+
+// reassignURLs changes owner of the links and returns updated links
+func (s *MemoryStorage) reassignURLs(fromUUID, toUUID string) []models.ShortURL {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	reassigned := make([]models.ShortURL, 0)
+	for id, u := range s.m {
+		if u.UUID == fromUUID {
+			u.UUID = toUUID
+			s.m[id] = u
+			reassigned = append(reassigned, u)
+		}
+	}
+	return reassigned
+}
+
+func (s *MemoryStorage) ListUsers(ctx context.Context, query string, limit, offset int) ([]models.User, error) {
+	s.mu.RLock()
+	users := make([]models.User, 0)
+	for _, u := range s.users {
+		if query == "" || u.ID == query || strings.Contains(u.Login, query) {
+			users = append(users, u)
+		}
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].CreatedAt.Before(users[j].CreatedAt)
+	})
+	return paginate(users, limit, offset), nil
+}
+
+func (s *MemoryStorage) Stats(ctx context.Context) (models.Stats, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	stats := models.Stats{
+		URLs:            len(s.m),
+		RegisteredUsers: len(s.users),
+		APIKeys:         len(s.apiKeys),
+	}
+	owners := make(map[string]struct{})
+	for _, u := range s.m {
+		if u.DeletedFlag {
+			stats.DeletedURLs++
+		}
+		if u.Disabled {
+			stats.DisabledURLs++
+		}
+		if u.UUID != "" {
+			owners[u.UUID] = struct{}{}
+		}
+	}
+	stats.Users = len(owners)
+	return stats, nil
+}
+
+func toSet(ids []string) map[string]struct{} {
+	set := make(map[string]struct{}, len(ids))
+	for _, id := range ids {
+		set[id] = struct{}{}
+	}
+	return set
+}
+
+// paginate returns part of sorted items, limit <= 0 means no limit
+func paginate[T any](items []T, limit, offset int) []T {
+	if offset >= len(items) {
+		return items[:0]
+	}
+	if offset > 0 {
+		items = items[offset:]
+	}
+	if limit > 0 && limit < len(items) {
+		items = items[:limit]
+	}
+	return items
+}
+
+// InsertSession saves session, session with the same id is replaced
+func (s *MemoryStorage) InsertSession(ctx context.Context, session models.Session) error {
+	s.sessionsMu.Lock()
+	defer s.sessionsMu.Unlock()
+	s.sessions[session.ID] = session
+	return nil
+}
+
+func (s *MemoryStorage) GetSessionsByUUID(ctx context.Context, uuid string) ([]models.Session, error) {
+	s.sessionsMu.RLock()
+	defer s.sessionsMu.RUnlock()
+	sessions := make([]models.Session, 0)
+	for _, session := range s.sessions {
+		if session.UUID == uuid {
+			sessions = append(sessions, session)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].IssuedAt.Before(sessions[j].IssuedAt)
+	})
+	return sessions, nil
+}
+
+// RevokeSession marks session revoked, unknown sessions are saved as revoked
+func (s *MemoryStorage) RevokeSession(ctx context.Context, session models.Session) error {
+	s.revokeSession(session)
+	return nil
+}
+
+func (s *MemoryStorage) revokeSession(session models.Session) models.Session {
+	s.sessionsMu.Lock()
+	defer s.sessionsMu.Unlock()
+	if existing, ok := s.sessions[session.ID]; ok {
+		session = existing
+	}
+	session.Revoked = true
+	s.sessions[session.ID] = session
+	return session
+}
+
+func (s *MemoryStorage) GetRevokedSessions(ctx context.Context, expiresAfter time.Time) ([]models.Session, error) {
+	s.sessionsMu.RLock()
+	defer s.sessionsMu.RUnlock()
+	sessions := make([]models.Session, 0)
+	for _, session := range s.sessions {
+		if session.Revoked && session.ExpiresAt.After(expiresAfter) {
+			sessions = append(sessions, session)
+		}
+	}
+	return sessions, nil
+}
+
+func (s *MemoryStorage) DeleteExpiredSessions(ctx context.Context, expiredBefore time.Time) (int, error) {
+	s.sessionsMu.Lock()
+	defer s.sessionsMu.Unlock()
+	removed := 0
+	for id, session := range s.sessions {
+		if session.ExpiresAt.Before(expiredBefore) {
+			delete(s.sessions, id)
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+func (s *MemoryStorage) InsertOrganization(ctx context.Context, org models.Organization) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.orgs[org.ID] = org
+	return nil
+}
+
+func (s *MemoryStorage) GetOrganization(ctx context.Context, id string) (models.Organization, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	org, ok := s.orgs[id]
+	return org, ok
+}
+
+// SetMembership sets role of the user in organization, empty role removes the user
+func (s *MemoryStorage) SetMembership(ctx context.Context, m models.Membership) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if m.Role == "" {
+		delete(s.members[m.OrgID], m.UUID)
+		return nil
+	}
+	if _, ok := s.members[m.OrgID]; !ok {
+		s.members[m.OrgID] = make(map[string]models.OrgRole)
+	}
+	s.members[m.OrgID][m.UUID] = m.Role
+	return nil
+}
+
+func (s *MemoryStorage) GetMembershipsByUUID(ctx context.Context, uuid string) ([]models.Membership, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	memberships := make([]models.Membership, 0)
+	for orgID, members := range s.members {
+		if role, ok := members[uuid]; ok {
+			memberships = append(memberships, models.Membership{OrgID: orgID, UUID: uuid, Role: role})
+		}
+	}
+	sort.Slice(memberships, func(i, j int) bool {
+		return memberships[i].OrgID < memberships[j].OrgID
+	})
+	return memberships, nil
+}
+
+func (s *MemoryStorage) GetOrgMembers(ctx context.Context, orgID string) ([]models.Membership, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	memberships := make([]models.Membership, 0, len(s.members[orgID]))
+	for uuid, role := range s.members[orgID] {
+		memberships = append(memberships, models.Membership{OrgID: orgID, UUID: uuid, Role: role})
+	}
+	sort.Slice(memberships, func(i, j int) bool {
+		return memberships[i].UUID < memberships[j].UUID
+	})
+	return memberships, nil
+}
+
+func (s *MemoryStorage) GetURLsByOrg(ctx context.Context, orgID string) ([]models.ShortURL, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	urls := make([]models.ShortURL, 0)
+	for _, u := range s.m {
+		if u.OrgID == orgID {
+			urls = append(urls, u)
+		}
+	}
+	return urls, nil
+}
+
+// SetURLsOrg moves links created by the user to organization
+func (s *MemoryStorage) SetURLsOrg(ctx context.Context, ids []string, uuid, orgID string) (int, error) {
+	return len(s.setURLsOrg(ids, uuid, orgID)), nil
+}
+
+func (s *MemoryStorage) setURLsOrg(ids []string, uuid, orgID string) []models.ShortURL {
+	set := toSet(ids)
+	return s.updateURLs(func(u *models.ShortURL) bool {
+		// Ссылки организации не переносятся, даже если пользователь их создал
+		if _, ok := set[u.ID]; !ok || u.UUID != uuid || u.OrgID != "" {
+			return false
+		}
+		u.OrgID = orgID
+		return true
+	})
+}
@@ -57,9 +57,182 @@ func TestSafeMap(t *testing.T) {
 			}
 			urlObjLoaded, ok := m.GetURLByID(context.Background(), tt.inputID)
 
-			assert.Equal(t, tt.want.url, m.m[tt.inputID])
+			assert.Equal(t, tt.want.url, m.m[tt.inputID].OriginalURL)
 			assert.Equal(t, tt.want.url, urlObjLoaded.OriginalURL)
 			assert.Equal(t, true, ok)
 		})
 	}
 }
+
+func TestMemoryStorage_Deletion(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemoryStorage()
+	assert.NoError(t, m.InsertURLMany(ctx, []models.ShortURL{
+		{ID: "a", OriginalURL: "a.com", UUID: "owner"},
+		{ID: "b", OriginalURL: "b.com", UUID: "owner"},
+		{ID: "c", OriginalURL: "c.com", UUID: "other"},
+	}))
+
+	// Чужие ссылки не удаляются
+	assert.NoError(t, m.TagURLsDeleted(ctx, []models.Deletion{
+		{UserID: "owner", URLID: "a"},
+		{UserID: "owner", URLID: "c"},
+	}))
+	a, _ := m.GetURLByID(ctx, "a")
+	c, _ := m.GetURLByID(ctx, "c")
+	assert.True(t, a.DeletedFlag)
+	assert.False(t, c.DeletedFlag)
+
+	// Запрос чужого пользователя в той же пачке не отменяет удаление владельцем
+	assert.NoError(t, m.TagURLsDeleted(ctx, []models.Deletion{
+		{UserID: "owner", URLID: "b"},
+		{UserID: "other", URLID: "b"},
+	}))
+	b, _ := m.GetURLByID(ctx, "b")
+	assert.True(t, b.DeletedFlag)
+
+	// Удаление администратором не проверяет владельца
+	assert.NoError(t, m.TagURLsDeletedByID(ctx, []string{"c"}))
+	c, _ = m.GetURLByID(ctx, "c")
+	assert.True(t, c.DeletedFlag)
+
+	stats, err := m.Stats(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, models.Stats{URLs: 3, DeletedURLs: 3, Users: 2}, stats)
+}
+
+func TestMemoryStorage_SearchURLs(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemoryStorage()
+	assert.NoError(t, m.InsertURLMany(ctx, []models.ShortURL{
+		{ID: "a", OriginalURL: "example.com/1", UUID: "u1"},
+		{ID: "b", OriginalURL: "example.com/2", UUID: "u2"},
+		{ID: "c", OriginalURL: "other.org", UUID: "u1"},
+	}))
+
+	urls, err := m.SearchURLs(ctx, models.URLFilter{Query: "example"})
+	assert.NoError(t, err)
+	assert.Len(t, urls, 2)
+
+	urls, err = m.SearchURLs(ctx, models.URLFilter{UUID: "u1", Limit: 1, Offset: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, []models.ShortURL{{ID: "c", OriginalURL: "other.org", UUID: "u1"}}, urls)
+
+	urls, err = m.SearchURLs(ctx, models.URLFilter{Query: "b"})
+	assert.NoError(t, err)
+	assert.Len(t, urls, 1)
+}
+
+func TestMemoryStorage_OrgOwnership(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemoryStorage()
+	assert.NoError(t, m.InsertURLMany(ctx, []models.ShortURL{
+		{ID: "a", OriginalURL: "a.com", UUID: "creator", OrgID: "org"},
+		{ID: "b", OriginalURL: "b.com", UUID: "creator", OrgID: "org"},
+		{ID: "c", OriginalURL: "c.com", UUID: "creator"},
+	}))
+	assert.NoError(t, m.SetMembership(ctx, models.Membership{OrgID: "org", UUID: "creator", Role: models.RoleOwner}))
+	assert.NoError(t, m.SetMembership(ctx, models.Membership{OrgID: "org", UUID: "editor", Role: models.RoleEditor}))
+	assert.NoError(t, m.SetMembership(ctx, models.Membership{OrgID: "org", UUID: "viewer", Role: models.RoleViewer}))
+
+	// Ссылки организации видны всем участникам, личные ссылки создателя - нет
+	urls, err := m.GetURLsByUUID(ctx, "viewer")
+	assert.NoError(t, err)
+	assert.Len(t, urls, 2)
+
+	// Читатель не может удалять ссылки организации, редактор может, но не личные ссылки создателя
+	assert.NoError(t, m.TagURLsDeleted(ctx, []models.Deletion{
+		{UserID: "viewer", URLID: "a"},
+		{UserID: "editor", URLID: "b"},
+		{UserID: "editor", URLID: "c"},
+	}))
+	a, _ := m.GetURLByID(ctx, "a")
+	b, _ := m.GetURLByID(ctx, "b")
+	c, _ := m.GetURLByID(ctx, "c")
+	assert.False(t, a.DeletedFlag)
+	assert.True(t, b.DeletedFlag)
+	assert.False(t, c.DeletedFlag)
+
+	// После удаления из организации ссылки больше не видны
+	assert.NoError(t, m.SetMembership(ctx, models.Membership{OrgID: "org", UUID: "viewer"}))
+	urls, err = m.GetURLsByUUID(ctx, "viewer")
+	assert.NoError(t, err)
+	assert.Empty(t, urls)
+
+	n, err := m.SetURLsOrg(ctx, []string{"c"}, "editor", "org")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+	n, err = m.SetURLsOrg(ctx, []string{"c"}, "creator", "org")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+}
+
+func TestMemoryStorage_OrgLinksOfFormerEditors(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemoryStorage()
+	// Ссылки создали участники, которых потом понизили до читателя или удалили из организации
+	assert.NoError(t, m.InsertURLMany(ctx, []models.ShortURL{
+		{ID: "by-viewer", OriginalURL: "a.com", UUID: "viewer", OrgID: "org"},
+		{ID: "by-removed", OriginalURL: "b.com", UUID: "removed", OrgID: "org"},
+	}))
+	assert.NoError(t, m.SetMembership(ctx, models.Membership{OrgID: "org", UUID: "owner", Role: models.RoleOwner}))
+	assert.NoError(t, m.SetMembership(ctx, models.Membership{OrgID: "org", UUID: "viewer", Role: models.RoleViewer}))
+	assert.NoError(t, m.SetMembership(ctx, models.Membership{OrgID: "other", UUID: "removed", Role: models.RoleOwner}))
+
+	assert.NoError(t, m.TagURLsDeleted(ctx, []models.Deletion{
+		{UserID: "viewer", URLID: "by-viewer"},
+		{UserID: "removed", URLID: "by-removed"},
+	}))
+	for _, id := range []string{"by-viewer", "by-removed"} {
+		u, _ := m.GetURLByID(ctx, id)
+		assert.False(t, u.DeletedFlag, id)
+	}
+
+	// Читатель видит ссылки организации как участник, бывший участник их больше не видит
+	urls, err := m.GetURLsByUUID(ctx, "viewer")
+	assert.NoError(t, err)
+	assert.Len(t, urls, 2)
+	urls, err = m.GetURLsByUUID(ctx, "removed")
+	assert.NoError(t, err)
+	assert.Empty(t, urls)
+
+	// Создатель не может увести ссылку в свою организацию
+	n, err := m.SetURLsOrg(ctx, []string{"by-removed"}, "removed", "other")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+}
+
+func TestMemoryStorage_SetURLRules(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemoryStorage()
+	assert.NoError(t, m.InsertURLMany(ctx, []models.ShortURL{{ID: "a", OriginalURL: "a.com", UUID: "owner"}}))
+
+	rules := []models.RedirectRule{{OS: models.OSiOS, URL: "https://apps.apple.com/app/id1"}}
+	assert.Len(t, m.setURLRules("a", rules), 1)
+	a, _ := m.GetURLByID(ctx, "a")
+	assert.Equal(t, rules, a.Rules)
+
+	// Для неизвестной ссылки нечего дописывать в файл
+	assert.Empty(t, m.setURLRules("missing", rules))
+}
+
+func TestMemoryStorage_APIKeys(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemoryStorage()
+	assert.NoError(t, m.InsertAPIKey(ctx, models.APIKey{ID: "k1", UUID: "owner", Hash: "h1"}))
+
+	k, ok, err := m.GetAPIKeyByHash(ctx, "h1")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "k1", k.ID)
+
+	// Ключ с тем же id заменяет прежний вместе с его хешем
+	assert.NoError(t, m.InsertAPIKey(ctx, models.APIKey{ID: "k1", UUID: "owner", Hash: "h2"}))
+	_, ok, _ = m.GetAPIKeyByHash(ctx, "h1")
+	assert.False(t, ok)
+
+	assert.NoError(t, m.RevokeAPIKey(ctx, "k1", "owner"))
+	k, ok, _ = m.GetAPIKeyByHash(ctx, "h2")
+	assert.True(t, ok)
+	assert.True(t, k.Revoked)
+}
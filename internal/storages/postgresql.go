@@ -3,26 +3,36 @@ package storages
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"time"
 
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
 	_ "github.com/jackc/pgx/v5/stdlib"
 
 	"github.com/maxzhirnov/urlshort/internal/models"
 )
 
+type logger interface {
+	Error(string, ...interface{})
+}
+
 type Postgresql struct {
-	DB *sql.DB
+	DB     *sql.DB
+	logger logger
 }
 
-func NewPostgresql(conn string) (*Postgresql, error) {
+func NewPostgresql(conn string, logger logger) (*Postgresql, error) {
 	db, err := sql.Open("pgx", conn)
 	if err != nil {
 		return nil, err
 	}
 
 	return &Postgresql{
-		DB: db,
+		DB:     db,
+		logger: logger,
 	}, nil
 }
 
@@ -34,8 +44,8 @@ func (s Postgresql) InsertURL(ctx context.Context, shortURL models.ShortURL) (mo
 	}
 
 	stmt, err := tx.PrepareContext(ctx, `
-	INSERT INTO short_urls(id, original_url, uuid, updated_at) 
-	VALUES ($1, $2, $3, NOW()) 
+	INSERT INTO short_urls(id, original_url, uuid, org_id, redirect_code, cache_control, referrer_policy, query_mode, path_mode, updated_at) 
+	VALUES ($1, $2, $3, NULLIF($4, '')::uuid, $5, $6, $7, $8, $9, NOW()) 
 	ON CONFLICT (original_url) DO UPDATE SET updated_at = NOW()
 	RETURNING id, original_url, updated_at, uuid, (xmax = 0) AS is_inserted;
 	`)
@@ -44,7 +54,8 @@ func (s Postgresql) InsertURL(ctx context.Context, shortURL models.ShortURL) (mo
 	}
 	defer stmt.Close()
 
-	row := stmt.QueryRowContext(ctx, shortURL.ID, shortURL.OriginalURL, shortURL.UUID)
+	row := stmt.QueryRowContext(ctx, shortURL.ID, shortURL.OriginalURL, shortURL.UUID, shortURL.OrgID,
+		shortURL.RedirectCode, shortURL.CacheControl, shortURL.ReferrerPolicy, shortURL.QueryMode, shortURL.PathMode)
 	if row.Err() != nil {
 		tx.Rollback()
 		return models.ShortURL{}, fmt.Errorf("something went wrong")
@@ -73,7 +84,9 @@ func (s Postgresql) InsertURLMany(ctx context.Context, urls []models.ShortURL) e
 		return err
 	}
 
-	stmt, err := tx.PrepareContext(ctx, "INSERT INTO short_urls(id, original_url, uuid, updated_at) VALUES ($1, $2, $3, NOW()) ON CONFLICT DO NOTHING ")
+	stmt, err := tx.PrepareContext(ctx, `
+	INSERT INTO short_urls(id, original_url, uuid, org_id, redirect_code, cache_control, referrer_policy, query_mode, path_mode, updated_at)
+	VALUES ($1, $2, $3, NULLIF($4, '')::uuid, $5, $6, $7, $8, $9, NOW()) ON CONFLICT DO NOTHING`)
 	if err != nil {
 		tx.Rollback()
 		return err
@@ -81,7 +94,8 @@ func (s Postgresql) InsertURLMany(ctx context.Context, urls []models.ShortURL) e
 	defer stmt.Close()
 
 	for _, url := range urls {
-		if _, err := stmt.ExecContext(ctx, url.ID, url.OriginalURL, url.UUID); err != nil {
+		if _, err := stmt.ExecContext(ctx, url.ID, url.OriginalURL, url.UUID, url.OrgID,
+			url.RedirectCode, url.CacheControl, url.ReferrerPolicy, url.QueryMode, url.PathMode); err != nil {
 			tx.Rollback()
 			return err
 		}
@@ -99,7 +113,9 @@ func (s Postgresql) TagURLsDeleted(ctx context.Context, urlsToDelete []models.De
 	stmt, err := tx.PrepareContext(ctx, `
 UPDATE short_urls
 SET deleted_flag = true
-WHERE id = $1 AND uuid = $2;
+WHERE id = $1 AND ((org_id IS NULL AND uuid = $2) OR org_id IN (
+	SELECT org_id FROM org_members WHERE uuid = $2 AND role IN ('editor', 'owner')
+));
 `)
 	if err != nil {
 		tx.Rollback()
@@ -118,12 +134,22 @@ WHERE id = $1 AND uuid = $2;
 }
 
 func (s Postgresql) GetURLByID(ctx context.Context, id string) (models.ShortURL, bool) {
-	row := s.DB.QueryRowContext(ctx, `SELECT id, original_url, uuid, deleted_flag FROM short_urls WHERE id=$1`, id)
+	row := s.DB.QueryRowContext(ctx, `
+	SELECT id, original_url, uuid, deleted_flag, disabled, COALESCE(org_id::text, ''),
+		redirect_code, cache_control, referrer_policy, query_mode, path_mode, redirect_rules
+	FROM short_urls WHERE id=$1`, id)
 	shortURL := models.ShortURL{}
-	err := row.Scan(&shortURL.ID, &shortURL.OriginalURL, &shortURL.UUID, &shortURL.DeletedFlag)
+	var rules []byte
+	err := row.Scan(&shortURL.ID, &shortURL.OriginalURL, &shortURL.UUID, &shortURL.DeletedFlag, &shortURL.Disabled, &shortURL.OrgID,
+		&shortURL.RedirectCode, &shortURL.CacheControl, &shortURL.ReferrerPolicy, &shortURL.QueryMode, &shortURL.PathMode, &rules)
 	if err != nil {
 		return shortURL, false
 	}
+	if err := json.Unmarshal(rules, &shortURL.Rules); err != nil {
+		// Испорченные правила не должны ломать саму ссылку, она ведет на original_url
+		s.logger.Error("couldn't decode redirect rules, serving link without them", "id", id, "error", err)
+		shortURL.Rules = nil
+	}
 	return shortURL, true
 }
 
@@ -137,8 +163,12 @@ func (s Postgresql) GetURLByOriginalURL(ctx context.Context, url string) (models
 	return shortURL, true
 }
 
+// GetURLsByUUID returns links created by the user and links of organizations the user is member of
 func (s Postgresql) GetURLsByUUID(ctx context.Context, uuid string) ([]models.ShortURL, error) {
-	rows, err := s.DB.QueryContext(ctx, `SELECT id, original_url FROM short_urls WHERE uuid=$1`, uuid)
+	rows, err := s.DB.QueryContext(ctx, `
+	SELECT id, original_url, COALESCE(org_id::text, '') FROM short_urls
+	WHERE (org_id IS NULL AND uuid=$1) OR org_id IN (SELECT org_id FROM org_members WHERE uuid=$1)
+	`, uuid)
 	if err != nil {
 		return nil, err
 	}
@@ -147,7 +177,7 @@ func (s Postgresql) GetURLsByUUID(ctx context.Context, uuid string) ([]models.Sh
 	shortURLs := make([]models.ShortURL, 0)
 	for rows.Next() {
 		url := models.ShortURL{}
-		if err := rows.Scan(&url.ID, &url.OriginalURL); err != nil {
+		if err := rows.Scan(&url.ID, &url.OriginalURL, &url.OrgID); err != nil {
 			return nil, err
 		}
 		shortURLs = append(shortURLs, url)
@@ -172,6 +202,31 @@ func (s Postgresql) Bootstrap() error {
 		return err
 	}
 
+	// Добавляем колонки, появившиеся после создания таблицы short_urls
+	if err := s.migrateShortURLs(); err != nil {
+		return err
+	}
+
+	// Создаем таблицу api_keys
+	if err := s.initAPIKeysTable(); err != nil {
+		return err
+	}
+
+	// Создаем таблицу users
+	if err := s.initUsersTable(); err != nil {
+		return err
+	}
+
+	// Создаем таблицу sessions
+	if err := s.initSessionsTable(); err != nil {
+		return err
+	}
+
+	// Создаем таблицы organizations и org_members
+	if err := s.initOrgTables(); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -210,3 +265,475 @@ func (s Postgresql) createUniqueOriginalURLIndex() error {
 
 	return nil
 }
+
+func (s Postgresql) initAPIKeysTable() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := s.DB.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS api_keys (
+									  id varchar(20) NOT NULL,
+									  uuid uuid NOT NULL,
+									  name varchar(100) NOT NULL DEFAULT '',
+									  prefix varchar(40) NOT NULL,
+									  hash char(64) NOT NULL UNIQUE,
+									  created_at TIMESTAMP DEFAULT NOW(),
+									  revoked BOOLEAN DEFAULT FALSE,
+									  PRIMARY KEY (id)) ;`); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s Postgresql) InsertAPIKey(ctx context.Context, key models.APIKey) error {
+	_, err := s.DB.ExecContext(ctx, `
+	INSERT INTO api_keys(id, uuid, name, prefix, hash, created_at, revoked)
+	VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`, key.ID, key.UUID, key.Name, key.Prefix, key.Hash, key.CreatedAt, key.Revoked)
+	return err
+}
+
+func (s Postgresql) GetAPIKeyByHash(ctx context.Context, hash string) (models.APIKey, bool, error) {
+	row := s.DB.QueryRowContext(ctx, `
+	SELECT id, uuid, name, prefix, hash, created_at, revoked FROM api_keys WHERE hash=$1
+	`, hash)
+	key := models.APIKey{}
+	err := row.Scan(&key.ID, &key.UUID, &key.Name, &key.Prefix, &key.Hash, &key.CreatedAt, &key.Revoked)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.APIKey{}, false, nil
+	}
+	if err != nil {
+		return models.APIKey{}, false, err
+	}
+	return key, true, nil
+}
+
+func (s Postgresql) GetAPIKeysByUUID(ctx context.Context, uuid string) ([]models.APIKey, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+	SELECT id, uuid, name, prefix, hash, created_at, revoked FROM api_keys WHERE uuid=$1 ORDER BY created_at
+	`, uuid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := make([]models.APIKey, 0)
+	for rows.Next() {
+		key := models.APIKey{}
+		if err := rows.Scan(&key.ID, &key.UUID, &key.Name, &key.Prefix, &key.Hash, &key.CreatedAt, &key.Revoked); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+func (s Postgresql) RevokeAPIKey(ctx context.Context, id, uuid string) error {
+	res, err := s.DB.ExecContext(ctx, `UPDATE api_keys SET revoked = true WHERE id = $1 AND uuid = $2`, id, uuid)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s Postgresql) initUsersTable() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := s.DB.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS users (
+									  id uuid NOT NULL,
+									  login varchar(100) NOT NULL UNIQUE,
+									  password_hash varchar(100) NOT NULL,
+									  external_id varchar(450) UNIQUE,
+									  created_at TIMESTAMP DEFAULT NOW(),
+									  PRIMARY KEY (id)) ;`); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s Postgresql) InsertUser(ctx context.Context, user models.User) error {
+	_, err := s.DB.ExecContext(ctx, `
+	INSERT INTO users(id, login, password_hash, external_id, created_at)
+	VALUES ($1, $2, $3, NULLIF($4, ''), $5)
+	`, user.ID, user.Login, user.PasswordHash, user.ExternalID, user.CreatedAt)
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
+		return ErrEntityAlreadyExist
+	}
+	return err
+}
+
+func (s Postgresql) GetUserByID(ctx context.Context, id string) (models.User, bool) {
+	return s.getUserBy(ctx, "id", id)
+}
+
+func (s Postgresql) GetUserByLogin(ctx context.Context, login string) (models.User, bool) {
+	return s.getUserBy(ctx, "login", login)
+}
+
+func (s Postgresql) GetUserByExternalID(ctx context.Context, externalID string) (models.User, bool) {
+	return s.getUserBy(ctx, "external_id", externalID)
+}
+
+// getUserBy selects user by value of the column, column is never taken from user input
+func (s Postgresql) getUserBy(ctx context.Context, column, value string) (models.User, bool) {
+	row := s.DB.QueryRowContext(ctx, `
+	SELECT id, login, password_hash, COALESCE(external_id, ''), created_at FROM users WHERE `+column+`=$1
+	`, value)
+	user := models.User{}
+	if err := row.Scan(&user.ID, &user.Login, &user.PasswordHash, &user.ExternalID, &user.CreatedAt); err != nil {
+		return user, false
+	}
+	return user, true
+}
+
+func (s Postgresql) ReassignURLs(ctx context.Context, fromUUID, toUUID string) (int, error) {
+	res, err := s.DB.ExecContext(ctx, `UPDATE short_urls SET uuid = $2 WHERE uuid = $1`, fromUUID, toUUID)
+	if err != nil {
+		return 0, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(affected), nil
+}
+
+func (s Postgresql) migrateShortURLs() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := s.DB.ExecContext(ctx, `ALTER TABLE short_urls ADD COLUMN IF NOT EXISTS disabled BOOLEAN DEFAULT FALSE`); err != nil {
+		return err
+	}
+	if _, err := s.DB.ExecContext(ctx, `ALTER TABLE short_urls ADD COLUMN IF NOT EXISTS org_id uuid`); err != nil {
+		return err
+	}
+	// 0 и пустые строки означают настройки редиректа по умолчанию
+	if _, err := s.DB.ExecContext(ctx, `
+	ALTER TABLE short_urls
+		ADD COLUMN IF NOT EXISTS redirect_code SMALLINT NOT NULL DEFAULT 0,
+		ADD COLUMN IF NOT EXISTS cache_control TEXT NOT NULL DEFAULT '',
+		ADD COLUMN IF NOT EXISTS referrer_policy TEXT NOT NULL DEFAULT '',
+		ADD COLUMN IF NOT EXISTS query_mode TEXT NOT NULL DEFAULT '',
+		ADD COLUMN IF NOT EXISTS path_mode TEXT NOT NULL DEFAULT ''`); err != nil {
+		return err
+	}
+	if _, err := s.DB.ExecContext(ctx, `ALTER TABLE short_urls ADD COLUMN IF NOT EXISTS redirect_rules JSONB NOT NULL DEFAULT '[]'`); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s Postgresql) TagURLsDeletedByID(ctx context.Context, ids []string) error {
+	_, err := s.DB.ExecContext(ctx, `UPDATE short_urls SET deleted_flag = true WHERE id = ANY($1)`, ids)
+	return err
+}
+
+func (s Postgresql) SetURLsDisabled(ctx context.Context, ids []string, disabled bool) error {
+	_, err := s.DB.ExecContext(ctx, `UPDATE short_urls SET disabled = $2 WHERE id = ANY($1)`, ids, disabled)
+	return err
+}
+
+func (s Postgresql) SetURLRules(ctx context.Context, id string, rules []models.RedirectRule) error {
+	if rules == nil {
+		rules = []models.RedirectRule{}
+	}
+	data, err := json.Marshal(rules)
+	if err != nil {
+		return err
+	}
+	_, err = s.DB.ExecContext(ctx, `UPDATE short_urls SET redirect_rules = $2 WHERE id = $1`, id, string(data))
+	return err
+}
+
+// SearchURLs matches query as plain substring, strpos is used instead of LIKE so % and _ in query aren't wildcards
+func (s Postgresql) SearchURLs(ctx context.Context, filter models.URLFilter) ([]models.ShortURL, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+	SELECT id, original_url, COALESCE(uuid::text, ''), deleted_flag, disabled FROM short_urls
+	WHERE ($1 = '' OR id = $1 OR strpos(original_url, $1) > 0)
+	  AND ($2 = '' OR uuid::text = $2)
+	ORDER BY id
+	LIMIT NULLIF($3, 0) OFFSET $4
+	`, filter.Query, filter.UUID, filter.Limit, filter.Offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	urls := make([]models.ShortURL, 0)
+	for rows.Next() {
+		url := models.ShortURL{}
+		if err := rows.Scan(&url.ID, &url.OriginalURL, &url.UUID, &url.DeletedFlag, &url.Disabled); err != nil {
+			return nil, err
+		}
+		urls = append(urls, url)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return urls, nil
+}
+
+// ListUsers matches query as plain substring of login like SearchURLs
+func (s Postgresql) ListUsers(ctx context.Context, query string, limit, offset int) ([]models.User, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+	SELECT id, login, password_hash, COALESCE(external_id, ''), created_at FROM users
+	WHERE $1 = '' OR id::text = $1 OR strpos(login, $1) > 0
+	ORDER BY created_at
+	LIMIT NULLIF($2, 0) OFFSET $3
+	`, query, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := make([]models.User, 0)
+	for rows.Next() {
+		user := models.User{}
+		if err := rows.Scan(&user.ID, &user.Login, &user.PasswordHash, &user.ExternalID, &user.CreatedAt); err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+func (s Postgresql) Stats(ctx context.Context) (models.Stats, error) {
+	stats := models.Stats{}
+	row := s.DB.QueryRowContext(ctx, `
+	SELECT
+		COUNT(*),
+		COUNT(*) FILTER (WHERE deleted_flag),
+		COUNT(*) FILTER (WHERE disabled),
+		COUNT(DISTINCT uuid),
+		(SELECT COUNT(*) FROM users),
+		(SELECT COUNT(*) FROM api_keys)
+	FROM short_urls
+	`)
+	err := row.Scan(&stats.URLs, &stats.DeletedURLs, &stats.DisabledURLs, &stats.Users, &stats.RegisteredUsers, &stats.APIKeys)
+	return stats, err
+}
+
+func (s Postgresql) initSessionsTable() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := s.DB.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS sessions (
+									  id uuid NOT NULL,
+									  uuid uuid NOT NULL,
+									  user_agent varchar(450) NOT NULL DEFAULT '',
+									  issued_at TIMESTAMP NOT NULL,
+									  expires_at TIMESTAMP NOT NULL,
+									  revoked BOOLEAN DEFAULT FALSE,
+									  PRIMARY KEY (id)) ;`); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s Postgresql) InsertSession(ctx context.Context, session models.Session) error {
+	_, err := s.DB.ExecContext(ctx, `
+	INSERT INTO sessions(id, uuid, user_agent, issued_at, expires_at, revoked)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	`, session.ID, session.UUID, session.UserAgent, session.IssuedAt, session.ExpiresAt, session.Revoked)
+	return err
+}
+
+func (s Postgresql) GetSessionsByUUID(ctx context.Context, uuid string) ([]models.Session, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+	SELECT id, uuid, user_agent, issued_at, expires_at, revoked FROM sessions WHERE uuid=$1 ORDER BY issued_at
+	`, uuid)
+	if err != nil {
+		return nil, err
+	}
+	return scanSessions(rows)
+}
+
+// RevokeSession marks session revoked, unknown sessions are saved as revoked
+func (s Postgresql) RevokeSession(ctx context.Context, session models.Session) error {
+	_, err := s.DB.ExecContext(ctx, `
+	INSERT INTO sessions(id, uuid, user_agent, issued_at, expires_at, revoked)
+	VALUES ($1, $2, $3, $4, $5, true)
+	ON CONFLICT (id) DO UPDATE SET revoked = true
+	`, session.ID, session.UUID, session.UserAgent, session.IssuedAt, session.ExpiresAt)
+	return err
+}
+
+func (s Postgresql) GetRevokedSessions(ctx context.Context, expiresAfter time.Time) ([]models.Session, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+	SELECT id, uuid, user_agent, issued_at, expires_at, revoked FROM sessions WHERE revoked AND expires_at > $1
+	`, expiresAfter)
+	if err != nil {
+		return nil, err
+	}
+	return scanSessions(rows)
+}
+
+func (s Postgresql) DeleteExpiredSessions(ctx context.Context, expiredBefore time.Time) (int, error) {
+	res, err := s.DB.ExecContext(ctx, `DELETE FROM sessions WHERE expires_at < $1`, expiredBefore)
+	if err != nil {
+		return 0, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(affected), nil
+}
+
+func scanSessions(rows *sql.Rows) ([]models.Session, error) {
+	defer rows.Close()
+	sessions := make([]models.Session, 0)
+	for rows.Next() {
+		session := models.Session{}
+		if err := rows.Scan(&session.ID, &session.UUID, &session.UserAgent, &session.IssuedAt, &session.ExpiresAt, &session.Revoked); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+func (s Postgresql) initOrgTables() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := s.DB.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS organizations (
+									  id uuid NOT NULL,
+									  name varchar(100) NOT NULL,
+									  created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+									  PRIMARY KEY (id)) ;`); err != nil {
+		return err
+	}
+	if _, err := s.DB.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS org_members (
+									  org_id uuid NOT NULL,
+									  uuid uuid NOT NULL,
+									  role varchar(10) NOT NULL,
+									  PRIMARY KEY (org_id, uuid)) ;`); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s Postgresql) InsertOrganization(ctx context.Context, org models.Organization) error {
+	_, err := s.DB.ExecContext(ctx, `INSERT INTO organizations(id, name, created_at) VALUES ($1, $2, $3)`,
+		org.ID, org.Name, org.CreatedAt)
+	return err
+}
+
+func (s Postgresql) GetOrganization(ctx context.Context, id string) (models.Organization, bool) {
+	row := s.DB.QueryRowContext(ctx, `SELECT id, name, created_at FROM organizations WHERE id::text=$1`, id)
+	org := models.Organization{}
+	if err := row.Scan(&org.ID, &org.Name, &org.CreatedAt); err != nil {
+		return org, false
+	}
+	return org, true
+}
+
+// SetMembership sets role of the user in organization, empty role removes the user
+func (s Postgresql) SetMembership(ctx context.Context, m models.Membership) error {
+	if m.Role == "" {
+		_, err := s.DB.ExecContext(ctx, `DELETE FROM org_members WHERE org_id = $1 AND uuid = $2`, m.OrgID, m.UUID)
+		return err
+	}
+	_, err := s.DB.ExecContext(ctx, `
+	INSERT INTO org_members(org_id, uuid, role) VALUES ($1, $2, $3)
+	ON CONFLICT (org_id, uuid) DO UPDATE SET role = $3
+	`, m.OrgID, m.UUID, m.Role)
+	return err
+}
+
+func (s Postgresql) GetMembershipsByUUID(ctx context.Context, uuid string) ([]models.Membership, error) {
+	rows, err := s.DB.QueryContext(ctx, `SELECT org_id, uuid, role FROM org_members WHERE uuid=$1 ORDER BY org_id`, uuid)
+	if err != nil {
+		return nil, err
+	}
+	return scanMemberships(rows)
+}
+
+func (s Postgresql) GetOrgMembers(ctx context.Context, orgID string) ([]models.Membership, error) {
+	rows, err := s.DB.QueryContext(ctx, `SELECT org_id, uuid, role FROM org_members WHERE org_id::text=$1 ORDER BY uuid`, orgID)
+	if err != nil {
+		return nil, err
+	}
+	return scanMemberships(rows)
+}
+
+func (s Postgresql) GetURLsByOrg(ctx context.Context, orgID string) ([]models.ShortURL, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+	SELECT id, original_url, uuid, deleted_flag, disabled, org_id FROM short_urls WHERE org_id::text=$1 ORDER BY id
+	`, orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	urls := make([]models.ShortURL, 0)
+	for rows.Next() {
+		url := models.ShortURL{}
+		if err := rows.Scan(&url.ID, &url.OriginalURL, &url.UUID, &url.DeletedFlag, &url.Disabled, &url.OrgID); err != nil {
+			return nil, err
+		}
+		urls = append(urls, url)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return urls, nil
+}
+
+// SetURLsOrg moves links created by the user to organization
+func (s Postgresql) SetURLsOrg(ctx context.Context, ids []string, uuid, orgID string) (int, error) {
+	res, err := s.DB.ExecContext(ctx, `
+	UPDATE short_urls SET org_id = $3 WHERE id = ANY($1) AND uuid = $2 AND org_id IS NULL
+	`, ids, uuid, orgID)
+	if err != nil {
+		return 0, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(affected), nil
+}
+
+func scanMemberships(rows *sql.Rows) ([]models.Membership, error) {
+	defer rows.Close()
+	memberships := make([]models.Membership, 0)
+	for rows.Next() {
+		m := models.Membership{}
+		if err := rows.Scan(&m.OrgID, &m.UUID, &m.Role); err != nil {
+			return nil, err
+		}
+		memberships = append(memberships, m)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return memberships, nil
+}
@@ -0,0 +1,72 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestSetup_FileExporter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traces.json")
+	shutdown, err := Setup(context.Background(), Config{
+		Exporter:    ExporterFile,
+		FilePath:    path,
+		SampleRatio: 1,
+	})
+	require.NoError(t, err)
+
+	// Входящий traceparent продолжает трейс вызывающей стороны
+	header := http.Header{}
+	header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ctx := otel.GetTextMapPropagator().Extract(context.Background(), propagation.HeaderCarrier(header))
+
+	_, span := otel.Tracer("test").Start(ctx, "redirect")
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", span.SpanContext().TraceID().String())
+	span.End()
+
+	require.NoError(t, shutdown(context.Background()))
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"Name":"redirect"`)
+	assert.Contains(t, string(data), "4bf92f3577b34da6a3ce929d0e0e4736")
+}
+
+func TestSetup_Errors(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+	}{
+		{name: "unknown exporter", cfg: Config{Exporter: "jaeger"}},
+		{name: "file exporter without path", cfg: Config{Exporter: ExporterFile}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Setup(context.Background(), tt.cfg)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestSetup_Disabled(t *testing.T) {
+	shutdown, err := Setup(context.Background(), Config{})
+	require.NoError(t, err)
+	assert.NoError(t, shutdown(context.Background()))
+
+	header := http.Header{}
+	ctx := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{1},
+		SpanID:     trace.SpanID{1},
+		TraceFlags: trace.FlagsSampled,
+	}))
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(header))
+	assert.NotEmpty(t, header.Get("traceparent"))
+}